@@ -0,0 +1,116 @@
+package zaphelper
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ensure we always implement io.WriteCloser
+var _ io.WriteCloser = (*AsyncWriter)(nil)
+
+// AsyncWriter is an io.WriteCloser that hands writes over to a single
+// background goroutine, so that Write never blocks on the underlying writer.
+// When the queue is full, writes are dropped and counted instead.
+type AsyncWriter struct {
+	// dropped is accessed atomically and kept first for 64-bit alignment.
+	dropped uint64
+
+	w     io.Writer
+	queue chan []byte
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncWriter returns an AsyncWriter writing to w through a queue holding
+// up to depth pending writes.
+func NewAsyncWriter(w io.Writer, depth int) *AsyncWriter {
+	a := &AsyncWriter{
+		w:     w,
+		queue: make(chan []byte, depth),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Write implements io.Writer.  It queues a copy of p and returns immediately,
+// dropping p if the queue is full.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return 0, errors.New("write to closed AsyncWriter")
+	}
+
+	// zap reuses its buffers once Write returns
+	b := make([]byte, len(p))
+	copy(b, p)
+	select {
+	case a.queue <- b:
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of writes dropped because the queue was full.
+func (a *AsyncWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Stats returns the counters of the underlying writer if it is a Writer, or
+// another AsyncWriter, along with the number of dropped writes.
+func (a *AsyncWriter) Stats() WriterStats {
+	var stats WriterStats
+	if s, ok := a.w.(interface {
+		Stats() WriterStats
+	}); ok {
+		stats = s.Stats()
+	}
+	stats.Dropped += a.Dropped()
+	return stats
+}
+
+// Close implements io.Closer.  It stops accepting writes, waits for the queued
+// ones to be written, and closes the underlying writer if it is an io.Closer.
+// If the queue isn't drained within ten seconds, Close gives up and returns an
+// error, leaving the underlying writer open for the pending writes.
+func (a *AsyncWriter) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+
+	timer := time.NewTimer(closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-a.done:
+	case <-timer.C:
+		return errors.Errorf("timed out after %v writing the queued entries", closeTimeout)
+	}
+	if c, ok := a.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// run writes queued entries to the underlying writer until the queue is
+// closed and drained.
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+	for b := range a.queue {
+		// Write returned long ago; the writer reports its own failures, as
+		// Writer does to its FallbackWriter
+		_, _ = a.w.Write(b)
+	}
+}
@@ -0,0 +1,101 @@
+package zaphelper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowWriter is an io.Writer that takes its time, so that AsyncWriter's queue
+// fills up.
+type slowWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	lines  int
+	closed bool
+}
+
+func (s *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Microsecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines++
+	return s.buf.Write(p)
+}
+
+func (s *slowWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestAsyncWriter(t *testing.T) {
+	sw := &slowWriter{}
+	a := NewAsyncWriter(sw, 16)
+
+	b := []byte("boo!\n")
+	n, err := a.Write(b)
+	isNil(err, t)
+	equals(len(b), n, t)
+	// the caller may reuse its buffer right away
+	b[0] = 'x'
+
+	isNil(a.Close(), t)
+	equals("boo!\n", sw.buf.String(), t)
+	equals(true, sw.closed, t)
+	equals(uint64(0), a.Dropped(), t)
+
+	_, err = a.Write(b)
+	if err == nil {
+		t.Fatal("expected an error writing to a closed AsyncWriter")
+	}
+	isNil(a.Close(), t)
+}
+
+func TestAsyncWriterDrops(t *testing.T) {
+	sw := &slowWriter{}
+	a := NewAsyncWriter(sw, 4)
+
+	const goroutines, writes = 16, 200
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if _, err := a.Write([]byte("boo!\n")); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	isNil(a.Close(), t)
+
+	if a.Dropped() == 0 {
+		t.Fatal("expected some writes to be dropped")
+	}
+	equals(uint64(goroutines*writes), uint64(sw.lines)+a.Dropped(), t)
+}
+
+func TestAsyncWriterStats(t *testing.T) {
+	dir := makeTempDir("TestAsyncWriterStats", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log")}
+	a := NewAsyncWriter(w, 1)
+	for i := 0; i < 100; i++ {
+		_, err := a.Write([]byte("boo!\n"))
+		isNil(err, t)
+	}
+	isNil(a.Close(), t)
+
+	stats := a.Stats()
+	equals(uint64(100), stats.BytesWritten/5+stats.Dropped, t)
+	equals(stats.BytesWritten, w.Stats().BytesWritten, t)
+}
@@ -0,0 +1,101 @@
+package zaphelper
+
+import (
+	"bytes"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// defaultBackupPattern is the BackupPattern used when none is set.
+const defaultBackupPattern = "{{.Name}}-{{.Time}}{{.Ext}}"
+
+// timeMark stands for the timestamp when a backupPattern is split around it.
+const timeMark = "\x00"
+
+// backupPattern is a parsed Writer.BackupPattern.
+type backupPattern struct {
+	pattern string
+	tmpl    *template.Template
+}
+
+// backupFields are the placeholders of a BackupPattern.
+type backupFields struct {
+	// Name is the logfile name without its extension.
+	Name string
+	// Ext is the extension of the logfile name, including the dot.
+	Ext string
+
+	// layouts records the layouts of the timestamps.
+	layouts *[]string
+}
+
+// Time stands for the timestamp of the backup formatted with layout, a layout
+// for time.Format which defaults to "2006-01-02T15-04-05.000".  It records
+// layout and returns timeMark, for split to replace.
+func (f backupFields) Time(layout ...string) (string, error) {
+	l := backupTimeFormat
+	switch len(layout) {
+	case 0:
+	case 1:
+		l = layout[0]
+	default:
+		return "", errors.New("Time takes at most one layout")
+	}
+	*f.layouts = append(*f.layouts, l)
+	return timeMark, nil
+}
+
+// parseBackupPattern parses pattern, or defaultBackupPattern if empty, and
+// checks that the backup names it produces can be parsed back.
+func parseBackupPattern(pattern string) (*backupPattern, error) {
+	if pattern == "" {
+		pattern = defaultBackupPattern
+	}
+	tmpl, err := template.New("BackupPattern").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad BackupPattern")
+	}
+	p := &backupPattern{pattern, tmpl}
+	before, _, after, err := p.split("app.log")
+	if err != nil {
+		return nil, err
+	}
+	if sample := before + "x" + after; path.IsAbs(sample) || path.Clean(sample) != sample || strings.HasPrefix(sample, "../") {
+		return nil, errors.Errorf("bad BackupPattern %q: backups must be named relative to the logfile's directory", pattern)
+	}
+	return p, nil
+}
+
+// split returns what p produces for the logfile name before and after the
+// timestamp, with slashes separating directories, and the layout of the
+// timestamp.
+func (p *backupPattern) split(name string) (before, layout, after string, err error) {
+	var layouts []string
+	var b bytes.Buffer
+	ext := filepath.Ext(name)
+	fields := backupFields{Name: name[:len(name)-len(ext)], Ext: ext, layouts: &layouts}
+	if err := p.tmpl.Execute(&b, fields); err != nil {
+		return "", "", "", errors.Wrap(err, "bad BackupPattern")
+	}
+	s := b.String()
+	if len(layouts) != 1 || strings.Count(s, timeMark) != 1 {
+		return "", "", "", errors.Errorf("bad BackupPattern %q: it must include {{.Time}} exactly once", p.pattern)
+	}
+	i := strings.Index(s, timeMark)
+	return s[:i], layouts[0], s[i+len(timeMark):], nil
+}
+
+// trailingPath returns the last depth+1 elements of path, separated by
+// slashes.
+func trailingPath(path string, depth int) string {
+	rel := filepath.Base(path)
+	for dir := filepath.Dir(path); depth > 0; depth-- {
+		rel = filepath.Base(dir) + "/" + rel
+		dir = filepath.Dir(dir)
+	}
+	return rel
+}
@@ -0,0 +1,89 @@
+package zaphelper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupPattern(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	for _, tt := range []struct {
+		pattern string
+		backup  func(ts time.Time) string
+	}{
+		{
+			pattern: `{{.Name}}{{.Ext}}.{{.Time "20060102-150405"}}`,
+			backup:  func(ts time.Time) string { return "app.log." + ts.Format("20060102-150405") },
+		},
+		{
+			pattern: `{{.Name}}/{{.Time "20060102/150405"}}{{.Ext}}`,
+			backup: func(ts time.Time) string {
+				return filepath.Join("app", ts.Format("20060102"), ts.Format("150405")+".log")
+			},
+		},
+	} {
+		dir := makeTempDir("TestBackupPattern", t)
+		defer os.RemoveAll(dir)
+
+		filename := filepath.Join(dir, "app.log")
+		w := &Writer{Filename: filename, BackupPattern: tt.pattern, MaxBackups: 1}
+		defer w.Close()
+
+		b := []byte("boo!\n")
+		_, err := w.Write(b)
+		isNil(err, t)
+		isNil(w.Close(), t)
+		backup, err := w.backup()
+		isNil(err, t)
+		equals(filepath.Join(dir, tt.backup(fakeTime().UTC())), backup, t)
+		existsWithContent(backup, b, t)
+
+		// the names parse back, and anything else is left alone
+		then := fakeCurrentTime.AddDate(-1, 0, 0).UTC()
+		older := filepath.Join(dir, tt.backup(then))
+		isNil(os.MkdirAll(filepath.Dir(older), 0755), t)
+		isNil(ioutil.WriteFile(older, []byte("old"), 0644), t)
+		other := filepath.Join(dir, tt.backup(then)+".txt")
+		isNil(ioutil.WriteFile(other, []byte("other"), 0644), t)
+		backups, err := w.Backups()
+		isNil(err, t)
+		equals(2, len(backups), t)
+		equals(backup, backups[0].Path, t)
+		equals(fakeTime().Truncate(time.Second).Unix(), backups[0].Timestamp.Unix(), t)
+		equals(older, backups[1].Path, t)
+		equals(then.Truncate(time.Second).Unix(), backups[1].Timestamp.Unix(), t)
+
+		isNil(w.millRunOnce(), t)
+		existsWithContent(backup, b, t)
+		notExist(older, t)
+		existsWithContent(other, []byte("other"), t)
+	}
+}
+
+func TestBadBackupPattern(t *testing.T) {
+	dir := makeTempDir("TestBadBackupPattern", t)
+	defer os.RemoveAll(dir)
+
+	for _, pattern := range []string{
+		"{{.Name}}.old{{.Ext}}",
+		"{{.Name}}-{{.Time}}-{{.Time}}{{.Ext}}",
+		`{{.Name}}-{{.Time "2006" "01"}}{{.Ext}}`,
+		"{{.Name}}-{{.Time}",
+		"{{.Name}}-{{.Stamp}}{{.Ext}}",
+		"../{{.Name}}-{{.Time}}{{.Ext}}",
+		"/var/log/{{.Name}}-{{.Time}}{{.Ext}}",
+	} {
+		w := &Writer{Filename: filepath.Join(dir, "app.log"), BackupPattern: pattern}
+		_, err := w.Write([]byte("boo!\n"))
+		if err == nil || !strings.Contains(err.Error(), "BackupPattern") {
+			t.Fatalf("expected an error for %q, got %v", pattern, err)
+		}
+		notExist(filepath.Join(dir, "app.log"), t)
+	}
+}
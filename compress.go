@@ -0,0 +1,83 @@
+package zaphelper
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Compressor returns a writer compressing what is written to it into w at
+// level, 0 standing for the default level of the algorithm.  The writer is
+// closed once the whole file has been written.
+type Compressor func(w io.Writer, level int) (io.WriteCloser, error)
+
+// compression is an algorithm registered with RegisterCompressor.
+type compression struct {
+	suffix   string
+	compress Compressor
+}
+
+var (
+	compressionsMu sync.RWMutex
+	// compressions are the algorithms CompressAlgorithm may name, by name.
+	compressions = map[string]compression{
+		"gzip": {compressSuffix, gzipCompressor},
+	}
+)
+
+// RegisterCompressor makes the compression algorithm called name available to
+// Writer's CompressAlgorithm, with compressed backups named with suffix, such
+// as ".zst".  It is meant to be called from the init function of the package
+// implementing the algorithm, so that its dependencies are only pulled in by
+// programs importing it.  Backups with any registered suffix are recognized
+// by the cleanup.
+func RegisterCompressor(name, suffix string, c Compressor) {
+	compressionsMu.Lock()
+	defer compressionsMu.Unlock()
+	compressions[name] = compression{suffix, c}
+}
+
+// lookupCompression returns the algorithm called name, gzip if empty.
+func lookupCompression(name string) (compression, bool) {
+	if name == "" {
+		name = "gzip"
+	}
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	c, ok := compressions[name]
+	return c, ok
+}
+
+// trimCompressSuffix returns name without the suffix of a registered
+// compression algorithm, and whether it had one.
+func trimCompressSuffix(name string) (string, bool) {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	for _, c := range compressions {
+		if strings.HasSuffix(name, c.suffix) {
+			return strings.TrimSuffix(name, c.suffix), true
+		}
+	}
+	return name, false
+}
+
+// compressSuffixes returns the suffixes of the registered compression
+// algorithms.
+func compressSuffixes() []string {
+	compressionsMu.RLock()
+	defer compressionsMu.RUnlock()
+	suffixes := make([]string, 0, len(compressions))
+	for _, c := range compressions {
+		suffixes = append(suffixes, c.suffix)
+	}
+	return suffixes
+}
+
+// gzipCompressor is the Compressor of the built-in "gzip" algorithm.
+func gzipCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
@@ -0,0 +1,265 @@
+package zaphelper
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// upperWriter "compresses" by upper-casing ASCII letters, and records the level
+// it was created with.
+type upperWriter struct {
+	w     io.Writer
+	level int
+}
+
+func (u *upperWriter) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	for i, c := range p {
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		b[i] = c
+	}
+	return u.w.Write(b)
+}
+
+func (u *upperWriter) Close() error { return nil }
+
+func TestRegisterCompressor(t *testing.T) {
+	var level int
+	RegisterCompressor("upper", ".up", func(w io.Writer, l int) (io.WriteCloser, error) {
+		level = l
+		return &upperWriter{w: w}, nil
+	})
+	defer func() {
+		compressionsMu.Lock()
+		delete(compressions, "upper")
+		compressionsMu.Unlock()
+	}()
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestRegisterCompressor", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), Compress: true, CompressAlgorithm: "upper", CompressLevel: 3}
+	defer w.Close()
+
+	backup := makeBackup(dir, fakeCurrentTime.Add(-time.Hour), t)
+	isNil(w.millRunOnce(), t)
+	notExist(backup, t)
+	existsWithContent(backup+".up", []byte("OLD"), t)
+	equals(3, level, t)
+
+	backups, err := w.Backups()
+	isNil(err, t)
+	equals(1, len(backups), t)
+	equals(true, backups[0].Compressed, t)
+}
+
+func TestUnknownCompressAlgorithm(t *testing.T) {
+	dir := makeTempDir("TestUnknownCompressAlgorithm", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), Compress: true, CompressAlgorithm: "lzma"}
+	backup := makeBackup(dir, time.Now().Add(-time.Hour), t)
+	if err := w.millRunOnce(); err == nil {
+		t.Fatal("expected an error for an unknown algorithm")
+	}
+	existsWithContent(backup, []byte("old"), t)
+}
+
+func TestCompressLevel(t *testing.T) {
+	dir := makeTempDir("TestCompressLevel", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), Compress: true, CompressLevel: 9}
+	backup := makeBackup(dir, time.Now().Add(-time.Hour), t)
+	isNil(w.millRunOnce(), t)
+	existsWithGzipContent(backup+compressSuffix, []byte("old"), t)
+
+	w.CompressLevel = 42
+	backup = makeBackup(dir, time.Now().Add(-2*time.Hour), t)
+	if err := w.millRunOnce(); err == nil {
+		t.Fatal("expected an error for an invalid gzip level")
+	}
+	existsWithContent(backup, []byte("old"), t)
+}
+
+func TestCompressExisting(t *testing.T) {
+	dir := makeTempDir("TestCompressExisting", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	isNil(ioutil.WriteFile(filename, []byte("active"), 0644), t)
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	now := time.Now()
+	plain := makeBackup(dir, now.Add(-time.Hour), t)
+	done := makeBackup(dir, now.Add(-2*time.Hour), t)
+	isNil(os.Rename(done, done+compressSuffix), t)
+	// an interrupted compression left both
+	twin := makeBackup(dir, now.Add(-3*time.Hour), t)
+	isNil(ioutil.WriteFile(twin+compressSuffix, []byte("compressed"), 0644), t)
+	other := filepath.Join(dir, "other.log")
+	isNil(ioutil.WriteFile(other, []byte("other"), 0644), t)
+
+	for i := 0; i < 2; i++ {
+		isNil(w.CompressExisting(), t)
+		notExist(plain, t)
+		existsWithGzipContent(plain+compressSuffix, []byte("old"), t)
+		existsWithContent(done+compressSuffix, []byte("old"), t)
+		existsWithContent(twin, []byte("old"), t)
+		existsWithContent(twin+compressSuffix, []byte("compressed"), t)
+		existsWithContent(filename, []byte("active"), t)
+		existsWithContent(other, []byte("other"), t)
+	}
+}
+
+func TestCompressExistingOnOpen(t *testing.T) {
+	dir := makeTempDir("TestCompressExistingOnOpen", t)
+	defer os.RemoveAll(dir)
+
+	backup := makeBackup(dir, time.Now().Add(-time.Hour), t)
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), Compress: true}
+	defer w.Close()
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+
+	waitFor(func() bool {
+		_, err := os.Stat(backup + compressSuffix)
+		return err == nil
+	}, t)
+	waitFor(func() bool {
+		_, err := os.Stat(backup)
+		return os.IsNotExist(err)
+	}, t)
+	existsWithGzipContent(backup+compressSuffix, []byte("old"), t)
+}
+
+func TestCompressConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	var active, peak int
+	RegisterCompressor("slow", ".slow", func(w io.Writer, l int) (io.WriteCloser, error) {
+		mu.Lock()
+		active++
+		if active > peak {
+			peak = active
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return &upperWriter{w: w}, nil
+	})
+	defer func() {
+		compressionsMu.Lock()
+		delete(compressions, "slow")
+		compressionsMu.Unlock()
+	}()
+
+	dir := makeTempDir("TestCompressConcurrency", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), CompressAlgorithm: "slow", CompressConcurrency: 2}
+	now := time.Now()
+	for i := 1; i <= 6; i++ {
+		makeBackup(dir, now.Add(-time.Duration(i)*time.Hour), t)
+	}
+	isNil(w.CompressExisting(), t)
+	equals(2, peak, t)
+	equals(0, len(backupFiles(dir, t)), t)
+
+	// the default is one at a time
+	peak = 0
+	w.CompressConcurrency = 0
+	makeBackup(dir, now, t)
+	makeBackup(dir, now.Add(-30*time.Minute), t)
+	isNil(w.CompressExisting(), t)
+	equals(1, peak, t)
+}
+
+func TestCloseWaitsForCompression(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	RegisterCompressor("blocking", ".blk", func(w io.Writer, l int) (io.WriteCloser, error) {
+		close(started)
+		<-release
+		return &upperWriter{w: w}, nil
+	})
+	defer func() {
+		compressionsMu.Lock()
+		delete(compressions, "blocking")
+		compressionsMu.Unlock()
+	}()
+
+	dir := makeTempDir("TestCloseWaitsForCompression", t)
+	defer os.RemoveAll(dir)
+
+	backup := makeBackup(dir, time.Now().Add(-time.Hour), t)
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), Compress: true, CompressAlgorithm: "blocking"}
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while compressing")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+	existsWithContent(backup+".blk", []byte("OLD"), t)
+}
+
+func TestCloseTimeout(t *testing.T) {
+	closeTimeout = 10 * time.Millisecond
+	defer func() { closeTimeout = 10 * time.Second }()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	RegisterCompressor("stuck", ".stk", func(w io.Writer, l int) (io.WriteCloser, error) {
+		close(started)
+		<-release
+		return &upperWriter{w: w}, nil
+	})
+	defer func() {
+		compressionsMu.Lock()
+		delete(compressions, "stuck")
+		compressionsMu.Unlock()
+	}()
+
+	dir := makeTempDir("TestCloseTimeout", t)
+	defer os.RemoveAll(dir)
+
+	makeBackup(dir, time.Now().Add(-time.Hour), t)
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, Compress: true, CompressAlgorithm: "stuck", BufferSize: 4096}
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	<-started
+
+	// the logfile is flushed and closed anyway
+	err = w.Close()
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	existsWithContent(filename, []byte("boo!\n"), t)
+	equals(true, isClosed(w), t)
+	close(release)
+}
@@ -0,0 +1,231 @@
+package zaphelper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config is the declarative form of InitLogger's arguments and options, e.g.
+// for ops to manage in a file read by LoadConfig.  Empty fields keep the
+// defaults of InitLogger.
+type Config struct {
+	// Path is the directory of the log files.
+	Path string `json:"path" yaml:"path"`
+	// Filename is the name of the file in Path NewCore writes to.  It
+	// defaults to the name of the program followed by ".log".  InitLogger
+	// names its files after the loggers instead.
+	Filename string `json:"filename" yaml:"filename"`
+	// Level is the minimum level of the loggers, such as "debug".  It
+	// defaults to the value of the WithLevelEnv variable, if set, and to
+	// "info" otherwise.
+	Level string `json:"level" yaml:"level"`
+	// Location is the name of the time zone of the times in the entries and
+	// the file names, such as "Asia/Shanghai".  It defaults to time.Local.
+	Location string `json:"location" yaml:"location"`
+	// Encoding is one of the Encoding constants.  It defaults to JSON.
+	Encoding string `json:"encoding" yaml:"encoding"`
+	// TimeFormat is one of the TimeFormat presets, or a layout for
+	// time.Format.
+	TimeFormat string `json:"timeformat" yaml:"timeformat"`
+	// TimePrecision is one of the TimePrecision presets.
+	TimePrecision string `json:"timeprecision" yaml:"timeprecision"`
+	// DurationFormat is one of the DurationFormat presets.
+	DurationFormat string `json:"durationformat" yaml:"durationformat"`
+	// LevelFormat is one of the LevelFormat presets.
+	LevelFormat string `json:"levelformat" yaml:"levelformat"`
+	// Caller records the file and line entries were logged from.
+	Caller bool `json:"caller" yaml:"caller"`
+	// DisableTimestamp, DisableLevel and DisableCaller leave those fields
+	// out of the entries, like WithoutTimestamp, WithoutLevel and
+	// WithoutCaller.
+	DisableTimestamp bool `json:"disabletimestamp" yaml:"disabletimestamp"`
+	DisableLevel     bool `json:"disablelevel" yaml:"disablelevel"`
+	DisableCaller    bool `json:"disablecaller" yaml:"disablecaller"`
+	// StacktraceLevel, if set, attaches a stacktrace to entries at this
+	// level and above.
+	StacktraceLevel string `json:"stacktracelevel" yaml:"stacktracelevel"`
+	// Console additionally writes the entries to stdout.
+	Console bool `json:"console" yaml:"console"`
+	// ErrorFilename, if set, additionally writes the entries at error level
+	// and above to this file in Path.
+	ErrorFilename string `json:"errorfilename" yaml:"errorfilename"`
+	// Rotation configures the Writers of the log files.
+	Rotation RotationConfig `json:"rotation" yaml:"rotation"`
+	// Sampling, if set, samples the entries like WithSampling.
+	Sampling *SamplingConfig `json:"sampling" yaml:"sampling"`
+	// InitialFields are attached to every entry, like WithFields.
+	InitialFields map[string]interface{} `json:"initialfields" yaml:"initialfields"`
+
+	// Options are applied after the settings above, overriding them.
+	Options []LoggerOption `json:"-" yaml:"-"`
+
+	// debugLevel and location are the InitLogger arguments; location takes
+	// precedence over Location.
+	debugLevel bool
+	location   *time.Location
+}
+
+// RotationConfig are the settings of the Writers of a Config, see Writer for
+// their meaning.
+type RotationConfig struct {
+	MaxSize      int   `json:"maxsize" yaml:"maxsize"`
+	MaxLines     int   `json:"maxlines" yaml:"maxlines"`
+	MaxBackups   int   `json:"maxbackups" yaml:"maxbackups"`
+	MaxAge       int   `json:"maxage" yaml:"maxage"`
+	MaxTotalSize int64 `json:"maxtotalsize" yaml:"maxtotalsize"`
+	Compress     bool  `json:"compress" yaml:"compress"`
+	LocalTime    bool  `json:"localtime" yaml:"localtime"`
+	// BackupPattern names the backups, see Writer.BackupPattern.
+	BackupPattern string `json:"backuppattern" yaml:"backuppattern"`
+}
+
+// SamplingConfig are the arguments of WithSampling in a Config.
+type SamplingConfig struct {
+	First      int `json:"first" yaml:"first"`
+	Thereafter int `json:"thereafter" yaml:"thereafter"`
+	// Tick is a duration such as "1s", its default.
+	Tick string `json:"tick" yaml:"tick"`
+}
+
+// LoadConfig reads a Config from the file at path, as JSON if its name ends
+// in ".json" and as YAML otherwise.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, errors.Wrap(err, "can't read config")
+	}
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(b, &cfg)
+	} else {
+		err = yaml.Unmarshal(b, &cfg)
+	}
+	if err != nil {
+		return cfg, errors.Wrapf(err, "can't parse config %s", path)
+	}
+	return cfg, nil
+}
+
+// InitFromConfig initializes the loggers like InitLogger, from cfg.
+func InitFromConfig(cfg Config) error {
+	initLock.Lock()
+	defer initLock.Unlock()
+
+	o, err := cfg.options()
+	if err != nil {
+		return err
+	}
+	return initLogger(cfg.Path, o)
+}
+
+// NewCore returns a core writing the entries enabled by cfg.Level to a Writer
+// for the file Filename in Path, and that Writer, for composing into a logger
+// built by hand, e.g. with zapcore.NewTee.  Unlike InitLogger it doesn't touch
+// the package's loggers: SetLevel doesn't apply to the core, and the outputs
+// other than the file (Console, ErrorFilename, WithSyslog, WithRemote, ...)
+// are left out.  The file is opened right away, so that errors surface here,
+// and closing the Writer is up to the caller.
+func NewCore(cfg Config) (zapcore.Core, *Writer, error) {
+	o, err := cfg.options()
+	if err != nil {
+		return nil, nil, err
+	}
+	name := cfg.Filename
+	if name == "" {
+		name = filepath.Base(os.Args[0]) + ".log"
+	}
+	w := o.newWriter(filepath.Join(cfg.Path, name))
+	core, err := o.newCoreAt(zap.NewAtomicLevelAt(o.level), zapcore.AddSync(w), sinks{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := w.Open(); err != nil {
+		return nil, nil, err
+	}
+	return core, w, nil
+}
+
+// options returns the options cfg stands for.
+func (cfg Config) options() (options, error) {
+	o := newOptions(cfg.debugLevel)
+	if cfg.Level != "" {
+		if err := o.level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return o, errors.Wrap(err, "bad level")
+		}
+		o.levelSet = true
+	}
+	o.location = cfg.location
+	if o.location == nil && cfg.Location != "" {
+		loc, err := time.LoadLocation(cfg.Location)
+		if err != nil {
+			return o, errors.Wrap(err, "bad location")
+		}
+		o.location = loc
+	}
+	if cfg.Encoding != "" {
+		o.encoding = cfg.Encoding
+	}
+	o.timeFormat = cfg.TimeFormat
+	o.precision = cfg.TimePrecision
+	o.duration = cfg.DurationFormat
+	o.levelFmt = cfg.LevelFormat
+	o.caller = cfg.Caller
+	o.noTimestamp = cfg.DisableTimestamp
+	o.noLevel = cfg.DisableLevel
+	o.noCaller = cfg.DisableCaller
+	if cfg.StacktraceLevel != "" {
+		if err := o.stacktraceLevel.UnmarshalText([]byte(cfg.StacktraceLevel)); err != nil {
+			return o, errors.Wrap(err, "bad stacktrace level")
+		}
+		o.stacktrace = true
+	}
+	o.console = cfg.Console
+	o.errorFilename = cfg.ErrorFilename
+	o.writerOptions = cfg.Rotation.writerOptions()
+	if s := cfg.Sampling; s != nil {
+		var tick time.Duration
+		if s.Tick != "" {
+			var err error
+			if tick, err = time.ParseDuration(s.Tick); err != nil {
+				return o, errors.Wrap(err, "bad sampling tick")
+			}
+		}
+		WithSampling(s.First, s.Thereafter, tick)(&o)
+	}
+	keys := make([]string, 0, len(cfg.InitialFields))
+	for k := range cfg.InitialFields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		o.fields = append(o.fields, zap.Any(k, cfg.InitialFields[k]))
+	}
+	for _, opt := range cfg.Options {
+		opt(&o)
+	}
+	o.applyLevelEnv()
+	return o, nil
+}
+
+// writerOptions returns the Options setting the fields of r.
+func (r RotationConfig) writerOptions() []Option {
+	return []Option{func(w *Writer) {
+		w.MaxSize = r.MaxSize
+		w.MaxLines = r.MaxLines
+		w.MaxBackups = r.MaxBackups
+		w.MaxAge = r.MaxAge
+		w.MaxTotalSize = r.MaxTotalSize
+		w.Compress = r.Compress
+		w.LocalTime = r.LocalTime
+		w.BackupPattern = r.BackupPattern
+	}}
+}
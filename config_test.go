@@ -0,0 +1,140 @@
+package zaphelper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLoadConfigYAML(t *testing.T) {
+	dir := makeTempDir("TestLoadConfigYAML", t)
+	defer os.RemoveAll(dir)
+
+	logDir := filepath.Join(dir, "logs")
+	path := filepath.Join(dir, "log.yaml")
+	isNil(ioutil.WriteFile(path, []byte(`
+path: `+logDir+`
+level: debug
+location: UTC
+levelformat: uppercase
+durationformat: string
+rotation:
+  maxsize: 100
+  maxbackups: 3
+  compress: true
+sampling:
+  first: 100
+  thereafter: 10
+  tick: 2s
+initialfields:
+  service: billing
+  shard: 7
+`), 0644), t)
+
+	cfg, err := LoadConfig(path)
+	isNil(err, t)
+	equals(logDir, cfg.Path, t)
+	equals(3, cfg.Rotation.MaxBackups, t)
+	equals("2s", cfg.Sampling.Tick, t)
+
+	isNil(InitFromConfig(cfg), t)
+	defer initDefaults(t)
+
+	GetLogger("TestLoadConfigYAML").Debug("hello")
+	isNil(Sync(), t)
+
+	w := loggers.get("TestLoadConfigYAML").writer
+	equals(100, w.MaxSize, t)
+	equals(3, w.MaxBackups, t)
+	equals(true, w.Compress, t)
+
+	b, err := ioutil.ReadFile(filepath.Join(logDir, "TestLoadConfigYAML.log"))
+	isNil(err, t)
+	entries := decodeEntries(string(b), t)
+	equals(1, len(entries), t)
+	equals("DEBUG", entries[0]["level"], t)
+	equals("billing", entries[0]["service"], t)
+	equals(float64(7), entries[0]["shard"], t)
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	dir := makeTempDir("TestLoadConfigJSON", t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.json")
+	isNil(ioutil.WriteFile(path, []byte(`{"path": "/var/log/app", "level": "warn", "rotation": {"maxage": 7}}`), 0644), t)
+
+	cfg, err := LoadConfig(path)
+	isNil(err, t)
+	equals("/var/log/app", cfg.Path, t)
+	equals("warn", cfg.Level, t)
+	equals(7, cfg.Rotation.MaxAge, t)
+}
+
+func TestInitFromConfigErrors(t *testing.T) {
+	dir := makeTempDir("TestInitFromConfigErrors", t)
+	defer os.RemoveAll(dir)
+
+	for _, cfg := range []Config{
+		{Path: dir, Level: "loud"},
+		{Path: dir, Location: "Nowhere/Special"},
+		{Path: dir, StacktraceLevel: "loud"},
+		{Path: dir, Sampling: &SamplingConfig{Tick: "soon"}},
+		{Path: dir, Encoding: "xml"},
+	} {
+		if err := InitFromConfig(cfg); err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestNewCore(t *testing.T) {
+	dir := makeTempDir("TestNewCore", t)
+	defer os.RemoveAll(dir)
+
+	initLock.Lock()
+	before := Logger
+	initLock.Unlock()
+	core, w, err := NewCore(Config{
+		Path:     dir,
+		Filename: "app.log",
+		Level:    "warn",
+		Options:  []LoggerOption{WithFields(zap.String("app", "demo"))},
+	})
+	isNil(err, t)
+	defer w.Close()
+	equals(filepath.Join(dir, "app.log"), w.Filename, t)
+	existsWithContent(w.Filename, []byte{}, t)
+
+	logger := zap.New(zapcore.NewTee(core, zapcore.NewNopCore()))
+	logger.Info("dropped")
+	logger.Warn("kept", zap.Int("n", 1))
+	isNil(logger.Sync(), t)
+
+	entries := readEntries(w.Filename, t)
+	equals(1, len(entries), t)
+	equals("kept", entries[0]["message"], t)
+	equals(float64(1), entries[0]["n"], t)
+	// the package's loggers are left alone
+	initLock.Lock()
+	defer initLock.Unlock()
+	equals(true, before == Logger, t)
+}
+
+func TestNewCoreErrors(t *testing.T) {
+	_, _, err := NewCore(Config{Path: "/", Level: "loud"})
+	if err == nil {
+		t.Fatal("expected an error for a bad level")
+	}
+	dir := makeTempDir("TestNewCoreErrors", t)
+	defer os.RemoveAll(dir)
+	notDir := filepath.Join(dir, "file")
+	isNil(ioutil.WriteFile(notDir, nil, 0644), t)
+	if _, _, err := NewCore(Config{Path: notDir}); err == nil {
+		t.Fatal("expected an error for a file in place of the directory")
+	}
+}
@@ -0,0 +1,66 @@
+package zaphelper
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// levelWidth is the width of the level column of the console, that of the
+// longest level, "dpanic".
+const levelWidth = 6
+
+// levelColors are the ANSI colors of the levels on the console, those of
+// zapcore.CapitalColorLevelEncoder.
+var levelColors = map[zapcore.Level]int{
+	zapcore.DebugLevel:  35, // magenta
+	zapcore.InfoLevel:   34, // blue
+	zapcore.WarnLevel:   33, // yellow
+	zapcore.ErrorLevel:  31, // red
+	zapcore.DPanicLevel: 31,
+	zapcore.PanicLevel:  31,
+	zapcore.FatalLevel:  31,
+}
+
+// consoleLevelEncoder encodes levels padded to levelWidth, so that the columns
+// of the console line up, in uppercase if upper is set, to match the files,
+// and in uppercase and color like zapcore.CapitalColorLevelEncoder if color
+// is set.
+func consoleLevelEncoder(upper, color bool) zapcore.LevelEncoder {
+	return func(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+		s := l.String()
+		if upper || color {
+			s = l.CapitalString()
+		}
+		var pad string
+		if n := levelWidth - len(s); n > 0 {
+			pad = strings.Repeat(" ", n)
+		}
+		if code, ok := levelColors[l]; ok && color {
+			s = "\x1b[" + strconv.Itoa(code) + "m" + s + "\x1b[0m"
+		}
+		enc.AppendString(s + pad)
+	}
+}
+
+// consoleColored reports whether the console levels are colored: as set by
+// WithConsoleColor, or if out is a terminal.
+func (o *options) consoleColored(out io.Writer) bool {
+	if o.consoleColorSet {
+		return o.consoleColor
+	}
+	return isTerminal(out)
+}
+
+// isTerminal reports whether w is a terminal, or another character device.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,63 @@
+package zaphelper
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestConsoleAligned(t *testing.T) {
+	dir := makeTempDir("TestConsoleAligned", t)
+	defer os.RemoveAll(dir)
+
+	var stdout bytes.Buffer
+	consoleOutput = &stdout
+	defer func() { consoleOutput = os.Stdout }()
+
+	// not a terminal, so no colors by default
+	isNil(InitLogger(dir, false, nil, WithConsole(true), WithLevelFormat(LevelFormatUppercase)), t)
+	defer initDefaults(t)
+	logger := GetLogger("TestConsoleAligned")
+	logger.Info("first")
+	logger.Error("second")
+	isNil(logger.Sync(), t)
+
+	if strings.Contains(stdout.String(), "\x1b[") {
+		t.Fatalf("unexpected color codes on a non-terminal: %q", stdout.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	equals(2, len(lines), t)
+	first, second := strings.Split(lines[0], "\t"), strings.Split(lines[1], "\t")
+	equals("INFO  ", first[1], t)
+	equals("ERROR ", second[1], t)
+	equals(len(first[0]), len(second[0]), t)
+}
+
+func TestConsoleLevelEncoder(t *testing.T) {
+	enc := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
+		MessageKey:  "message",
+		LevelKey:    "level",
+		EncodeLevel: consoleLevelEncoder(false, true),
+	})
+	buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.WarnLevel, Message: "hi"}, nil)
+	isNil(err, t)
+	equals("\x1b[33mWARN\x1b[0m  \thi\n", buf.String(), t)
+}
+
+func TestIsTerminal(t *testing.T) {
+	equals(false, isTerminal(&bytes.Buffer{}), t)
+
+	dir := makeTempDir("TestIsTerminal", t)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "out")
+	isNil(ioutil.WriteFile(name, nil, 0644), t)
+	f, err := os.Open(name)
+	isNil(err, t)
+	defer f.Close()
+	equals(false, isTerminal(f), t)
+}
@@ -0,0 +1,90 @@
+package zaphelper
+
+import (
+	"context"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// ContextKey is the type of the context keys FromContext extracts by default.
+type ContextKey string
+
+const (
+	// RequestIDKey is the context key of the request id, logged as
+	// "request_id" by FromContext unless WithContextKeys says otherwise.
+	RequestIDKey = ContextKey("request_id")
+	// TraceIDKey is the context key of the trace id, logged as "trace_id" by
+	// FromContext unless WithContextKeys says otherwise.
+	TraceIDKey = ContextKey("trace_id")
+)
+
+// loggerKey is the context key WithContext stashes a logger under.
+type loggerKey struct{}
+
+// defaultContextKeys are the context keys FromContext extracts by default, by
+// field name.
+var defaultContextKeys = map[string]interface{}{
+	"request_id": RequestIDKey,
+	"trace_id":   TraceIDKey,
+}
+
+// WithContextKeys sets the values FromContext extracts from a context, as a
+// map from field name to context key.
+func WithContextKeys(keys map[string]interface{}) LoggerOption {
+	return func(o *options) {
+		o.contextKeys = keys
+	}
+}
+
+// FromContext returns GetLogger(name) with a field for each of the known
+// context keys that has a value in ctx.  With WithDeadlineSampling, the
+// logger also follows the deadline of ctx.
+func FromContext(ctx context.Context, name string) *zap.SugaredLogger {
+	loggers.lock.RLock()
+	keys := settings.contextKeys
+	deadline, hasDeadline := settings.deadlineField(ctx)
+	loggers.lock.RUnlock()
+
+	fields := make([]string, 0, len(keys))
+	for field := range keys {
+		fields = append(fields, field)
+	}
+	// keep the fields in a stable order
+	sort.Strings(fields)
+
+	var args []interface{}
+	for _, field := range fields {
+		if v := ctx.Value(keys[field]); v != nil {
+			args = append(args, field, v)
+		}
+	}
+	if hasDeadline {
+		args = append(args, deadline)
+	}
+	logger := GetLogger(name)
+	if len(args) == 0 {
+		return logger
+	}
+	return logger.With(args...)
+}
+
+// WithContext returns a copy of ctx carrying logger, with the given
+// key-value pairs added, for LoggerFromContext to retrieve.
+func WithContext(ctx context.Context, logger *zap.SugaredLogger, keysAndValues ...interface{}) context.Context {
+	if len(keysAndValues) > 0 {
+		logger = logger.With(keysAndValues...)
+	}
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger stashed in ctx by WithContext, or
+// Logger if there is none.
+func LoggerFromContext(ctx context.Context) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	initLock.Lock()
+	defer initLock.Unlock()
+	return Logger
+}
@@ -0,0 +1,103 @@
+package zaphelper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type userKey struct{}
+
+func TestFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf)), t)
+	defer initDefaults(t)
+
+	ctx := context.WithValue(context.Background(), RequestIDKey, "req-1")
+	FromContext(ctx, "TestFromContext").Info("hello")
+	FromContext(context.Background(), "TestFromContext").Info("bare")
+
+	entries := decodeEntries(buf.String(), t)
+	equals(2, len(entries), t)
+	equals("req-1", entries[0]["request_id"], t)
+	if _, ok := entries[0]["trace_id"]; ok {
+		t.Fatalf("unexpected trace_id in %v", entries[0])
+	}
+	if _, ok := entries[1]["request_id"]; ok {
+		t.Fatalf("unexpected request_id in %v", entries[1])
+	}
+}
+
+func TestWithContextKeys(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithContextKeys(map[string]interface{}{"user": userKey{}})), t)
+	defer initDefaults(t)
+
+	ctx := context.WithValue(context.Background(), userKey{}, "alice")
+	ctx = context.WithValue(ctx, RequestIDKey, "req-1")
+	FromContext(ctx, "TestWithContextKeys").Info("hello")
+
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	equals("alice", entries[0]["user"], t)
+	if _, ok := entries[0]["request_id"]; ok {
+		t.Fatalf("unexpected request_id in %v", entries[0])
+	}
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf)), t)
+	defer initDefaults(t)
+
+	if LoggerFromContext(context.Background()) != Logger {
+		t.Fatal("expected Logger for a context without logger")
+	}
+
+	ctx := WithContext(context.Background(), GetLogger("TestLoggerFromContext"), "tenant", "acme")
+	LoggerFromContext(ctx).Info("hello")
+
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	equals("acme", entries[0]["tenant"], t)
+}
+
+func TestDeadlineSampling(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithSampling(1, 0, time.Minute), WithDeadlineSampling(time.Minute)), t)
+	defer initDefaults(t)
+
+	now := time.Now()
+	for n, tt := range []struct {
+		deadline time.Time
+		kept     int
+	}{
+		// sampled when there is no deadline or it is far enough
+		{time.Time{}, 1},
+		{now.Add(time.Hour), 1},
+		// and kept close to or past the deadline
+		{now.Add(10 * time.Second), 5},
+		{now.Add(-time.Second), 5},
+	} {
+		ctx := context.Background()
+		if !tt.deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, tt.deadline)
+			defer cancel()
+		}
+		buf.Reset()
+		logger := FromContext(ctx, "TestDeadlineSampling")
+		// the loggers share the counts, which are by message
+		msg := fmt.Sprintf("repeated %d", n)
+		for i := 0; i < 5; i++ {
+			logger.Infow(msg, "i", i)
+		}
+		entries := decodeEntries(buf.String(), t)
+		equals(tt.kept, len(entries), t)
+		if _, ok := entries[0][deadlineKey]; ok {
+			t.Fatalf("unexpected %s field in %v", deadlineKey, entries[0])
+		}
+	}
+}
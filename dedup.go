@@ -0,0 +1,223 @@
+package zaphelper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DedupKey returns the identity of an entry for WithDedup: entries with the
+// same key are considered repeats of each other.
+type DedupKey func(ent zapcore.Entry, fields []zapcore.Field) string
+
+// DedupByMessage identifies entries by their level and message.
+func DedupByMessage(ent zapcore.Entry, fields []zapcore.Field) string {
+	return ent.Level.String() + "\x00" + ent.Message
+}
+
+// DedupByMessageAndFields identifies entries by their level, message and
+// fields.
+func DedupByMessageAndFields(ent zapcore.Entry, fields []zapcore.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	keys := make([]string, 0, len(enc.Fields))
+	for k := range enc.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	key := DedupByMessage(ent, fields)
+	for _, k := range keys {
+		key += fmt.Sprintf("\x00%s=%v", k, enc.Fields[k])
+	}
+	return key
+}
+
+// WithDedup collapses the repeats of an entry logged within window of it, as
+// identified by key, or DedupByMessage if nil: the entry is written, its
+// repeats are dropped, and once the window elapses or a different entry is
+// logged, the last repeat is written once with a "repeated" field counting
+// them.  Unlike WithSampling, nothing is lost but the repeats themselves, which
+// keeps crash loops from burying other lines.
+func WithDedup(window time.Duration, key DedupKey) LoggerOption {
+	return func(o *options) {
+		if key == nil {
+			key = DedupByMessage
+		}
+		o.dedupWindow = window
+		o.dedupKey = key
+	}
+}
+
+// dedupCore is a zapcore.Core collapsing repeated entries, as configured by
+// WithDedup.  The cores derived from it by With share its state, so that the
+// repeats logged through the loggers of FromContext collapse too.
+type dedupCore struct {
+	zapcore.Core
+	window time.Duration
+	key    DedupKey
+	// fields are those added by With since newDedupCore, which the key
+	// sees along with those of the entry.
+	fields []zapcore.Field
+	state  *dedupState
+}
+
+// dedupState tracks the entry whose repeats are being collapsed.
+type dedupState struct {
+	mu     sync.Mutex
+	active bool
+	key    string
+	first  time.Time
+	count  int
+	ent    zapcore.Entry
+	fields []zapcore.Field
+	// core is that of the last repeat, which writes them.
+	core  zapcore.Core
+	timer *time.Timer
+	// errorOutput gets the errors of the writes once the window elapses.
+	errorOutput zapcore.WriteSyncer
+}
+
+// afterFunc is time.AfterFunc, which tests replace to end the windows of
+// WithDedup by hand.
+var afterFunc = time.AfterFunc
+
+// newDedupCore returns core collapsing repeats within window, or core itself if
+// window isn't positive.  Collapsed entries are passed on through core's
+// Check, so core may be a tee or sampler.  The errors of writing the repeats
+// once the window elapses go to errorOutput, os.Stderr if nil.
+func newDedupCore(core zapcore.Core, window time.Duration, key DedupKey, errorOutput zapcore.WriteSyncer) zapcore.Core {
+	if window <= 0 {
+		return core
+	}
+	return &dedupCore{Core: core, window: window, key: key, state: &dedupState{errorOutput: errorOutput}}
+}
+
+func (c *dedupCore) With(fields []zapcore.Field) zapcore.Core {
+	return &dedupCore{
+		Core:   c.Core.With(fields),
+		window: c.window,
+		key:    c.key,
+		fields: append(c.fields[:len(c.fields):len(c.fields)], fields...),
+		state:  c.state,
+	}
+}
+
+func (c *dedupCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *dedupCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := fields
+	if len(c.fields) > 0 {
+		all = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	}
+	key := c.key(ent, all)
+	now := currentTime()
+	s := c.state
+	s.mu.Lock()
+	if s.active && s.key == key && now.Sub(s.first) < c.window {
+		s.count++
+		s.ent = ent
+		s.fields = append(s.fields[:0], fields...)
+		s.core = c.Core
+		s.mu.Unlock()
+		return nil
+	}
+	err := s.flushLocked()
+	s.active = true
+	s.key = key
+	s.first = now
+	var timer *time.Timer
+	timer = afterFunc(c.window, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// the window has ended since
+		if s.timer != timer {
+			return
+		}
+		if err := s.flushLocked(); err != nil {
+			reportError(s.errorOutput, "write", err)
+		}
+	})
+	s.timer = timer
+	s.mu.Unlock()
+	return multierr.Append(err, writeThrough(c.Core, ent, fields))
+}
+
+// Sync flushes the pending repeats, stopping the timer, so that it doesn't
+// fire into a closed output later.
+func (c *dedupCore) Sync() error {
+	return multierr.Append(c.state.flush(), c.Core.Sync())
+}
+
+// flush writes the pending repeats, if any, and ends the window.
+func (s *dedupState) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+// flushLocked is flush for callers holding the lock.
+func (s *dedupState) flushLocked() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	var err error
+	if s.count > 0 {
+		err = writeThrough(s.core, s.ent, append(s.fields, zap.Int("repeated", s.count)))
+	}
+	s.active = false
+	s.count = 0
+	s.fields = nil
+	s.core = nil
+	return err
+}
+
+// writeThrough passes ent and fields on through core's Check, so that the
+// levels and the sampling of the cores it tees still apply, which calling its
+// Write wouldn't do, and returns the errors of their writes.
+func writeThrough(core zapcore.Core, ent zapcore.Entry, fields []zapcore.Field) error {
+	ce := core.Check(ent, nil)
+	if ce == nil {
+		return nil
+	}
+	// the CheckedEntry only reports the errors to its ErrorOutput
+	var errs writeErrors
+	ce.ErrorOutput = &errs
+	ce.Write(fields...)
+	return errs.err
+}
+
+// writeErrors is a zapcore.WriteSyncer collecting the errors a CheckedEntry
+// reports.
+type writeErrors struct {
+	err error
+}
+
+func (e *writeErrors) Write(p []byte) (int, error) {
+	// the reports read "<time> write error: <error>"
+	msg := strings.TrimRight(string(p), "\n")
+	const sep = " write error: "
+	if i := strings.Index(msg, sep); i >= 0 {
+		msg = msg[i+len(sep):]
+	}
+	e.err = multierr.Append(e.err, errors.New(msg))
+	return len(p), nil
+}
+
+func (e *writeErrors) Sync() error {
+	return nil
+}
@@ -0,0 +1,233 @@
+package zaphelper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDedup(t *testing.T) {
+	inner, recorded := observer.New(zapcore.DebugLevel)
+	logger := zap.New(newDedupCore(inner, time.Hour, DedupByMessage, nil))
+
+	for i := 0; i < 5; i++ {
+		logger.Error("crash", zap.Int("attempt", i))
+	}
+	equals(1, recorded.Len(), t)
+
+	logger.Info("recovered")
+	entries := recorded.AllUntimed()
+	equals(3, len(entries), t)
+	equals("crash", entries[0].Message, t)
+	equals(int64(0), entries[0].ContextMap()["attempt"], t)
+	equals("crash", entries[1].Message, t)
+	equals(int64(4), entries[1].ContextMap()["repeated"], t)
+	equals(int64(4), entries[1].ContextMap()["attempt"], t)
+	equals("recovered", entries[2].Message, t)
+	if _, ok := entries[2].ContextMap()["repeated"]; ok {
+		t.Fatal("unexpected repeated field")
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	end, restore := fakeDedupWindows()
+	defer restore()
+	inner, recorded := observer.New(zapcore.DebugLevel)
+	logger := zap.New(newDedupCore(inner, time.Minute, DedupByMessage, nil))
+
+	logger.Info("tick")
+	logger.Info("tick")
+	equals(1, recorded.Len(), t)
+	// the repeats are written once the window elapses
+	end()
+	equals(2, recorded.Len(), t)
+	equals(int64(1), recorded.All()[1].ContextMap()["repeated"], t)
+
+	logger.Info("tick")
+	equals(3, recorded.Len(), t)
+	isNil(logger.Sync(), t)
+	equals(3, recorded.Len(), t)
+
+	// nor is an entry a repeat once the window is over
+	logger.Info("tick")
+	fakeCurrentTime = fakeCurrentTime.Add(time.Minute)
+	logger.Info("tick")
+	equals(5, recorded.Len(), t)
+}
+
+func TestDedupWith(t *testing.T) {
+	inner, recorded := observer.New(zapcore.DebugLevel)
+	logger := zap.New(newDedupCore(inner, time.Hour, DedupByMessage, nil))
+
+	// the loggers derived by With share the window of their parent
+	for i := 0; i < 5; i++ {
+		logger.With(zap.Int("request", i)).Error("crash")
+	}
+	equals(1, recorded.Len(), t)
+	isNil(logger.Sync(), t)
+	entries := recorded.AllUntimed()
+	equals(2, len(entries), t)
+	equals(int64(4), entries[1].ContextMap()["repeated"], t)
+	equals(int64(4), entries[1].ContextMap()["request"], t)
+}
+
+func TestDedupWithByMessageAndFields(t *testing.T) {
+	inner, recorded := observer.New(zapcore.DebugLevel)
+	logger := zap.New(newDedupCore(inner, time.Hour, DedupByMessageAndFields, nil))
+
+	// the fields of With are part of the key
+	logger.With(zap.String("path", "/a")).Info("request")
+	logger.With(zap.String("path", "/a")).Info("request")
+	logger.With(zap.String("path", "/b")).Info("request")
+	isNil(logger.Sync(), t)
+
+	entries := recorded.AllUntimed()
+	equals(3, len(entries), t)
+	equals(int64(1), entries[1].ContextMap()["repeated"], t)
+	equals("/b", entries[2].ContextMap()["path"], t)
+}
+
+func TestDedupByMessageAndFields(t *testing.T) {
+	inner, recorded := observer.New(zapcore.DebugLevel)
+	logger := zap.New(newDedupCore(inner, time.Hour, DedupByMessageAndFields, nil))
+
+	logger.Info("request", zap.String("path", "/a"))
+	logger.Info("request", zap.String("path", "/a"))
+	logger.Info("request", zap.String("path", "/b"))
+	isNil(logger.Sync(), t)
+
+	entries := recorded.AllUntimed()
+	equals(3, len(entries), t)
+	equals(int64(1), entries[1].ContextMap()["repeated"], t)
+	equals("/b", entries[2].ContextMap()["path"], t)
+}
+
+func TestDedupWriteError(t *testing.T) {
+	var out bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(brokenWriter{}), WithErrorOutput(&out), WithDedup(time.Hour, nil)), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestDedupWriteError")
+	logger.Info("lost")
+	equals(1, strings.Count(out.String(), "write error: no space left on device"), t)
+	// the repeat is only written, and fails, once flushed
+	logger.Info("lost")
+	equals(1, strings.Count(out.String(), "no space left on device"), t)
+	if err := logger.Sync(); err == nil || !strings.Contains(err.Error(), "no space left on device") {
+		t.Fatalf("expected the flush to fail, got %v", err)
+	}
+}
+
+func TestDedupWindowWriteError(t *testing.T) {
+	end, restore := fakeDedupWindows()
+	defer restore()
+	var out bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(brokenWriter{}), WithErrorOutput(&out), WithDedup(time.Minute, nil)), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestDedupWindowWriteError")
+	logger.Info("lost")
+	logger.Info("lost")
+	equals(1, strings.Count(out.String(), "no space left on device"), t)
+	// the error of writing the repeat once the window elapses is reported
+	end()
+	equals(2, strings.Count(out.String(), "write error: no space left on device"), t)
+}
+
+func TestDedupReinitWriteError(t *testing.T) {
+	var out bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(brokenWriter{}), WithErrorOutput(&out), WithDedup(time.Hour, nil)), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestDedupReinitWriteError")
+	logger.Info("lost")
+	logger.Info("lost")
+	// the repeat written when the loggers are replaced fails too
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf)), t)
+	equals(1, strings.Count(out.String(), "close error: no space left on device"), t)
+}
+
+func TestDedupSyncWith(t *testing.T) {
+	end, restore := fakeDedupWindows()
+	defer restore()
+	inner, recorded := observer.New(zapcore.DebugLevel)
+	logger := zap.New(newDedupCore(inner, time.Minute, DedupByMessage, nil))
+	child := logger.With(zap.String("request", "a"))
+
+	child.Info("tick")
+	child.Info("tick")
+	equals(1, recorded.Len(), t)
+	// syncing the root flushes the repeats of the loggers derived from it
+	isNil(logger.Sync(), t)
+	equals(2, recorded.Len(), t)
+	equals(int64(1), recorded.All()[1].ContextMap()["repeated"], t)
+	// and stops the timer
+	end()
+	equals(2, recorded.Len(), t)
+}
+
+func TestDedupReinit(t *testing.T) {
+	end, restore := fakeDedupWindows()
+	defer restore()
+	defer initDefaults(t)
+	dir := makeTempDir("TestDedupReinit", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil, WithDedup(time.Minute, nil)), t)
+	logger := GetLogger("app")
+	logger.Info("tick")
+	logger.Info("tick")
+	w := loggers.get("app").writer
+
+	// a new InitLogger writes the repeats before closing the old file
+	other := makeTempDir("TestDedupReinitOther", t)
+	defer os.RemoveAll(other)
+	isNil(InitLogger(other, false, nil), t)
+	entries := readEntries(filepath.Join(dir, "app.log"), t)
+	equals(2, len(entries), t)
+	equals(float64(1), entries[1]["repeated"], t)
+	// no timer fires later, reopening the closed file
+	end()
+	equals(true, isClosed(w), t)
+	equals(2, len(readEntries(filepath.Join(dir, "app.log"), t)), t)
+}
+
+// fakeDedupWindows stops the clock at fakeCurrentTime and makes the windows of
+// WithDedup elapse only when end is called, until restore is.
+func fakeDedupWindows() (end, restore func()) {
+	var mu sync.Mutex
+	var fired []func()
+	start := fakeCurrentTime
+	currentTime = fakeTime
+	afterFunc = func(d time.Duration, f func()) *time.Timer {
+		mu.Lock()
+		defer mu.Unlock()
+		fired = append(fired, f)
+		// stopped when the window ends, never fired
+		return time.AfterFunc(time.Hour, func() {})
+	}
+	end = func() {
+		mu.Lock()
+		fs := fired
+		fired = nil
+		mu.Unlock()
+		for _, f := range fs {
+			f()
+		}
+	}
+	restore = func() {
+		fakeCurrentTime = start
+		currentTime = time.Now
+		afterFunc = time.AfterFunc
+	}
+	return end, restore
+}
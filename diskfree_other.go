@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!windows
+
+package zaphelper
+
+import "github.com/pkg/errors"
+
+// diskFree fails: there is no free space check wired up for this platform.
+func diskFree(dir string) (uint64, error) {
+	return 0, errors.New("free space check is not supported on this platform")
+}
@@ -0,0 +1,87 @@
+package zaphelper
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// errorOutputBurst is how many messages the error output of the loggers
+	// lets through per errorOutputInterval.  They are variables so tests can
+	// mock them out.
+	errorOutputBurst    = 10
+	errorOutputInterval = time.Minute
+)
+
+// WithErrorOutput sets where the loggers report their own failures, such as a
+// Writer that can't write to a full disk, instead of os.Stderr, e.g. the
+// FallbackWriter of the Writers.  The reports are rate-limited to 10 a minute
+// whatever the output, followed by the number of reports dropped, so that a
+// permanently broken sink doesn't flood it.
+func WithErrorOutput(w io.Writer) LoggerOption {
+	return func(o *options) {
+		o.errorOutput = w
+	}
+}
+
+// newErrorOutput returns the rate-limited error output of the loggers, writing
+// to w, or os.Stderr if nil.
+func newErrorOutput(w io.Writer) zapcore.WriteSyncer {
+	if w == nil {
+		w = os.Stderr
+	}
+	return &rateLimitedWriter{ws: zapcore.AddSync(w)}
+}
+
+// reportError writes err, met doing what outside of any Write, to ws, the
+// error output of the loggers, or os.Stderr if nil, formatted as zap reports
+// write errors.
+func reportError(ws io.Writer, what string, err error) {
+	if ws == nil {
+		ws = os.Stderr
+	}
+	fmt.Fprintf(ws, "%v %s error: %v\n", time.Now(), what, err)
+}
+
+// rateLimitedWriter is a zapcore.WriteSyncer dropping the writes beyond
+// errorOutputBurst per errorOutputInterval, and writing how many it dropped
+// before the next one it lets through.
+type rateLimitedWriter struct {
+	ws zapcore.WriteSyncer
+
+	mu      sync.Mutex
+	start   time.Time
+	count   int
+	dropped int
+}
+
+func (r *rateLimitedWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := currentTime(); now.Sub(r.start) >= errorOutputInterval {
+		r.start = now
+		r.count = 0
+	}
+	if r.count >= errorOutputBurst {
+		r.dropped++
+		return len(p), nil
+	}
+	r.count++
+	if r.dropped > 0 {
+		// should the output fail, so does the report below, which is returned
+		_, _ = fmt.Fprintf(r.ws, "zaphelper: dropped %d error messages\n", r.dropped)
+		r.dropped = 0
+	}
+	return r.ws.Write(p)
+}
+
+func (r *rateLimitedWriter) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ws.Sync()
+}
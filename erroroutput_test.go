@@ -0,0 +1,43 @@
+package zaphelper
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// brokenWriter fails every write, like a Writer on a full disk.
+type brokenWriter struct{}
+
+func (brokenWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func TestErrorOutput(t *testing.T) {
+	now := fakeCurrentTime
+	currentTime = func() time.Time { return now }
+	defer func() { currentTime = time.Now }()
+	errorOutputBurst = 3
+	defer func() { errorOutputBurst = 10 }()
+
+	var out bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(brokenWriter{}), WithErrorOutput(&out)), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestErrorOutput")
+	for i := 0; i < 10; i++ {
+		logger.Info("lost")
+	}
+	equals(3, strings.Count(out.String(), "no space left on device"), t)
+	equals(false, strings.Contains(out.String(), "dropped"), t)
+
+	// the next window reports the drops first
+	now = now.Add(errorOutputInterval)
+	logger.Info("lost")
+	equals(4, strings.Count(out.String(), "no space left on device"), t)
+	if !strings.Contains(out.String(), "dropped 7 error messages\n") {
+		t.Fatalf("expected the drops to be reported: %s", out.String())
+	}
+}
@@ -0,0 +1,51 @@
+package zaphelper
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// stackTracer is implemented by the errors of github.com/pkg/errors that
+// recorded a stack.
+type stackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+// LogError logs msg at error level with err as the "error" field.  If err, or
+// an error it wraps, carries a pkg/errors stack, the stack of the innermost one
+// is attached as the "stack" field in place of the logger's own stacktrace, so
+// that it points at where the failure happened rather than where it was logged.
+func LogError(logger *zap.SugaredLogger, err error, msg string) {
+	raw := logger.Desugar().WithOptions(zap.AddCallerSkip(1))
+	st := originalStack(err)
+	if st == nil {
+		raw.Error(msg, zap.Error(err))
+		return
+	}
+	raw.WithOptions(zap.AddStacktrace(zapcore.FatalLevel+1)).Error(msg,
+		zap.String("error", err.Error()),
+		zap.String("stack", fmt.Sprintf("%+v", st)),
+	)
+}
+
+// originalStack returns the stack of the innermost error in err's chain of
+// causes that has one, or nil.
+func originalStack(err error) errors.StackTrace {
+	var st errors.StackTrace
+	for err != nil {
+		if tracer, ok := err.(stackTracer); ok {
+			st = tracer.StackTrace()
+		}
+		cause, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return st
+}
@@ -0,0 +1,43 @@
+package zaphelper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func failingOperation() error {
+	return errors.New("disk on fire")
+}
+
+func TestLogError(t *testing.T) {
+	logger, recorded := NewTestLogger()
+	LogError(logger, errors.Wrap(failingOperation(), "save failed"), "request failed")
+
+	entries := recorded.All()
+	equals(1, len(entries), t)
+	fields := entries[0].ContextMap()
+	equals("save failed: disk on fire", fields["error"], t)
+	stack, _ := fields["stack"].(string)
+	if !strings.Contains(stack, "failingOperation") {
+		t.Fatalf("expected the stack of the original error, got %q", stack)
+	}
+	equals("", entries[0].Stack, t)
+}
+
+func TestLogErrorWithoutStack(t *testing.T) {
+	logger, recorded := NewTestLogger()
+	LogError(logger, errPlain("plain"), "request failed")
+
+	entries := recorded.All()
+	equals(1, len(entries), t)
+	equals("plain", entries[0].ContextMap()["error"], t)
+	if _, ok := entries[0].ContextMap()["stack"]; ok {
+		t.Fatal("unexpected stack field")
+	}
+}
+
+type errPlain string
+
+func (e errPlain) Error() string { return string(e) }
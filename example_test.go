@@ -1,11 +1,15 @@
 package zaphelper
 
 import (
+	"log"
 	"time"
 )
 
 func Example() {
-	InitLogger("/tmp", false, time.Local)
+	if err := InitLogger("/tmp", false, time.Local); err != nil {
+		log.Fatal(err)
+	}
+	defer Sync()
 	logger := GetLogger("helloworld")
 	logger.Infow("hello log", "key", "value")
 	RotateLog()
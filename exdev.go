@@ -0,0 +1,16 @@
+//go:build !plan9
+// +build !plan9
+
+package zaphelper
+
+import (
+	"os"
+	"syscall"
+)
+
+// isCrossDevice reports whether err is a rename failing because source and
+// destination are on different filesystems.
+func isCrossDevice(err error) bool {
+	le, ok := err.(*os.LinkError)
+	return ok && le.Err == syscall.EXDEV
+}
@@ -0,0 +1,7 @@
+package zaphelper
+
+// isCrossDevice reports false: renames never cross filesystems on Plan 9,
+// they only change the name within a directory.
+func isCrossDevice(err error) bool {
+	return false
+}
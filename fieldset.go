@@ -0,0 +1,81 @@
+package zaphelper
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// fieldSetKey is the context key WithFieldSet stashes a FieldSet under.
+type fieldSetKey struct{}
+
+// FieldSet accumulates fields over the handling of a request, e.g. the user id
+// once authenticated and the tenant once looked up, for the final access log
+// line to carry them all without deriving a logger at each step.  It is safe
+// for concurrent use, and its methods are no-ops on a nil FieldSet, such as
+// FieldSetFromContext returns for a context without one.
+type FieldSet struct {
+	mu     sync.Mutex
+	fields []zap.Field
+}
+
+// NewFieldSet returns an empty FieldSet.
+func NewFieldSet() *FieldSet {
+	return &FieldSet{}
+}
+
+// Add sets the field key to value, replacing the value of a previous Add of
+// key in place, so that the fields keep the order they were first added in.
+func (s *FieldSet) Add(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	f := zap.Any(key, value)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.fields {
+		if s.fields[i].Key == key {
+			s.fields[i] = f
+			return
+		}
+	}
+	s.fields = append(s.fields, f)
+}
+
+// Fields returns a copy of the fields added so far, for zap.Logger.With or the
+// logging methods of a zap.Logger.
+func (s *FieldSet) Fields() []zap.Field {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields := make([]zap.Field, len(s.fields))
+	copy(fields, s.fields)
+	return fields
+}
+
+// Args returns the fields added so far as arguments for the logging methods of
+// a zap.SugaredLogger, such as Infow.
+func (s *FieldSet) Args() []interface{} {
+	fields := s.Fields()
+	args := make([]interface{}, len(fields))
+	for i, f := range fields {
+		args[i] = f
+	}
+	return args
+}
+
+// WithFieldSet returns a copy of ctx carrying s, for FieldSetFromContext to
+// retrieve.
+func WithFieldSet(ctx context.Context, s *FieldSet) context.Context {
+	return context.WithValue(ctx, fieldSetKey{}, s)
+}
+
+// FieldSetFromContext returns the FieldSet stashed in ctx by WithFieldSet, or
+// nil if there is none.
+func FieldSetFromContext(ctx context.Context) *FieldSet {
+	s, _ := ctx.Value(fieldSetKey{}).(*FieldSet)
+	return s
+}
@@ -0,0 +1,46 @@
+package zaphelper
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestFieldSet(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf)), t)
+	defer initDefaults(t)
+
+	authenticate := func(ctx context.Context) {
+		FieldSetFromContext(ctx).Add("user", "alice")
+	}
+	lookupTenant := func(ctx context.Context) {
+		FieldSetFromContext(ctx).Add("tenant", "acme")
+		FieldSetFromContext(ctx).Add("user", "alice@acme")
+	}
+	ctx := WithFieldSet(context.Background(), NewFieldSet())
+	authenticate(ctx)
+	lookupTenant(ctx)
+	FieldSetFromContext(ctx).Add("status", 200)
+
+	logger := GetLogger("TestFieldSet")
+	logger.Infow("request", FieldSetFromContext(ctx).Args()...)
+	logger.Desugar().Info("desugared", FieldSetFromContext(ctx).Fields()...)
+
+	entries := decodeEntries(buf.String(), t)
+	equals(2, len(entries), t)
+	for _, entry := range entries {
+		equals("alice@acme", entry["user"], t)
+		equals("acme", entry["tenant"], t)
+		equals(float64(200), entry["status"], t)
+	}
+	equals(3, len(FieldSetFromContext(ctx).Fields()), t)
+}
+
+func TestFieldSetMissing(t *testing.T) {
+	s := FieldSetFromContext(context.Background())
+	equals(true, s == nil, t)
+	s.Add("user", "alice")
+	equals(0, len(s.Fields()), t)
+	equals(0, len(s.Args()), t)
+}
@@ -0,0 +1,180 @@
+package zaphelper
+
+import (
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultFlattenDepth is the nesting depth WithFlatten flattens by default.
+const defaultFlattenDepth = 10
+
+// WithFlatten flattens the object fields of the entries into keys joined by
+// sep, "." if empty, e.g. {"user":{"id":1}} into {"user.id":1}, and likewise
+// for namespaces, for ingestors that only map flat keys.  Objects nested
+// deeper than maxDepth, 10 if not positive, are left as they are, as are the
+// objects in arrays, so that a pathological marshaler can't recurse forever.
+func WithFlatten(sep string, maxDepth int) LoggerOption {
+	return func(o *options) {
+		if sep == "" {
+			sep = "."
+		}
+		if maxDepth <= 0 {
+			maxDepth = defaultFlattenDepth
+		}
+		o.flattenSep = sep
+		o.flattenDepth = maxDepth
+	}
+}
+
+// flattenEncoder is a zapcore.Encoder adding the fields of the objects it is
+// given to the wrapped encoder under prefixed keys instead of nesting them.
+type flattenEncoder struct {
+	enc      zapcore.Encoder
+	sep      string
+	maxDepth int
+	// prefix is prepended to keys, for namespaces and nested objects, which
+	// are depth deep.
+	prefix string
+	depth  int
+}
+
+// newFlattenEncoder returns enc flattening objects into keys joined by sep, up
+// to maxDepth deep.
+func newFlattenEncoder(enc zapcore.Encoder, sep string, maxDepth int) *flattenEncoder {
+	return &flattenEncoder{enc: enc, sep: sep, maxDepth: maxDepth}
+}
+
+func (e *flattenEncoder) Clone() zapcore.Encoder {
+	clone := *e
+	clone.enc = e.enc.Clone()
+	return &clone
+}
+
+func (e *flattenEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	if e.prefix == "" && !hasNesting(fields) {
+		return e.enc.EncodeEntry(ent, fields)
+	}
+	line := e.Clone().(*flattenEncoder)
+	for _, f := range fields {
+		f.AddTo(line)
+	}
+	return line.enc.EncodeEntry(ent, nil)
+}
+
+// hasNesting reports whether any of fields would nest keys.
+func hasNesting(fields []zapcore.Field) bool {
+	for _, f := range fields {
+		if f.Type == zapcore.ObjectMarshalerType || f.Type == zapcore.NamespaceType {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *flattenEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	if e.depth >= e.maxDepth {
+		return e.enc.AddObject(e.prefix+key, marshaler)
+	}
+	nested := *e
+	nested.prefix = e.prefix + key + e.sep
+	nested.depth++
+	return marshaler.MarshalLogObject(&nested)
+}
+
+func (e *flattenEncoder) OpenNamespace(key string) {
+	e.prefix += key + e.sep
+}
+
+func (e *flattenEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	return e.enc.AddArray(e.prefix+key, marshaler)
+}
+
+func (e *flattenEncoder) AddBinary(key string, value []byte) {
+	e.enc.AddBinary(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddByteString(key string, value []byte) {
+	e.enc.AddByteString(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddBool(key string, value bool) {
+	e.enc.AddBool(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddComplex128(key string, value complex128) {
+	e.enc.AddComplex128(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddComplex64(key string, value complex64) {
+	e.enc.AddComplex64(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddDuration(key string, value time.Duration) {
+	e.enc.AddDuration(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddFloat64(key string, value float64) {
+	e.enc.AddFloat64(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddFloat32(key string, value float32) {
+	e.enc.AddFloat32(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddInt(key string, value int) {
+	e.enc.AddInt(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddInt64(key string, value int64) {
+	e.enc.AddInt64(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddInt32(key string, value int32) {
+	e.enc.AddInt32(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddInt16(key string, value int16) {
+	e.enc.AddInt16(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddInt8(key string, value int8) {
+	e.enc.AddInt8(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddString(key string, value string) {
+	e.enc.AddString(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddTime(key string, value time.Time) {
+	e.enc.AddTime(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddUint(key string, value uint) {
+	e.enc.AddUint(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddUint64(key string, value uint64) {
+	e.enc.AddUint64(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddUint32(key string, value uint32) {
+	e.enc.AddUint32(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddUint16(key string, value uint16) {
+	e.enc.AddUint16(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddUint8(key string, value uint8) {
+	e.enc.AddUint8(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddUintptr(key string, value uintptr) {
+	e.enc.AddUintptr(e.prefix+key, value)
+}
+
+func (e *flattenEncoder) AddReflected(key string, value interface{}) error {
+	return e.enc.AddReflected(e.prefix+key, value)
+}
@@ -0,0 +1,78 @@
+package zaphelper
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type flattenUser struct {
+	id   int
+	name string
+	city string
+}
+
+func (u flattenUser) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("id", u.id)
+	enc.AddString("name", u.name)
+	return enc.AddObject("address", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("city", u.city)
+		return enc.AddArray("lines", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			arr.AppendString(u.city)
+			return nil
+		}))
+	}))
+}
+
+func TestFlatten(t *testing.T) {
+	o := newOptions(false)
+	WithFlatten("", 0)(&o)
+	ent := zapcore.Entry{Time: time.Now(), Message: "login"}
+	user := flattenUser{42, "ann", "Paris"}
+
+	entry := encodeEntry(&o, ent, t, zap.Object("user", user), zap.String("plain", "value"))
+	equals(float64(42), entry["user.id"], t)
+	equals("ann", entry["user.name"], t)
+	equals("Paris", entry["user.address.city"], t)
+	equals("[Paris]", fmt.Sprint(entry["user.address.lines"]), t)
+	equals("value", entry["plain"], t)
+	equals(nil, entry["user"], t)
+
+	// namespaces are flattened too, and so are the fields added with With
+	enc, err := o.newEncoder()
+	isNil(err, t)
+	enc.AddObject("ctx", user)
+	enc.OpenNamespace("req")
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.Namespace("http"), zap.Int("status", 200)})
+	isNil(err, t)
+	entry = decodeEntries(buf.String(), t)[0]
+	equals("Paris", entry["ctx.address.city"], t)
+	equals(float64(200), entry["req.http.status"], t)
+}
+
+func TestFlattenSeparatorAndDepth(t *testing.T) {
+	o := newOptions(false)
+	WithFlatten("_", 1)(&o)
+	ent := zapcore.Entry{Time: time.Now(), Message: "login"}
+
+	entry := encodeEntry(&o, ent, t, zap.Object("user", flattenUser{42, "ann", "Paris"}))
+	equals(float64(42), entry["user_id"], t)
+	address, ok := entry["user_address"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected the address to stay nested: %v", entry)
+	}
+	equals("Paris", address["city"], t)
+}
+
+func TestFlattenPlainFields(t *testing.T) {
+	o := newOptions(false)
+	WithFlatten("", 0)(&o)
+	plain := newOptions(false)
+	ent := zapcore.Entry{Time: time.Now(), Message: "login"}
+	fields := []zapcore.Field{zap.String("a", "b"), zap.Int("n", 1)}
+
+	equals(fmt.Sprint(encodeEntry(&plain, ent, t, fields...)), fmt.Sprint(encodeEntry(&o, ent, t, fields...)), t)
+}
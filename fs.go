@@ -0,0 +1,103 @@
+package zaphelper
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// fileSystem is what the Writer uses to reach its logfiles, backups and their
+// directories, so that tests can run rotation, cleanup and compression against
+// memory instead of the disk.  ExclusiveLock always works on the disk.
+type fileSystem interface {
+	// Open opens the named file for reading.
+	Open(name string) (file, error)
+	// OpenFile opens or creates the named file, as os.OpenFile does.
+	OpenFile(name string, flag int, perm os.FileMode) (file, error)
+	Stat(name string) (os.FileInfo, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	// ReadDir returns the entries of dirname sorted by name.
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	// Symlink creates newname as a symlink to oldname, as os.Symlink does.
+	Symlink(oldname, newname string) error
+}
+
+// file is an open file of a fileSystem.
+type file interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// osFS is the fileSystem of the os package.
+type osFS struct{}
+
+func (osFS) Open(name string) (file, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (file, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dirname)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+// walkFiles calls fn with the path and info of every file below dir, in
+// lexical order, skipping the entries removed while walking.
+func walkFiles(fs fileSystem, dir string, fn func(path string, info os.FileInfo)) error {
+	infos, err := fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if !info.IsDir() {
+			fn(path, info)
+			continue
+		}
+		if err := walkFiles(fs, path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package zaphelper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// renameFS is a fileSystem whose Rename is replaced by rename.
+type renameFS struct {
+	fileSystem
+	rename func(oldpath, newpath string) error
+}
+
+func (fs renameFS) Rename(oldpath, newpath string) error {
+	return fs.rename(oldpath, newpath)
+}
+
+// memFS is a fileSystem kept in memory.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+	dirs  map[string]bool
+	// links maps the symlinks to their targets.
+	links map[string]string
+	// syncs counts the calls to Sync on the files.
+	syncs int
+}
+
+type memData struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		files: make(map[string]*memData),
+		dirs:  map[string]bool{"/": true, ".": true},
+		links: make(map[string]string),
+	}
+}
+
+func (fs *memFS) Open(name string) (file, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (file, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = filepath.Clean(name)
+	d, ok := fs.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 || !fs.dirs[filepath.Dir(name)] {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		d = &memData{mode: perm, modTime: currentTime()}
+		fs.files[name] = d
+	}
+	if flag&os.O_TRUNC != 0 {
+		d.data = nil
+	}
+	return &memFile{fs: fs, name: name, d: d, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (fs *memFS) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = filepath.Clean(name)
+	if d, ok := fs.files[name]; ok {
+		return memInfo{filepath.Base(name), int64(len(d.data)), d.mode, d.modTime}, nil
+	}
+	if fs.dirs[name] {
+		return memInfo{filepath.Base(name), 0, os.ModeDir | 0744, time.Time{}}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *memFS) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	if target, ok := fs.links[oldpath]; ok && fs.dirs[filepath.Dir(newpath)] {
+		delete(fs.links, oldpath)
+		fs.links[newpath] = target
+		return nil
+	}
+	d, ok := fs.files[oldpath]
+	if !ok || !fs.dirs[filepath.Dir(newpath)] {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldpath)
+	fs.files[newpath] = d
+	return nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := fs.files[name]; ok {
+		delete(fs.files, name)
+		return nil
+	}
+	if _, ok := fs.links[name]; ok {
+		delete(fs.links, name)
+		return nil
+	}
+	if !fs.dirs[name] {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	for path := range fs.files {
+		if filepath.Dir(path) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+		}
+	}
+	for path := range fs.dirs {
+		if path != name && filepath.Dir(path) == name {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+		}
+	}
+	delete(fs.dirs, name)
+	return nil
+}
+
+func (fs *memFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	dirname = filepath.Clean(dirname)
+	if !fs.dirs[dirname] {
+		return nil, &os.PathError{Op: "open", Path: dirname, Err: os.ErrNotExist}
+	}
+	var infos []os.FileInfo
+	for path, d := range fs.files {
+		if filepath.Dir(path) == dirname {
+			infos = append(infos, memInfo{filepath.Base(path), int64(len(d.data)), d.mode, d.modTime})
+		}
+	}
+	for path := range fs.dirs {
+		if path != dirname && filepath.Dir(path) == dirname {
+			infos = append(infos, memInfo{filepath.Base(path), 0, os.ModeDir | 0744, time.Time{}})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (fs *memFS) MkdirAll(path string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for path = filepath.Clean(path); !fs.dirs[path]; path = filepath.Dir(path) {
+		if _, ok := fs.files[path]; ok {
+			return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrExist}
+		}
+		fs.dirs[path] = true
+	}
+	return nil
+}
+
+func (fs *memFS) Symlink(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	newname = filepath.Clean(newname)
+	_, isLink := fs.links[newname]
+	if _, ok := fs.files[newname]; ok || isLink || fs.dirs[newname] {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if !fs.dirs[filepath.Dir(newname)] {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	fs.links[newname] = oldname
+	return nil
+}
+
+// content returns the content of the named file, or nil if there is none.
+func (fs *memFS) content(name string) []byte {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if d, ok := fs.files[filepath.Clean(name)]; ok {
+		return append([]byte(nil), d.data...)
+	}
+	return nil
+}
+
+// names returns the sorted paths of all files.
+func (fs *memFS) names() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var names []string
+	for name := range fs.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// memFile is an open file of a memFS.
+type memFile struct {
+	fs     *memFS
+	name   string
+	d      *memData
+	off    int
+	append bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.off >= len(f.d.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.append {
+		f.off = len(f.d.data)
+	}
+	if end := f.off + len(p); end > len(f.d.data) {
+		f.d.data = append(f.d.data, make([]byte, end-len(f.d.data))...)
+	}
+	copy(f.d.data[f.off:], p)
+	f.off += len(p)
+	f.d.modTime = currentTime()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error { return nil }
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if int(size) < len(f.d.data) {
+		f.d.data = f.d.data[:size]
+	}
+	return nil
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	f.fs.syncs++
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return memInfo{filepath.Base(f.name), int64(len(f.d.data)), f.d.mode, f.d.modTime}, nil
+}
+
+// memInfo is the os.FileInfo of a memFS entry.
+type memInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() os.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() interface{}   { return nil }
+
+func TestMemFSRotateAndCleanup(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+	fs := newMemFS()
+
+	dir := "/logs"
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, MaxBackups: 2, fs: fs}
+	defer w.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := w.Write([]byte("boo!\n"))
+		isNil(err, t)
+		newFakeTime()
+		isNil(w.Rotate(), t)
+	}
+	isNil(w.PruneBackups(), t)
+
+	names := fs.names()
+	equals(3, len(names), t)
+	equals(filename, names[2], t)
+	for _, name := range names[:2] {
+		if !strings.HasPrefix(filepath.Base(name), "app-") {
+			t.Fatalf("unexpected file %s", name)
+		}
+		equals("boo!\n", string(fs.content(name)), t)
+	}
+	equals(0, len(fs.content(filename)), t)
+	// nothing reached the disk
+	notExist(dir, t)
+}
+
+func TestMemFSLinkName(t *testing.T) {
+	fs := newMemFS()
+	w := &Writer{Filename: "/logs/app.log", LinkName: "/logs/current.log", fs: fs}
+	defer w.Close()
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	equals("app.log", fs.links["/logs/current.log"], t)
+
+	isNil(fs.MkdirAll("/other", 0744), t)
+	isNil(w.SetFilename("/other/app.log"), t)
+	_, err = w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	equals("/other/app.log", fs.links["/logs/current.log"], t)
+	equals(1, len(fs.links), t)
+	// nothing reached the disk
+	notExist("/logs", t)
+}
+
+func TestMemFSCompress(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+	fs := newMemFS()
+
+	filename := filepath.Join("/logs", "app.log")
+	w := &Writer{Filename: filename, fs: fs}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+	isNil(w.CompressExisting(), t)
+
+	backups, err := w.Backups()
+	isNil(err, t)
+	equals(1, len(backups), t)
+	equals(true, backups[0].Compressed, t)
+
+	gz, err := gzip.NewReader(bytes.NewReader(fs.content(backups[0].Path)))
+	isNil(err, t)
+	content, err := ioutil.ReadAll(gz)
+	isNil(err, t)
+	equals(string(b), string(content), t)
+}
+
+func TestSyncOnWrite(t *testing.T) {
+	fs := newMemFS()
+	filename := filepath.Join("/logs", "app.log")
+
+	for _, w := range []*Writer{
+		{Filename: filename, SyncOnWrite: true, fs: fs},
+		{Filename: filename, SyncOnWrite: true, BufferSize: 1024, fs: fs},
+	} {
+		fs.syncs = 0
+		before := len(fs.content(filename))
+		_, err := w.Write([]byte("boo!\n"))
+		isNil(err, t)
+		equals(1, fs.syncs, t)
+		_, err = w.WriteString("foo!\n")
+		isNil(err, t)
+		equals(2, fs.syncs, t)
+		// nothing is left in the buffer
+		equals(before+10, len(fs.content(filename)), t)
+		isNil(w.Close(), t)
+	}
+
+	fs.syncs = 0
+	w := &Writer{Filename: filename, fs: fs}
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	isNil(w.Close(), t)
+	equals(0, fs.syncs, t)
+}
+
+func TestMemFSSubdirLayout(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+	fs := newMemFS()
+
+	w := &Writer{Filename: filepath.Join("/logs", "app.log"), SubdirLayout: "2006-01", MaxBackups: 1, fs: fs}
+	defer w.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := w.Write([]byte("boo!\n"))
+		isNil(err, t)
+		newFakeTime()
+		isNil(w.Rotate(), t)
+	}
+	isNil(w.PruneBackups(), t)
+
+	sub := filepath.Join("/logs", fakeTime().UTC().Format("2006-01"))
+	infos, err := fs.ReadDir(sub)
+	isNil(err, t)
+	equals(1, len(infos), t)
+}
@@ -0,0 +1,127 @@
+package zaphelper
+
+import (
+	"os"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// gelfVersion is the version of the GELF format written.
+const gelfVersion = "1.1"
+
+// WithGELFHost sets the "host" of the entries encoded with EncodingGELF,
+// which defaults to the hostname.
+func WithGELFHost(host string) LoggerOption {
+	return func(o *options) {
+		o.gelfHost = host
+	}
+}
+
+// gelfLevel encodes a level as the numeric syslog severity GELF expects.
+func gelfLevel(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendInt(gelfSeverity(l))
+}
+
+// gelfSeverity returns the syslog severity of l.
+func gelfSeverity(l zapcore.Level) int {
+	switch l {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	default:
+		// DPanic, Panic and Fatal
+		return 2
+	}
+}
+
+// gelfEncoder is a zapcore.Encoder writing entries as GELF JSON objects for
+// Graylog: the message is the "short_message", the time the "timestamp" in
+// seconds since the epoch, the level the syslog severity, and the keys of
+// the fields are prefixed with an underscore, as additional fields.  Objects
+// nested in fields are encoded as JSON objects with their keys untouched.
+type gelfEncoder struct {
+	zapcore.Encoder
+}
+
+// newGELFEncoder returns a GELF encoder.  Only the line ending and the
+// encoders of durations and callers are taken from cfg; host is the
+// machine's hostname if empty.
+func newGELFEncoder(cfg zapcore.EncoderConfig, host string) *gelfEncoder {
+	if host == "" {
+		var err error
+		if host, err = os.Hostname(); err != nil {
+			host = "unknown"
+		}
+	}
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		MessageKey:     "short_message",
+		TimeKey:        "timestamp",
+		LevelKey:       "level",
+		NameKey:        "_logger",
+		CallerKey:      "_caller",
+		StacktraceKey:  "full_message",
+		LineEnding:     cfg.LineEnding,
+		EncodeTime:     zapcore.EpochTimeEncoder,
+		EncodeLevel:    gelfLevel,
+		EncodeDuration: cfg.EncodeDuration,
+		EncodeCaller:   cfg.EncodeCaller,
+		EncodeName:     zapcore.FullNameEncoder,
+	})
+	enc.AddString("version", gelfVersion)
+	enc.AddString("host", host)
+	return &gelfEncoder{Encoder: enc}
+}
+
+func (e *gelfEncoder) Clone() zapcore.Encoder {
+	return &gelfEncoder{Encoder: e.Encoder.Clone()}
+}
+
+func (e *gelfEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	enc := e
+	if len(fields) > 0 {
+		enc = e.Clone().(*gelfEncoder)
+		for _, f := range fields {
+			f.AddTo(enc)
+		}
+	}
+	return enc.Encoder.EncodeEntry(ent, nil)
+}
+
+func (e *gelfEncoder) AddArray(key string, v zapcore.ArrayMarshaler) error {
+	return e.Encoder.AddArray("_"+key, v)
+}
+func (e *gelfEncoder) AddObject(key string, v zapcore.ObjectMarshaler) error {
+	return e.Encoder.AddObject("_"+key, v)
+}
+func (e *gelfEncoder) AddBinary(key string, v []byte)          { e.Encoder.AddBinary("_"+key, v) }
+func (e *gelfEncoder) AddByteString(key string, v []byte)      { e.Encoder.AddByteString("_"+key, v) }
+func (e *gelfEncoder) AddBool(key string, v bool)              { e.Encoder.AddBool("_"+key, v) }
+func (e *gelfEncoder) AddComplex128(key string, v complex128)  { e.Encoder.AddComplex128("_"+key, v) }
+func (e *gelfEncoder) AddComplex64(key string, v complex64)    { e.Encoder.AddComplex64("_"+key, v) }
+func (e *gelfEncoder) AddDuration(key string, v time.Duration) { e.Encoder.AddDuration("_"+key, v) }
+func (e *gelfEncoder) AddFloat64(key string, v float64)        { e.Encoder.AddFloat64("_"+key, v) }
+func (e *gelfEncoder) AddFloat32(key string, v float32)        { e.Encoder.AddFloat32("_"+key, v) }
+func (e *gelfEncoder) AddInt(key string, v int)                { e.Encoder.AddInt("_"+key, v) }
+func (e *gelfEncoder) AddInt64(key string, v int64)            { e.Encoder.AddInt64("_"+key, v) }
+func (e *gelfEncoder) AddInt32(key string, v int32)            { e.Encoder.AddInt32("_"+key, v) }
+func (e *gelfEncoder) AddInt16(key string, v int16)            { e.Encoder.AddInt16("_"+key, v) }
+func (e *gelfEncoder) AddInt8(key string, v int8)              { e.Encoder.AddInt8("_"+key, v) }
+func (e *gelfEncoder) AddString(key, v string)                 { e.Encoder.AddString("_"+key, v) }
+func (e *gelfEncoder) AddTime(key string, v time.Time)         { e.Encoder.AddTime("_"+key, v) }
+func (e *gelfEncoder) AddUint(key string, v uint)              { e.Encoder.AddUint("_"+key, v) }
+func (e *gelfEncoder) AddUint64(key string, v uint64)          { e.Encoder.AddUint64("_"+key, v) }
+func (e *gelfEncoder) AddUint32(key string, v uint32)          { e.Encoder.AddUint32("_"+key, v) }
+func (e *gelfEncoder) AddUint16(key string, v uint16)          { e.Encoder.AddUint16("_"+key, v) }
+func (e *gelfEncoder) AddUint8(key string, v uint8)            { e.Encoder.AddUint8("_"+key, v) }
+func (e *gelfEncoder) AddUintptr(key string, v uintptr)        { e.Encoder.AddUintptr("_"+key, v) }
+func (e *gelfEncoder) AddReflected(key string, v interface{}) error {
+	return e.Encoder.AddReflected("_"+key, v)
+}
+func (e *gelfEncoder) OpenNamespace(key string) { e.Encoder.OpenNamespace("_" + key) }
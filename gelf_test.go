@@ -0,0 +1,70 @@
+package zaphelper
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestGELFEncoder(t *testing.T) {
+	o := newOptions(false)
+	WithEncoding(EncodingGELF)(&o)
+	enc, err := o.newEncoder()
+	isNil(err, t)
+	enc.AddString("app", "demo")
+
+	ent := zapcore.Entry{
+		Level:      zapcore.ErrorLevel,
+		Time:       time.Unix(1519905600, 500000000),
+		LoggerName: "http",
+		Message:    "request failed",
+		Stack:      "main.main\n\tmain.go:1",
+	}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{
+		zap.Int("status", 500),
+		zap.Object("user", logfmtUser{name: "alice"}),
+	})
+	isNil(err, t)
+
+	entry := map[string]interface{}{}
+	isNil(json.Unmarshal(buf.Bytes(), &entry), t)
+	host, _ := os.Hostname()
+	equals("1.1", entry["version"], t)
+	equals(host, entry["host"], t)
+	equals("request failed", entry["short_message"], t)
+	equals(1519905600.5, entry["timestamp"], t)
+	equals(float64(3), entry["level"], t)
+	equals("http", entry["_logger"], t)
+	equals("main.main\n\tmain.go:1", entry["full_message"], t)
+	equals("demo", entry["_app"], t)
+	equals(float64(500), entry["_status"], t)
+	user, _ := entry["_user"].(map[string]interface{})
+	equals("alice", user["name"], t)
+	equals(10, len(entry), t)
+}
+
+func TestGELFHost(t *testing.T) {
+	o := newOptions(false)
+	WithEncoding(EncodingGELF)(&o)
+	WithGELFHost("web-1")(&o)
+	entry := encodeEntry(&o, zapcore.Entry{Message: "hello"}, t)
+	equals("web-1", entry["host"], t)
+}
+
+func TestGELFSeverity(t *testing.T) {
+	for l, severity := range map[zapcore.Level]int{
+		zapcore.DebugLevel:  7,
+		zapcore.InfoLevel:   6,
+		zapcore.WarnLevel:   4,
+		zapcore.ErrorLevel:  3,
+		zapcore.DPanicLevel: 2,
+		zapcore.PanicLevel:  2,
+		zapcore.FatalLevel:  2,
+	} {
+		equals(severity, gelfSeverity(l), t)
+	}
+}
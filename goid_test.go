@@ -0,0 +1,72 @@
+package zaphelper
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWithGoroutineID(t *testing.T) {
+	var buf syncBuffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithGoroutineID(true)), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestWithGoroutineID")
+	const goroutines = 8
+	ids := make(chan int64, goroutines)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids <- goroutineID()
+			logger.Infow("hello", "i", i)
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+	want := map[float64]bool{}
+	for id := range ids {
+		want[float64(id)] = true
+	}
+	equals(goroutines, len(want), t)
+
+	entries := decodeEntries(buf.String(), t)
+	equals(goroutines, len(entries), t)
+	for _, entry := range entries {
+		id, _ := entry["goid"].(float64)
+		if !want[id] {
+			t.Fatalf("expected the goid of a logging goroutine, got %v", entry)
+		}
+		delete(want, id)
+	}
+}
+
+func TestNoGoroutineID(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf)), t)
+	defer initDefaults(t)
+
+	GetLogger("TestNoGoroutineID").Info("hello")
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	if _, ok := entries[0]["goid"]; ok {
+		t.Fatalf("unexpected goid in %v", entries[0])
+	}
+}
+
+func BenchmarkGoroutineID(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		goroutineID()
+	}
+}
+
+func TestGoroutineIDWriteError(t *testing.T) {
+	var out bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(brokenWriter{}), WithErrorOutput(&out), WithGoroutineID(true)), t)
+	defer initDefaults(t)
+
+	GetLogger("TestGoroutineIDWriteError").Info("lost")
+	equals(1, strings.Count(out.String(), "write error: no space left on device"), t)
+}
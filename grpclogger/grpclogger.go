@@ -0,0 +1,196 @@
+// Package grpclogger provides gRPC interceptors logging each call through
+// zaphelper, kept out of the zaphelper package so that it doesn't depend on
+// gRPC.
+package grpclogger
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/yeeuu/zaphelper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// CodeToLevel returns the level a call finishing with code is logged at.
+type CodeToLevel func(code codes.Code) zapcore.Level
+
+// DefaultCodeToLevel logs successful calls at info level and failed ones at
+// error level.
+func DefaultCodeToLevel(code codes.Code) zapcore.Level {
+	if code == codes.OK {
+		return zapcore.InfoLevel
+	}
+	return zapcore.ErrorLevel
+}
+
+// Option configures the interceptors.
+type Option func(*options)
+
+type options struct {
+	logger       *zap.SugaredLogger
+	levels       CodeToLevel
+	requestIDKey string
+}
+
+// WithLogger makes the interceptors log through logger instead of
+// zaphelper.GetLogger("grpc").
+func WithLogger(logger *zap.SugaredLogger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithLevels sets the level calls are logged at by their status code,
+// DefaultCodeToLevel by default.
+func WithLevels(levels CodeToLevel) Option {
+	return func(o *options) {
+		o.levels = levels
+	}
+}
+
+// WithRequestIDKey sets the metadata key the request id is read from,
+// "x-request-id" by default.
+func WithRequestIDKey(key string) Option {
+	return func(o *options) {
+		o.requestIDKey = key
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		levels:       DefaultCodeToLevel,
+		requestIDKey: "x-request-id",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// getLogger returns the logger to log through.  GetLogger is called on every
+// call so that the interceptors pick up InitLogger run after they were built.
+func (o *options) getLogger() *zap.SugaredLogger {
+	if o.logger != nil {
+		return o.logger
+	}
+	return zaphelper.GetLogger("grpc")
+}
+
+// serverContext returns ctx carrying the request id found in the incoming
+// metadata, if any, and a logger with it for zaphelper.LoggerFromContext.
+func (o *options) serverContext(ctx context.Context) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(o.requestIDKey); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	logger := o.getLogger()
+	if id != "" {
+		ctx = context.WithValue(ctx, zaphelper.RequestIDKey, id)
+		logger = logger.With("request_id", id)
+	}
+	return zaphelper.WithContext(ctx, logger), id
+}
+
+// clientRequestID returns the request id found in the outgoing metadata of
+// ctx, if any.
+func (o *options) clientRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if ids := md.Get(o.requestIDKey); len(ids) > 0 {
+			return ids[0]
+		}
+	}
+	return ""
+}
+
+// log logs a finished call.
+func (o *options) log(ctx context.Context, msg, method, requestID string, start time.Time, err error) {
+	code := status.Code(err)
+	fields := []zap.Field{
+		zap.String("grpc.service", path.Dir(method)[1:]),
+		zap.String("grpc.method", path.Base(method)),
+		zap.String("grpc.code", code.String()),
+		zap.Duration("grpc.duration", time.Since(start)),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer.address", p.Addr.String()))
+	}
+	if requestID != "" {
+		fields = append(fields, zap.String("request_id", requestID))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	if ce := o.getLogger().Desugar().Check(o.levels(code), msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor logging each unary call
+// served.  The handler's context carries the request id and a logger with it,
+// retrieved with zaphelper.LoggerFromContext.
+func UnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, id := o.serverContext(ctx)
+		resp, err := handler(ctx, req)
+		o.log(ctx, "finished unary call", info.FullMethod, id, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns an interceptor logging each streaming call
+// served, once it is over.  The handler's context is set up as for
+// UnaryServerInterceptor.
+func StreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, id := o.serverContext(ss.Context())
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		o.log(ctx, "finished streaming call", info.FullMethod, id, start, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor logging each unary call made.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		o.log(ctx, "finished client unary call", method, o.clientRequestID(ctx), start, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns an interceptor logging each streaming call
+// made, once the stream is established.
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	o := newOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		o.log(ctx, "started client streaming call", method, o.clientRequestID(ctx), start, err)
+		return cs, err
+	}
+}
+
+// serverStream overrides the context of a grpc.ServerStream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,192 @@
+package grpclogger
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yeeuu/zaphelper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthServer records the request id of the logger in the context of the
+// calls it serves.
+type healthServer struct {
+	*health.Server
+	logged chan *zap.SugaredLogger
+}
+
+func (s *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.logged <- zaphelper.LoggerFromContext(ctx)
+	return s.Server.Check(ctx, req)
+}
+
+// serve starts an in-process server with the interceptors built from opts, and
+// returns a client connected to it.
+func serve(t *testing.T, opts ...Option) (healthpb.HealthClient, *healthServer, func()) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(opts...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(opts...)),
+	)
+	hs := &healthServer{Server: health.NewServer(), logged: make(chan *zap.SugaredLogger, 1)}
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return healthpb.NewHealthClient(conn), hs, func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	client, hs, stop := serve(t, WithLogger(zap.New(core).Sugar()))
+	defer stop()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-1")
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	(<-hs.logged).Info("in handler")
+
+	handled := recorded.FilterMessage("in handler").AllUntimed()
+	if len(handled) != 1 || handled[0].ContextMap()["request_id"] != "req-1" {
+		t.Errorf("expected the handler's logger to carry the request id, got %v", handled)
+	}
+	entries := recorded.FilterMessage("finished unary call").AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", entries)
+	}
+	entry := entries[0]
+	fields := entry.ContextMap()
+	if entry.Level != zapcore.InfoLevel {
+		t.Errorf("expected info level, got %v", entry.Level)
+	}
+	if fields["grpc.service"] != "grpc.health.v1.Health" || fields["grpc.method"] != "Check" {
+		t.Errorf("unexpected method fields %v", fields)
+	}
+	if fields["grpc.code"] != "OK" || fields["request_id"] != "req-1" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	if _, ok := fields["grpc.duration"]; !ok {
+		t.Errorf("missing duration in %v", fields)
+	}
+	if _, ok := fields["peer.address"]; !ok {
+		t.Errorf("missing peer in %v", fields)
+	}
+}
+
+func TestUnaryServerInterceptorError(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	client, _, stop := serve(t, WithLogger(zap.New(core).Sugar()))
+	defer stop()
+
+	// the health server doesn't know that service
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+
+	entries := recorded.FilterMessage("finished unary call").AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", entries)
+	}
+	if entries[0].Level != zapcore.ErrorLevel {
+		t.Errorf("expected error level, got %v", entries[0].Level)
+	}
+	if entries[0].ContextMap()["grpc.code"] != "NotFound" {
+		t.Errorf("unexpected fields %v", entries[0].ContextMap())
+	}
+}
+
+func TestWithLevels(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	debugOK := func(code codes.Code) zapcore.Level {
+		if code == codes.OK {
+			return zapcore.DebugLevel
+		}
+		return DefaultCodeToLevel(code)
+	}
+	client, _, stop := serve(t, WithLogger(zap.New(core).Sugar()), WithLevels(debugOK))
+	defer stop()
+
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := recorded.FilterMessage("finished unary call").Len(); n != 0 {
+		t.Fatalf("expected the call to be logged at debug level, got %d entries", n)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	client, _, stop := serve(t, WithLogger(zap.New(core).Sugar()))
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	for i := 0; i < 100 && recorded.FilterMessage("finished streaming call").Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	entries := recorded.FilterMessage("finished streaming call").AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", recorded.AllUntimed())
+	}
+	if entries[0].ContextMap()["grpc.method"] != "Watch" {
+		t.Errorf("unexpected fields %v", entries[0].ContextMap())
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	interceptor := UnaryClientInterceptor(WithLogger(zap.New(core).Sugar()))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-2")
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.Unavailable, "down")
+	}
+	err := interceptor(ctx, "/pkg.Service/Method", nil, nil, nil, invoker)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the invoker's error, got %v", err)
+	}
+
+	entries := recorded.AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %v", entries)
+	}
+	fields := entries[0].ContextMap()
+	if entries[0].Level != zapcore.ErrorLevel || fields["grpc.code"] != "Unavailable" || fields["request_id"] != "req-2" {
+		t.Errorf("unexpected entry %v", entries[0])
+	}
+	if fields["grpc.service"] != "pkg.Service" || fields["grpc.method"] != "Method" {
+		t.Errorf("unexpected method fields %v", fields)
+	}
+}
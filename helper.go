@@ -1,66 +1,225 @@
 package zaphelper
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 type instance struct {
+	raw    *zap.Logger
 	logger *zap.SugaredLogger
+	// writer is nil unless the logger writes to its own file
 	writer *Writer
+	// stderr is set for the loggers used before InitLogger
+	stderr bool
+}
+
+// sinks are the outputs shared by all loggers, created by InitLogger.
+type sinks struct {
+	// errWriter receives the entries at error level and above, if set.
+	errWriter *Writer
+	// console receives the entries encoded for a console, if set, with the
+	// levels colored if consoleColor is.
+	console      zapcore.WriteSyncer
+	consoleColor bool
+	// custom receives the entries of all loggers instead of their files,
+	// if set.
+	custom zapcore.WriteSyncer
+	// syslog receives the entries of all loggers, if set.
+	syslog syslogWriter
+	// remote receives the entries of all loggers, if set.
+	remote *RemoteWriter
+	// journald receives the entries of all loggers, if set.
+	journald net.Conn
+}
+
+// close closes the shared outputs.
+func (s sinks) close() error {
+	var err error
+	if s.errWriter != nil {
+		err = s.errWriter.Close()
+	}
+	if s.syslog != nil {
+		err = multierr.Append(err, s.syslog.Close())
+	}
+	if s.remote != nil {
+		err = multierr.Append(err, s.remote.Close())
+	}
+	if s.journald != nil {
+		err = multierr.Append(err, s.journald.Close())
+	}
+	return err
+}
+
+// rotate rotates the shared files.
+func (s sinks) rotate() error {
+	if s.errWriter != nil {
+		return s.errWriter.Rotate()
+	}
+	return nil
 }
 
 type loggerMap struct {
 	lock      *sync.RWMutex
 	instances map[string]instance
+	shared    sinks
+	// initialized is false until InitLogger has run; until then loggers
+	// write to stderr.
+	initialized bool
 }
 
 var (
 	loggers = loggerMap{
-		new(sync.RWMutex),
-		make(map[string]instance),
+		lock:      new(sync.RWMutex),
+		instances: make(map[string]instance),
 	}
+	// directory and settings are guarded by loggers.lock
 	directory string
-	level     zapcore.LevelEnabler
+	settings  = newOptions(false)
+	// initLock serializes InitLogger and guards Logger and stopDaily
+	initLock sync.Mutex
+	// stopDaily stops the goroutine switching Logger to a new file every day
+	stopDaily chan struct{}
+	// level is shared by all loggers, so that SetLevel affects them at once
+	level = zap.NewAtomicLevel()
+	// consoleOutput exists so it can be mocked out by tests.
+	consoleOutput io.Writer = os.Stdout
 	// Logger zap.Logger实例
-	Logger *zap.SugaredLogger
+	// InitLogger 之前输出到 stderr
+	Logger = GetLogger("")
 )
 
 // InitLogger 初始化
 // path 输出路径
 // debugLevel 是否输出debug信息
 // location 日志文件名所属时区
-func InitLogger(path string, debugLevel bool, location *time.Location) {
-	directory = path
-	if debugLevel {
-		level = zap.DebugLevel
-	} else {
-		level = zap.InfoLevel
+// opts 其他配置, 如 WithLevel
+// 目录无法创建或日志文件无法打开时返回错误
+// 可重复调用: 之前的 logger 会被关闭并替换
+func InitLogger(path string, debugLevel bool, location *time.Location, opts ...LoggerOption) error {
+	return InitFromConfig(Config{Path: path, Options: opts, debugLevel: debugLevel, location: location})
+}
+
+// initLogger initializes the loggers writing to path from o, with initLock
+// held.
+func initLogger(path string, o options) error {
+	if o.writer == nil || o.errorFilename != "" {
+		if err := os.MkdirAll(path, 0744); err != nil {
+			if dirErr := checkDir(osFS{}, path); dirErr != nil {
+				return dirErr
+			}
+			return errors.Wrap(err, "can't make log directory")
+		}
+		if err := exists(path); err != nil {
+			return err
+		}
+	}
+	if _, err := o.newCore("", zapcore.AddSync(ioutil.Discard), sinks{}); err != nil {
+		return err
+	}
+	var shared sinks
+	if o.writer != nil {
+		shared.custom = zapcore.Lock(zapcore.AddSync(o.writer))
+	}
+	if o.console {
+		shared.console = zapcore.Lock(zapcore.AddSync(consoleOutput))
+		shared.consoleColor = o.consoleColored(consoleOutput)
+	}
+	if o.errorFilename != "" {
+		shared.errWriter = o.newWriter(filepath.Join(path, o.errorFilename))
+		if err := shared.errWriter.Open(); err != nil {
+			return err
+		}
+	}
+	if o.syslog != nil {
+		w, err := dialSyslog(o.syslog)
+		if err != nil {
+			shared.close()
+			return err
+		}
+		shared.syslog = w
 	}
+	if o.journald != nil {
+		conn, err := dialJournald()
+		if err != nil {
+			shared.close()
+			return err
+		}
+		shared.journald = conn
+	}
+	if o.remote != nil {
+		shared.remote = NewRemoteWriter(o.remote.network, o.remote.addr, o.remote.depth)
+	}
+	o.sampling = newSamplingSettings(*o.samplingParams())
+	o.errorSink = newErrorOutput(o.errorOutput)
 	//Fix time offset for Local
 	// lt := time.FixedZone("Asia/Shanghai", 8*60*60)
-	if location != nil {
-		time.Local = location
+	local := time.Local
+	if o.location != nil {
+		time.Local = o.location
+	}
+	// open the file of Logger before replacing the current loggers, which
+	// are kept if it fails
+	today := time.Now().Format("2006-01-02")
+	i := newInstance(today, path, &o, shared)
+	if i.writer != nil {
+		if err := i.writer.Open(); err != nil {
+			if o.location != nil {
+				time.Local = local
+			}
+			shared.close()
+			return err
+		}
 	}
+	level.SetLevel(o.level)
+	// loggers built from the previous settings are stale
+	loggers.reset(path, o, shared, map[string]instance{today: i})
 
-	Logger = GetLogger(time.Now().Format("2006-01-02"))
+	Logger = i.logger
+	if o.levelEnvErr != nil {
+		Logger.Warnw("ignoring the level from the environment", "error", o.levelEnvErr)
+	}
 
-	go func() {
-		lastFile := time.Now().Format("2006-01-02")
-		for {
-			time.Sleep(time.Minute)
-			if lastFile != time.Now().Format("2006-01-02") {
-				lastFile = time.Now().Format("2006-01-02")
-				Logger = GetLogger(lastFile)
-			}
+	if stopDaily != nil {
+		close(stopDaily)
+	}
+	stopDaily = make(chan struct{})
+	go daily(stopDaily)
+	return nil
+}
+
+// daily switches Logger to a file named after the new date every day, until
+// stop is closed.
+func daily(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	lastFile := time.Now().Format("2006-01-02")
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
 		}
-	}()
+		if lastFile != time.Now().Format("2006-01-02") {
+			lastFile = time.Now().Format("2006-01-02")
+			initLock.Lock()
+			Logger = GetLogger(lastFile)
+			initLock.Unlock()
+		}
+	}
 }
 
 // BeiJingTimeFormatter encodes the entry time as an RFC3339-formatted string under
@@ -71,73 +230,224 @@ func InitLogger(path string, debugLevel bool, location *time.Location) {
 // 	})
 // }
 
-func localTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-	t = t.Local()
-	enc.AppendString(t.Format("2006-01-02 15:04:05"))
+// reset closes and drops all cached loggers but those of instances, built from
+// dir, o and shared, so that the others are built again from them on next
+// use.
+func (l *loggerMap) reset(dir string, o options, shared sinks, instances map[string]instance) {
+	l.lock.Lock()
+	old, oldShared, oldErrors := l.instances, l.shared, settings.errorSink
+	l.instances = instances
+	l.shared = shared
+	directory = dir
+	settings = o
+	l.initialized = true
+	l.lock.Unlock()
+
+	if err := closeInstances(old, oldShared); err != nil {
+		// to where the old loggers reported their failures
+		reportError(oldErrors, "close", err)
+	}
+}
+
+// closeInstances syncs instances and closes their files and the shared
+// outputs.
+func closeInstances(instances map[string]instance, shared sinks) error {
+	// flush what the loggers hold back, such as the repeats of WithDedup,
+	// while their outputs are still open
+	var err error
+	for _, i := range instances {
+		if !i.stderr {
+			err = multierr.Append(err, i.raw.Sync())
+		}
+	}
+	err = multierr.Append(err, shared.close())
+	for _, i := range instances {
+		if i.writer != nil {
+			err = multierr.Append(err, i.writer.Close())
+		}
+	}
+	return err
 }
 
 func (l *loggerMap) Get(name string) *zap.SugaredLogger {
+	return l.get(name).logger
+}
+
+func (l *loggerMap) get(name string) instance {
+	l.lock.RLock()
 	i, ok := l.instances[name]
+	l.lock.RUnlock()
 	if !ok {
 		l.lock.Lock()
 		i, ok = l.instances[name]
-		if !ok {
-			writer := &Writer{
-				Filename: path.Join(directory, name+".log"),
-			}
-			ws := zapcore.AddSync(writer)
-			cfg := zapcore.EncoderConfig{
-				TimeKey:        "time",
-				LevelKey:       "level",
-				NameKey:        "logger",
-				CallerKey:      "caller",
-				MessageKey:     "message",
-				StacktraceKey:  "stacktrace",
-				EncodeLevel:    zapcore.LowercaseLevelEncoder,
-				EncodeTime:     localTimeEncoder,
-				EncodeDuration: zapcore.NanosDurationEncoder,
-			}
-			logger := zap.New(zapcore.NewCore(
-				zapcore.NewJSONEncoder(cfg),
-				ws,
-				level,
-			))
-			i = instance{
-				logger: logger.Sugar(),
-				writer: writer,
-			}
+		if !ok && !l.initialized {
+			i = newStderrInstance(name)
+			l.instances[name] = i
+		}
+		if !ok && l.initialized {
+			i = newInstance(name, directory, &settings, l.shared)
 			l.instances[name] = i
 		}
 		defer l.lock.Unlock()
 	}
-	return i.logger
+	return i
+}
+
+// newInstance returns the instance of the logger called name, from the
+// settings InitLogger validated: writing to the shared custom writer if set,
+// and to its own file in dir otherwise.
+func newInstance(name, dir string, o *options, shared sinks) instance {
+	if shared.custom != nil {
+		core, _ := o.newCore(name, shared.custom, shared)
+		logger := zap.New(core, o.zapOptions()...)
+		return instance{
+			raw:    logger,
+			logger: logger.Sugar(),
+		}
+	}
+	writer := o.newWriter(path.Join(dir, name+".log"))
+	core, _ := o.newCore(name, zapcore.AddSync(writer), shared)
+	logger := zap.New(core, o.zapOptions()...)
+	return instance{
+		raw:    logger,
+		logger: logger.Sugar(),
+		writer: writer,
+	}
+}
+
+// newStderrInstance returns the instance used for name before InitLogger has
+// run, which writes to stderr instead of a file.
+func newStderrInstance(name string) instance {
+	core, _ := settings.newCore(name, zapcore.Lock(os.Stderr), sinks{})
+	logger := zap.New(core, settings.zapOptions()...)
+	if name != "" {
+		logger = logger.Named(name)
+	}
+	return instance{
+		raw:    logger,
+		logger: logger.Sugar(),
+		stderr: true,
+	}
 }
 
 // RotateLog to causes Logger to close the existing log file
 // and immediately create a new one.
 func RotateLog() {
-	loggers.lock.Lock()
+	// rotate without the lock, which the OnRotate hooks may need to log
+	loggers.lock.RLock()
+	var writers []*Writer
+	for _, i := range loggers.instances {
+		if i.writer != nil {
+			writers = append(writers, i.writer)
+		}
+	}
+	shared := loggers.shared
+	loggers.lock.RUnlock()
+	for _, w := range writers {
+		w.Rotate()
+	}
+	shared.rotate()
+}
+
+// Sync flushes all loggers and their files, meant to be deferred in main.
+// Loggers writing to stderr before InitLogger are flushed too, but their
+// errors are ignored: syncing stderr fails with "invalid argument" on some
+// platforms.
+func Sync() error {
+	loggers.lock.RLock()
+	defer loggers.lock.RUnlock()
+	var err error
 	for _, i := range loggers.instances {
-		i.writer.Rotate()
+		errSync := i.raw.Sync()
+		if !i.stderr {
+			err = multierr.Append(err, errSync)
+		}
 	}
+	return err
+}
+
+// Shutdown syncs and closes all loggers and their files, stops the goroutines
+// of the package, such as the ones of HandleSIGHUP, waiting for the rotations
+// they are running, and resets it to its state
+// before InitLogger, where loggers write to stderr, so that a later InitLogger
+// starts afresh.  If ctx is done before the files are closed, Shutdown returns
+// ctx.Err() and they are closed in the background.  Calling it again is a
+// no-op.
+func Shutdown(ctx context.Context) error {
+	initLock.Lock()
+	defer initLock.Unlock()
+
+	if stopDaily != nil {
+		close(stopDaily)
+		stopDaily = nil
+	}
+	stopSIGHUP()
+
+	loggers.lock.Lock()
+	old, oldShared := loggers.instances, loggers.shared
+	loggers.instances = make(map[string]instance)
+	loggers.shared = sinks{}
+	loggers.initialized = false
+	directory = ""
+	settings = newOptions(false)
 	loggers.lock.Unlock()
+	level.SetLevel(settings.level)
+	Logger = GetLogger("")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- closeInstances(old, oldShared)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func exists(path string) error {
 	stat, err := os.Stat(path)
-	if err == nil {
-		return errors.Wrap(err, "directory")
-	}
 	if os.IsNotExist(err) {
 		return errors.New("path is not exists: " + path)
 	}
+	if err != nil {
+		return errors.Wrap(err, "directory")
+	}
 	if !stat.IsDir() {
 		return errors.New("path is not directory: " + path)
 	}
-	return err
+	return nil
+}
+
+// SetLevel changes the minimum level of all loggers at runtime
+func SetLevel(l zapcore.Level) {
+	level.SetLevel(l)
+}
+
+// GetLevel returns the current minimum level of all loggers
+func GetLevel() zapcore.Level {
+	return level.Level()
+}
+
+// LevelHandler returns an http.Handler reporting and changing the level of
+// all loggers, e.g. to be mounted at /loglevel of an admin mux.
+// GET responds with the current level as {"level":"info"}; PUT with the same
+// JSON body, e.g. curl -XPUT -d '{"level":"debug"}', changes it.
+func LevelHandler() http.Handler {
+	return level
 }
 
 // GetLogger to get zap.SugaredLogger
+// 同名的 logger 会被缓存复用, 直到再次调用 InitLogger
+// InitLogger 之前返回的 logger 输出到 stderr, 之后再调用 GetLogger 才会得到写文件的 logger
 func GetLogger(name string) *zap.SugaredLogger {
 	return loggers.Get(name)
 }
+
+// GetRawLogger to get the zap.Logger behind GetLogger(name), for hot paths
+// that want to avoid the overhead of the sugared API.  Both share the same
+// core, level and file.
+func GetRawLogger(name string) *zap.Logger {
+	return loggers.get(name).raw
+}
@@ -0,0 +1,684 @@
+package zaphelper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestInitLogger(t *testing.T) {
+	dir := makeTempDir("TestInitLogger", t)
+	defer os.RemoveAll(dir)
+
+	logDir := filepath.Join(dir, "logs")
+	isNil(InitLogger(logDir, false, nil), t)
+	existsWithContent(filepath.Join(logDir, time.Now().Format("2006-01-02")+".log"), []byte{}, t)
+}
+
+func TestInitLoggerNotDirectory(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerNotDirectory", t)
+	defer os.RemoveAll(dir)
+
+	notDir := filepath.Join(dir, "file")
+	isNil(ioutil.WriteFile(notDir, nil, 0644), t)
+
+	if err := InitLogger(notDir, false, nil); err == nil {
+		t.Fatal("expected an error for a log directory that is a file")
+	}
+	if err := InitLogger(filepath.Join(notDir, "logs"), false, nil); err == nil {
+		t.Fatal("expected an error for a log directory below a file")
+	}
+}
+
+func TestInitLoggerOpenFails(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerOpenFails", t)
+	defer os.RemoveAll(dir)
+
+	first := filepath.Join(dir, "first")
+	isNil(InitLogger(first, false, nil, WithLevel(zapcore.WarnLevel)), t)
+	defer initDefaults(t)
+
+	// the file of Logger can't be opened there
+	second := filepath.Join(dir, "second")
+	isNil(os.MkdirAll(filepath.Join(second, time.Now().Format("2006-01-02")+".log"), 0755), t)
+	if err := InitLogger(second, false, nil); err == nil {
+		t.Fatal("expected an error opening the logfile")
+	}
+
+	// the loggers, their level and the daily switch are still the first ones
+	equals(zapcore.WarnLevel, GetLevel(), t)
+	if stopDaily == nil {
+		t.Fatal("expected the daily goroutine to keep running")
+	}
+	logger := GetLogger("TestInitLoggerOpenFails")
+	logger.Info("dropped")
+	logger.Warn("written")
+	isNil(logger.Sync(), t)
+	entries := readEntries(filepath.Join(first, "TestInitLoggerOpenFails.log"), t)
+	equals(1, len(entries), t)
+	Logger.Warn("still open")
+	isNil(Logger.Sync(), t)
+	equals(1, len(readEntries(filepath.Join(first, time.Now().Format("2006-01-02")+".log"), t)), t)
+}
+
+func TestInitLoggerLevel(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerLevel", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, true, nil, WithLevel(zapcore.WarnLevel)), t)
+	logger := GetLogger("TestInitLoggerLevel")
+	logger.Info("dropped")
+	logger.Warn("written")
+	isNil(logger.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerLevel.log"), t)
+	equals(1, len(entries), t)
+	equals("written", entries[0]["message"], t)
+	equals("warn", entries[0]["level"], t)
+}
+
+func TestInitLoggerLevelOverrides(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithLevelOverrides(map[string]zapcore.Level{
+		"sql":       zapcore.DebugLevel,
+		"sql.quiet": zapcore.ErrorLevel,
+	})), t)
+	defer initDefaults(t)
+
+	GetLogger("sql").Debug("sql")
+	GetLogger("sql.orm").Debug("sql.orm")
+	GetLogger("sql.quiet").Warn("sql.quiet")
+	GetLogger("sqlite").Debug("sqlite")
+	GetLogger("http").Debug("http")
+	GetLogger("http").Info("http info")
+
+	entries := decodeEntries(buf.String(), t)
+	equals(3, len(entries), t)
+	equals("sql", entries[0]["message"], t)
+	equals("sql.orm", entries[1]["message"], t)
+	equals("http info", entries[2]["message"], t)
+}
+
+func TestInitLoggerLevelEnv(t *testing.T) {
+	isNil(os.Setenv(DefaultLevelEnv, "debug"), t)
+	defer os.Unsetenv(DefaultLevelEnv)
+	defer initDefaults(t)
+
+	isNil(InitLogger("", false, nil, WithWriter(ioutil.Discard)), t)
+	equals(zapcore.DebugLevel, GetLevel(), t)
+
+	// an explicit level wins
+	isNil(InitLogger("", false, nil, WithWriter(ioutil.Discard), WithLevel(zapcore.WarnLevel)), t)
+	equals(zapcore.WarnLevel, GetLevel(), t)
+	isNil(InitFromConfig(Config{Level: "error", Options: []LoggerOption{WithWriter(ioutil.Discard)}}), t)
+	equals(zapcore.ErrorLevel, GetLevel(), t)
+
+	isNil(os.Setenv("APP_LOG_LEVEL", "error"), t)
+	defer os.Unsetenv("APP_LOG_LEVEL")
+	isNil(InitLogger("", true, nil, WithWriter(ioutil.Discard), WithLevelEnv("APP_LOG_LEVEL")), t)
+	equals(zapcore.ErrorLevel, GetLevel(), t)
+	isNil(InitLogger("", true, nil, WithWriter(ioutil.Discard), WithLevelEnv("")), t)
+	equals(zapcore.DebugLevel, GetLevel(), t)
+}
+
+func TestInitLoggerBadLevelEnv(t *testing.T) {
+	isNil(os.Setenv(DefaultLevelEnv, "chatty"), t)
+	defer os.Unsetenv(DefaultLevelEnv)
+	defer initDefaults(t)
+
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf)), t)
+	equals(zapcore.InfoLevel, GetLevel(), t)
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	equals("warn", entries[0]["level"], t)
+	if !strings.Contains(entries[0]["error"].(string), "chatty") {
+		t.Fatalf("expected the bad value in the warning: %v", entries[0])
+	}
+}
+
+func TestInitLoggerDebugLevel(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerDebugLevel", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, true, nil), t)
+	logger := GetLogger("TestInitLoggerDebugLevel")
+	logger.Debug("written")
+	isNil(logger.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerDebugLevel.log"), t)
+	equals(1, len(entries), t)
+	equals("debug", entries[0]["level"], t)
+}
+
+func TestSetLevel(t *testing.T) {
+	dir := makeTempDir("TestSetLevel", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil), t)
+	defer SetLevel(zapcore.InfoLevel)
+	equals(zapcore.InfoLevel, GetLevel(), t)
+
+	first := GetLogger("TestSetLevelFirst")
+	first.Debug("dropped")
+	SetLevel(zapcore.DebugLevel)
+	equals(zapcore.DebugLevel, GetLevel(), t)
+	second := GetLogger("TestSetLevelSecond")
+	first.Debug("first")
+	second.Debug("second")
+
+	SetLevel(zapcore.ErrorLevel)
+	first.Warn("dropped")
+	second.Warn("dropped")
+	isNil(first.Sync(), t)
+	isNil(second.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestSetLevelFirst.log"), t)
+	equals(1, len(entries), t)
+	equals("first", entries[0]["message"], t)
+	entries = readEntries(filepath.Join(dir, "TestSetLevelSecond.log"), t)
+	equals(1, len(entries), t)
+	equals("second", entries[0]["message"], t)
+}
+
+func TestLevelHandler(t *testing.T) {
+	defer SetLevel(zapcore.InfoLevel)
+	SetLevel(zapcore.InfoLevel)
+
+	srv := httptest.NewServer(LevelHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	isNil(err, t)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	isNil(err, t)
+	equals(`{"level":"info"}`, strings.TrimSpace(string(body)), t)
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader(`{"level":"debug"}`))
+	isNil(err, t)
+	resp, err = http.DefaultClient.Do(req)
+	isNil(err, t)
+	resp.Body.Close()
+	equals(http.StatusOK, resp.StatusCode, t)
+	equals(zapcore.DebugLevel, GetLevel(), t)
+}
+
+func TestInitLoggerEncoding(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerEncoding", t)
+	defer os.RemoveAll(dir)
+
+	equals(EncodingJSON, newOptions(false).encoding, t)
+
+	isNil(InitLogger(dir, false, nil, WithEncoding(EncodingConsole)), t)
+	defer InitLogger(dir, false, nil)
+	logger := GetLogger("TestInitLoggerEncoding")
+	logger.Infow("hello", "key", "value")
+	isNil(logger.Sync(), t)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "TestInitLoggerEncoding.log"))
+	isNil(err, t)
+	fields := strings.Split(strings.TrimSpace(string(b)), "\t")
+	equals(4, len(fields), t)
+	equals("info", fields[1], t)
+	equals("hello", fields[2], t)
+	equals(`{"key": "value"}`, fields[3], t)
+}
+
+func TestInitLoggerUnknownEncoding(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerUnknownEncoding", t)
+	defer os.RemoveAll(dir)
+
+	if err := InitLogger(dir, false, nil, WithEncoding("xml")); err == nil {
+		t.Fatal("expected an error for an unknown encoding")
+	}
+}
+
+func TestInitLoggerKeys(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerKeys", t)
+	defer os.RemoveAll(dir)
+
+	keys := Keys{Time: "@timestamp", Level: "severity", Message: "msg"}
+	isNil(InitLogger(dir, false, nil, WithKeys(keys)), t)
+	defer InitLogger(dir, false, nil)
+	logger := GetLogger("TestInitLoggerKeys")
+	logger.Info("hello")
+	isNil(logger.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerKeys.log"), t)
+	equals(1, len(entries), t)
+	equals("info", entries[0]["severity"], t)
+	equals("hello", entries[0]["msg"], t)
+	if _, ok := entries[0]["@timestamp"]; !ok {
+		t.Fatalf("expected a @timestamp field, got %v", entries[0])
+	}
+	for _, key := range []string{DefaultKeys.Time, DefaultKeys.Level, DefaultKeys.Message} {
+		if _, ok := entries[0][key]; ok {
+			t.Fatalf("unexpected %s field in %v", key, entries[0])
+		}
+	}
+}
+
+func TestInitLoggerCallerSkip(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerCallerSkip", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil, WithCallerSkip(1), WithStacktraceLevel(zapcore.ErrorLevel)), t)
+	defer InitLogger(dir, false, nil)
+	logger := GetLogger("TestInitLoggerCallerSkip")
+	_, _, line, _ := runtime.Caller(0)
+	logThrough(logger, "hello")
+	logger.Error("failed")
+	isNil(logger.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerCallerSkip.log"), t)
+	equals(2, len(entries), t)
+	caller, _ := entries[0]["caller"].(string)
+	if want := fmt.Sprintf("/helper_test.go:%d", line+1); !strings.HasSuffix(caller, want) {
+		t.Fatalf("expected caller ending in %s, got %q", want, caller)
+	}
+	if _, ok := entries[0]["stacktrace"]; ok {
+		t.Fatalf("unexpected stacktrace below error level: %v", entries[0])
+	}
+	if _, ok := entries[1]["stacktrace"]; !ok {
+		t.Fatalf("expected a stacktrace at error level: %v", entries[1])
+	}
+}
+
+func TestInitLoggerNoCaller(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerNoCaller", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil), t)
+	logger := GetLogger("TestInitLoggerNoCaller")
+	logger.Error("failed")
+	isNil(logger.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerNoCaller.log"), t)
+	equals(1, len(entries), t)
+	for _, key := range []string{"caller", "stacktrace"} {
+		if _, ok := entries[0][key]; ok {
+			t.Fatalf("unexpected %s field in %v", key, entries[0])
+		}
+	}
+}
+
+func TestInitLoggerSampling(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerSampling", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil, WithSampling(2, 3, time.Minute)), t)
+	defer InitLogger(dir, false, nil)
+	logger := GetLogger("TestInitLoggerSampling")
+	for i := 0; i < 10; i++ {
+		logger.Infow("repeated", "i", i)
+	}
+	logger.Info("other")
+	isNil(logger.Sync(), t)
+
+	// the first 2, then every 3rd of the remaining 8
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerSampling.log"), t)
+	var is []float64
+	for _, entry := range entries[:len(entries)-1] {
+		is = append(is, entry["i"].(float64))
+	}
+	equals(fmt.Sprint([]float64{0, 1, 4, 7}), fmt.Sprint(is), t)
+	equals("other", entries[len(entries)-1]["message"], t)
+}
+
+func TestInitLoggerSamplingWith(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerSamplingWith", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil, WithSampling(2, 3, time.Minute)), t)
+	defer InitLogger(dir, false, nil)
+	logger := GetLogger("TestInitLoggerSamplingWith")
+	// the loggers derived by With share the counts of their parent
+	for i := 0; i < 10; i++ {
+		logger.With("i", i).Info("repeated")
+	}
+	isNil(logger.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerSamplingWith.log"), t)
+	var is []float64
+	for _, entry := range entries {
+		is = append(is, entry["i"].(float64))
+	}
+	equals(fmt.Sprint([]float64{0, 1, 4, 7}), fmt.Sprint(is), t)
+}
+
+func TestGetRawLogger(t *testing.T) {
+	dir := makeTempDir("TestGetRawLogger", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil), t)
+	raw := GetRawLogger("TestGetRawLogger")
+	raw.Info("raw", zap.Int("n", 1))
+	GetLogger("TestGetRawLogger").Infow("sugared", "n", 2)
+	isNil(raw.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestGetRawLogger.log"), t)
+	equals(2, len(entries), t)
+	equals("raw", entries[0]["message"], t)
+	equals(float64(1), entries[0]["n"], t)
+	equals("sugared", entries[1]["message"], t)
+}
+
+func TestGetLoggerCached(t *testing.T) {
+	dir := makeTempDir("TestGetLoggerCached", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil), t)
+	first := GetLogger("TestGetLoggerCached")
+	if GetLogger("TestGetLoggerCached") != first {
+		t.Fatal("expected the same logger for the same name")
+	}
+	if GetRawLogger("TestGetLoggerCached") != GetRawLogger("TestGetLoggerCached") {
+		t.Fatal("expected the same raw logger for the same name")
+	}
+	if GetLogger("TestGetLoggerCachedOther") == first {
+		t.Fatal("expected a different logger for a different name")
+	}
+
+	isNil(InitLogger(dir, false, nil), t)
+	if GetLogger("TestGetLoggerCached") == first {
+		t.Fatal("expected InitLogger to invalidate the cached logger")
+	}
+}
+
+func TestInitLoggerRepeatedly(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerRepeatedly", t)
+	defer os.RemoveAll(dir)
+
+	var writers []*Writer
+	for i := 0; i < 3; i++ {
+		isNil(InitLogger(dir, false, nil), t)
+		Logger.Info("hello")
+		GetLogger("TestInitLoggerRepeatedly").Info("hello")
+		writers = append(writers, loggers.get("TestInitLoggerRepeatedly").writer)
+	}
+	last := loggers.get("TestInitLoggerRepeatedly").writer
+	for _, w := range writers[:len(writers)-1] {
+		if w == last {
+			t.Fatal("expected a new writer after InitLogger")
+		}
+		equals(true, isClosed(w), t)
+	}
+	equals(false, isClosed(last), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerRepeatedly.log"), t)
+	equals(3, len(entries), t)
+}
+
+func TestGetLoggerBeforeInit(t *testing.T) {
+	loggers.lock.Lock()
+	loggers.instances = make(map[string]instance)
+	loggers.initialized = false
+	loggers.lock.Unlock()
+
+	r, w, err := os.Pipe()
+	isNil(err, t)
+	stderr := os.Stderr
+	os.Stderr = w
+	logger := GetLogger("TestGetLoggerBeforeInit")
+	os.Stderr = stderr
+
+	logger.Info("early")
+	isNil(w.Close(), t)
+	b, err := ioutil.ReadAll(r)
+	isNil(err, t)
+	entry := map[string]interface{}{}
+	isNil(json.Unmarshal(b, &entry), t)
+	equals("early", entry["message"], t)
+	equals("TestGetLoggerBeforeInit", entry["logger"], t)
+
+	dir := makeTempDir("TestGetLoggerBeforeInit", t)
+	defer os.RemoveAll(dir)
+	isNil(InitLogger(dir, false, nil), t)
+	GetLogger("TestGetLoggerBeforeInit").Info("late")
+	isNil(GetLogger("TestGetLoggerBeforeInit").Sync(), t)
+	entries := readEntries(filepath.Join(dir, "TestGetLoggerBeforeInit.log"), t)
+	equals(1, len(entries), t)
+	equals("late", entries[0]["message"], t)
+}
+
+func TestPackageSync(t *testing.T) {
+	dir := makeTempDir("TestPackageSync", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil), t)
+	GetLogger("TestSyncFirst").Info("first")
+	GetLogger("TestSyncSecond").Info("second")
+	isNil(Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestSyncFirst.log"), t)
+	equals(1, len(entries), t)
+	entries = readEntries(filepath.Join(dir, "TestSyncSecond.log"), t)
+	equals(1, len(entries), t)
+}
+
+func TestInitLoggerErrorFilename(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerErrorFilename", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil, WithErrorFilename("error.log"), WithWriterOptions(WithMaxBackups(1))), t)
+	defer InitLogger(dir, false, nil)
+	equals(1, loggers.shared.errWriter.MaxBackups, t)
+
+	first := GetLogger("TestInitLoggerErrorFilenameFirst")
+	second := GetLogger("TestInitLoggerErrorFilenameSecond")
+	first.Info("info")
+	first.Error("first error")
+	second.Warn("warn")
+	second.Error("second error")
+	isNil(Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerErrorFilenameFirst.log"), t)
+	equals(2, len(entries), t)
+	entries = readEntries(filepath.Join(dir, "TestInitLoggerErrorFilenameSecond.log"), t)
+	equals(2, len(entries), t)
+	entries = readEntries(filepath.Join(dir, "error.log"), t)
+	equals(2, len(entries), t)
+	equals("first error", entries[0]["message"], t)
+	equals("second error", entries[1]["message"], t)
+}
+
+func TestRotateLogHook(t *testing.T) {
+	dir := makeTempDir("TestRotateLogHook", t)
+	defer os.RemoveAll(dir)
+
+	var rotated []string
+	hook := func(w *Writer) {
+		w.OnRotate = func(oldPath, newPath string) {
+			// the hooks may log while the loggers rotate
+			GetLogger("TestRotateLogHookHook").Info("rotated")
+			rotated = append(rotated, oldPath)
+		}
+	}
+	isNil(InitLogger(dir, false, nil, WithWriterOptions(hook)), t)
+	defer InitLogger(dir, false, nil)
+
+	GetLogger("TestRotateLogHook").Info("before")
+	done := make(chan struct{})
+	go func() {
+		RotateLog()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RotateLog deadlocked")
+	}
+	// Logger's file and that of TestRotateLogHook
+	equals(2, len(rotated), t)
+	isNil(Sync(), t)
+	entries := readEntries(filepath.Join(dir, "TestRotateLogHookHook.log"), t)
+	equals(2, len(entries), t)
+}
+
+func TestInitLoggerConsole(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerConsole", t)
+	defer os.RemoveAll(dir)
+
+	var stdout bytes.Buffer
+	consoleOutput = &stdout
+	defer func() { consoleOutput = os.Stdout }()
+
+	isNil(InitLogger(dir, false, nil, WithConsole(true), WithConsoleLevel(zapcore.WarnLevel)), t)
+	defer InitLogger(dir, false, nil)
+	logger := GetLogger("TestInitLoggerConsole")
+	logger.Info("file only")
+	logger.Warnw("both", "key", "value")
+	isNil(logger.Sync(), t)
+
+	entries := readEntries(filepath.Join(dir, "TestInitLoggerConsole.log"), t)
+	equals(2, len(entries), t)
+	fields := strings.Split(strings.TrimSpace(stdout.String()), "\t")
+	equals(4, len(fields), t)
+	equals("warn  ", fields[1], t)
+	equals("both", fields[2], t)
+	equals(`{"key": "value"}`, fields[3], t)
+}
+
+func TestInitLoggerConsoleColor(t *testing.T) {
+	dir := makeTempDir("TestInitLoggerConsoleColor", t)
+	defer os.RemoveAll(dir)
+
+	var stdout bytes.Buffer
+	consoleOutput = &stdout
+	defer func() { consoleOutput = os.Stdout }()
+
+	isNil(InitLogger(dir, false, nil, WithConsole(true), WithConsoleColor(true)), t)
+	defer InitLogger(dir, false, nil)
+	logger := GetLogger("TestInitLoggerConsoleColor")
+	logger.Info("hello")
+	isNil(logger.Sync(), t)
+
+	if !strings.Contains(stdout.String(), "\x1b[") {
+		t.Fatalf("expected colored console output, got %q", stdout.String())
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "TestInitLoggerConsoleColor.log"))
+	isNil(err, t)
+	if strings.Contains(string(b), "\x1b[") {
+		t.Fatalf("unexpected color codes in the file: %q", b)
+	}
+}
+
+func TestInitLoggerWithWriter(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf)), t)
+	defer initDefaults(t)
+
+	GetLogger("first").Info("first")
+	GetLogger("second").Info("second")
+	RotateLog()
+	isNil(Sync(), t)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	equals(2, len(lines), t)
+	entry := map[string]interface{}{}
+	isNil(json.Unmarshal([]byte(lines[1]), &entry), t)
+	equals("second", entry["message"], t)
+}
+
+func TestInitLoggerFields(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithFields(zap.String("app_version", "1.2.3")), WithProcessFields()), t)
+	defer initDefaults(t)
+
+	GetLogger("TestInitLoggerFields").With("request_id", "abc").Info("hello")
+
+	entry := map[string]interface{}{}
+	isNil(json.Unmarshal(buf.Bytes(), &entry), t)
+	host, _ := os.Hostname()
+	equals("1.2.3", entry["app_version"], t)
+	equals(host, entry["host"], t)
+	equals(float64(os.Getpid()), entry["pid"], t)
+	equals("abc", entry["request_id"], t)
+}
+
+// initDefaults initializes the package again with the default options, in a
+// throwaway directory, for tests that initialized it without a directory.
+func TestShutdown(t *testing.T) {
+	defer initDefaults(t)
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent(), goleak.IgnoreTopFunction("os/signal.signal_recv"))
+	dir := makeTempDir("TestShutdown", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil, WithWriterOptions(WithCompress(true))), t)
+	HandleSIGHUP(context.Background())
+	GetLogger("app").Info("before")
+	w := loggers.get("app").writer
+
+	isNil(Shutdown(context.Background()), t)
+	equals(true, isClosed(w), t)
+	equals(false, loggers.initialized, t)
+	equals(true, stopDaily == nil, t)
+	entries := readEntries(filepath.Join(dir, "app.log"), t)
+	equals(1, len(entries), t)
+	isNil(Shutdown(context.Background()), t)
+
+	// a new InitLogger starts afresh
+	isNil(InitLogger(dir, false, nil), t)
+	GetLogger("app").Info("after")
+	isNil(Sync(), t)
+	entries = readEntries(filepath.Join(dir, "app.log"), t)
+	equals(2, len(entries), t)
+	equals("after", entries[1]["message"], t)
+	isNil(Shutdown(context.Background()), t)
+}
+
+func initDefaults(t testing.TB) {
+	dir := makeTempDir("initDefaults", t)
+	defer os.RemoveAll(dir)
+	isNil(InitLogger(dir, false, nil), t)
+}
+
+// isClosed reports whether w doesn't hold a file open.
+func isClosed(w *Writer) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file == nil
+}
+
+// logThrough is a logging wrapper, as skipped by WithCallerSkip(1).
+func logThrough(logger *zap.SugaredLogger, msg string) {
+	logger.Info(msg)
+}
+
+// readEntries decodes the JSON log entries in the given file.
+func readEntries(path string, t testing.TB) []map[string]interface{} {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	isNil(err, t)
+	return decodeEntries(string(b), t)
+}
+
+// decodeEntries decodes the JSON log entries in s, one per line.
+func decodeEntries(s string, t testing.TB) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		isNil(json.Unmarshal([]byte(line), &entry), t)
+		entries = append(entries, entry)
+	}
+	return entries
+}
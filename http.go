@@ -0,0 +1,188 @@
+package zaphelper
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// MiddlewareOption configures the middleware returned by NewMiddleware.
+type MiddlewareOption func(*middleware)
+
+type middleware struct {
+	logger          *zap.SugaredLogger
+	levels          func(status int) zapcore.Level
+	fields          func(r *http.Request) []zap.Field
+	requestIDHeader string
+}
+
+// WithHTTPLogger makes the middleware log through logger instead of
+// GetLogger("http").
+func WithHTTPLogger(logger *zap.SugaredLogger) MiddlewareOption {
+	return func(m *middleware) {
+		m.logger = logger
+	}
+}
+
+// WithStatusLevels sets the level requests are logged at by their response
+// status.  By default, server errors are logged at error level and other
+// requests at info level.
+func WithStatusLevels(levels func(status int) zapcore.Level) MiddlewareOption {
+	return func(m *middleware) {
+		m.levels = levels
+	}
+}
+
+// WithRequestFields adds the fields returned by fields for the request to its
+// entry, e.g. the user agent.
+func WithRequestFields(fields func(r *http.Request) []zap.Field) MiddlewareOption {
+	return func(m *middleware) {
+		m.fields = fields
+	}
+}
+
+// WithRequestIDHeader sets the header the request id is read from, and
+// echoed in, "X-Request-Id" by default.
+func WithRequestIDHeader(name string) MiddlewareOption {
+	return func(m *middleware) {
+		m.requestIDHeader = name
+	}
+}
+
+// defaultStatusLevel logs server errors at error level, and other requests at
+// info level.
+func defaultStatusLevel(status int) zapcore.Level {
+	if status >= http.StatusInternalServerError {
+		return zapcore.ErrorLevel
+	}
+	return zapcore.InfoLevel
+}
+
+// Middleware logs each request served by next with NewMiddleware's defaults.
+func Middleware(next http.Handler) http.Handler {
+	return NewMiddleware()(next)
+}
+
+// NewMiddleware returns a middleware logging each request with its method,
+// path, status, response size and duration, through GetLogger("http") unless
+// configured otherwise.  The request id is taken from the request header, or
+// generated, and echoed in the response.  The request's context carries it
+// and a logger with it, for handlers to retrieve with LoggerFromContext.
+func NewMiddleware(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	m := &middleware{
+		levels:          defaultStatusLevel,
+		requestIDHeader: "X-Request-Id",
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.serve(next, w, r)
+		})
+	}
+}
+
+func (m *middleware) serve(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	logger := m.logger
+	if logger == nil {
+		logger = GetLogger("http")
+	}
+	id := r.Header.Get(m.requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+	}
+	w.Header().Set(m.requestIDHeader, id)
+	ctx := context.WithValue(r.Context(), RequestIDKey, id)
+	ctx = WithContext(ctx, logger, "request_id", id)
+
+	rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rw, r.WithContext(ctx))
+
+	ce := logger.Desugar().Check(m.levels(rw.status), "served request")
+	if ce == nil {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.Int("status", rw.status),
+		zap.Int64("bytes", rw.bytes),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("remote_addr", r.RemoteAddr),
+		zap.String("request_id", id),
+	}
+	if m.fields != nil {
+		fields = append(fields, m.fields(r)...)
+	}
+	ce.Write(fields...)
+}
+
+// newRequestID returns a random request id.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// responseWriter records the status and size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if the wrapped ResponseWriter does.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker if the wrapped ResponseWriter does, for
+// websockets and other protocol upgrades, and fails otherwise.  What is sent
+// over the connection isn't counted.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the ResponseWriter doesn't support hijacking")
+	}
+	return h.Hijack()
+}
+
+// Push implements http.Pusher if the wrapped ResponseWriter does, and fails
+// with http.ErrNotSupported otherwise.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
@@ -0,0 +1,122 @@
+package zaphelper
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMiddleware(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	handler := NewMiddleware(WithHTTPLogger(zap.New(core).Sugar()), WithRequestFields(func(r *http.Request) []zap.Field {
+		return []zap.Field{zap.String("user_agent", r.UserAgent())}
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoggerFromContext(r.Context()).Info("handling")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "hello")
+	}))
+
+	req := httptest.NewRequest("POST", "/things?x=1", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	req.Header.Set("User-Agent", "test")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	equals("req-1", rec.Header().Get("X-Request-Id"), t)
+
+	entries := recorded.AllUntimed()
+	equals(2, len(entries), t)
+	equals("req-1", entries[0].ContextMap()["request_id"], t)
+	fields := entries[1].ContextMap()
+	equals(zapcore.InfoLevel, entries[1].Level, t)
+	equals("POST", fields["method"], t)
+	equals("/things", fields["path"], t)
+	equals(int64(http.StatusCreated), fields["status"], t)
+	equals(int64(5), fields["bytes"], t)
+	equals("req-1", fields["request_id"], t)
+	equals("test", fields["user_agent"], t)
+	if _, ok := fields["duration"]; !ok {
+		t.Fatalf("missing duration in %v", fields)
+	}
+}
+
+func TestMiddlewareStatusLevels(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	handler := NewMiddleware(WithHTTPLogger(zap.New(core).Sugar()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	entries := recorded.AllUntimed()
+	equals(1, len(entries), t)
+	equals(zapcore.ErrorLevel, entries[0].Level, t)
+	// a request id is generated if the request has none
+	id := rec.Header().Get("X-Request-Id")
+	equals(16, len(id), t)
+	equals(id, entries[0].ContextMap()["request_id"], t)
+
+	core, recorded = observer.New(zapcore.InfoLevel)
+	handler = NewMiddleware(WithHTTPLogger(zap.New(core).Sugar()), WithStatusLevels(func(int) zapcore.Level {
+		return zapcore.DebugLevel
+	}))(http.NotFoundHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	equals(0, recorded.Len(), t)
+}
+
+// pushRecorder is an httptest.ResponseRecorder supporting http.Pusher.
+type pushRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (r *pushRecorder) Push(target string, opts *http.PushOptions) error {
+	r.pushed = append(r.pushed, target)
+	return nil
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	handler := NewMiddleware(WithHTTPLogger(zap.New(core).Sugar()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\nhijacked")
+		rw.Flush()
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	isNil(err, t)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	isNil(err, t)
+	b, err := ioutil.ReadAll(conn)
+	isNil(err, t)
+	if !strings.HasSuffix(string(b), "\r\n\r\nhijacked") {
+		t.Fatalf("expected the hijacked response, got %q", b)
+	}
+	waitFor(func() bool { return recorded.Len() == 1 }, t)
+
+	// recorders can't be hijacked, but the error says so
+	rec := &pushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	NewMiddleware(WithHTTPLogger(zap.New(core).Sugar()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			t.Error("expected an error hijacking a recorder")
+		}
+		isNil(w.(http.Pusher).Push("/style.css", nil), t)
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	equals("/style.css", strings.Join(rec.pushed, ","), t)
+}
@@ -0,0 +1,179 @@
+package zaphelper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// journaldSocket is the socket of the journal.  It is a variable so tests can
+// mock it out.
+var journaldSocket = "/run/systemd/journal/socket"
+
+// journaldConfig is the journal sink WithJournald configures.
+type journaldConfig struct {
+	tag string
+}
+
+// WithJournald additionally sends the entries of all loggers to the systemd
+// journal over its native protocol, as sd_journal_send does, so that
+// journalctl -o json shows their fields as journal fields: "user_id" becomes
+// USER_ID, the level PRIORITY, the message MESSAGE, the caller CODE_FILE,
+// CODE_LINE and CODE_FUNC, and SYSLOG_IDENTIFIER is tag, the name of the
+// program if empty.  The journal is only available on Linux: elsewhere, or
+// with no journal running, InitLogger fails if WithJournald is used.  Entries
+// larger than a datagram, which would need a memfd, fail to send.
+func WithJournald(tag string) LoggerOption {
+	return func(o *options) {
+		if tag == "" {
+			tag = filepath.Base(os.Args[0])
+		}
+		o.journald = &journaldConfig{tag: tag}
+	}
+}
+
+// journaldCore is a zapcore.Core sending its entries to the journal, one
+// datagram each.
+type journaldCore struct {
+	zapcore.LevelEnabler
+	w      io.Writer
+	tag    string
+	fields []zapcore.Field
+}
+
+// newJournaldCore returns a core sending the entries enab enables to the
+// journal through w, identified by tag.
+func newJournaldCore(w io.Writer, tag string, enab zapcore.LevelEnabler) zapcore.Core {
+	return &journaldCore{LevelEnabler: enab, w: w, tag: tag}
+}
+
+func (c *journaldCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	return &clone
+}
+
+func (c *journaldCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *journaldCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	_, err := c.w.Write(c.encode(ent, fields))
+	return err
+}
+
+func (c *journaldCore) Sync() error {
+	return nil
+}
+
+// encode returns the datagram for ent and fields in the native protocol of
+// the journal.
+func (c *journaldCore) encode(ent zapcore.Entry, fields []zapcore.Field) []byte {
+	var b bytes.Buffer
+	journaldField(&b, "MESSAGE", ent.Message)
+	journaldField(&b, "PRIORITY", strconv.Itoa(gelfSeverity(ent.Level)))
+	journaldField(&b, "SYSLOG_IDENTIFIER", c.tag)
+	if ent.LoggerName != "" {
+		journaldField(&b, "LOGGER", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		journaldField(&b, "CODE_FILE", ent.Caller.File)
+		journaldField(&b, "CODE_LINE", strconv.Itoa(ent.Caller.Line))
+		if ent.Caller.Function != "" {
+			journaldField(&b, "CODE_FUNC", ent.Caller.Function)
+		}
+	}
+	if ent.Stack != "" {
+		journaldField(&b, "STACKTRACE", ent.Stack)
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	keys := make([]string, 0, len(enc.Fields))
+	for k := range enc.Fields {
+		keys = append(keys, k)
+	}
+	// keep the fields in a stable order
+	sort.Strings(keys)
+	for _, k := range keys {
+		journaldField(&b, journaldFieldName(k), journaldValue(enc.Fields[k]))
+	}
+	return b.Bytes()
+}
+
+// journaldField appends the field name=value to b, with value
+// length-prefixed if it spans lines.
+func journaldField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	// writing to a bytes.Buffer can't fail
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journaldFieldName turns key into a valid journal field name: uppercase
+// letters, digits and underscores, not starting with an underscore, which
+// marks the fields set by the journal itself, nor with a digit, and at most
+// 64 bytes long.
+func journaldFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	s := strings.TrimLeft(string(name), "_")
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		s = "F_" + s
+	}
+	if len(s) > 64 {
+		s = s[:64]
+	}
+	return s
+}
+
+// journaldValue formats a value of a zapcore.MapObjectEncoder: strings and
+// times as they are, the rest as JSON.
+func journaldValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		// e.g. complex numbers
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,20 @@
+package zaphelper
+
+import (
+	"net"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// dialJournald connects to the journal.
+func dialJournald() (net.Conn, error) {
+	if _, err := os.Stat(journaldSocket); err != nil {
+		return nil, errors.Wrap(err, "journald is not available")
+	}
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't connect to journald")
+	}
+	return conn, nil
+}
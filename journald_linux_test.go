@@ -0,0 +1,95 @@
+package zaphelper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithJournald(t *testing.T) {
+	dir := makeTempDir("TestWithJournald", t)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	isNil(err, t)
+	defer conn.Close()
+	journaldSocket = socket
+	defer func() { journaldSocket = "/run/systemd/journal/socket" }()
+
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithCaller(true), WithJournald("myapp")), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestWithJournald").With("user-id", 42)
+	logger.Warnw("hello", "_trusted", "no", "9lives", true, "nested", map[string]int{"a": 1}, "multi", "line1\nline2")
+	logger.Error("failed")
+
+	packet := make([]byte, 65536)
+	isNil(conn.SetReadDeadline(time.Now().Add(5*time.Second)), t)
+	n, err := conn.Read(packet)
+	isNil(err, t)
+	fields := parseJournald(packet[:n], t)
+	equals("hello", fields["MESSAGE"], t)
+	equals("4", fields["PRIORITY"], t)
+	equals("myapp", fields["SYSLOG_IDENTIFIER"], t)
+	equals("42", fields["USER_ID"], t)
+	equals("no", fields["TRUSTED"], t)
+	equals("true", fields["F_9LIVES"], t)
+	equals(`{"a":1}`, fields["NESTED"], t)
+	equals("line1\nline2", fields["MULTI"], t)
+	if !strings.HasSuffix(fields["CODE_FILE"], "journald_linux_test.go") || fields["CODE_LINE"] == "" {
+		t.Fatalf("expected the caller, got %v", fields)
+	}
+
+	n, err = conn.Read(packet)
+	isNil(err, t)
+	fields = parseJournald(packet[:n], t)
+	equals("failed", fields["MESSAGE"], t)
+	equals("3", fields["PRIORITY"], t)
+
+	// the writer still gets the entries
+	equals(2, len(decodeEntries(buf.String(), t)), t)
+}
+
+func TestWithJournaldUnavailable(t *testing.T) {
+	journaldSocket = filepath.Join(os.TempDir(), "TestWithJournaldUnavailable.sock")
+	defer func() { journaldSocket = "/run/systemd/journal/socket" }()
+
+	err := InitLogger("", false, nil, WithWriter(&bytes.Buffer{}), WithJournald(""))
+	defer initDefaults(t)
+	if err == nil || !strings.Contains(err.Error(), "journald is not available") {
+		t.Fatalf("expected journald to be unavailable, got %v", err)
+	}
+}
+
+// parseJournald decodes a datagram of the native journal protocol.
+func parseJournald(b []byte, t testing.TB) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "=\n")
+		if i < 0 {
+			t.Fatalf("truncated field in %q", b)
+		}
+		name := string(b[:i])
+		if b[i] == '=' {
+			end := bytes.IndexByte(b, '\n')
+			fields[name] = string(b[i+1 : end])
+			b = b[end+1:]
+			continue
+		}
+		b = b[i+1:]
+		if len(b) < 8 {
+			t.Fatalf("truncated length in %q", b)
+		}
+		size := binary.LittleEndian.Uint64(b)
+		fields[name] = string(b[8 : 8+size])
+		b = b[8+size+1:]
+	}
+	return fields
+}
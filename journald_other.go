@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package zaphelper
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// dialJournald fails: the journal only exists on Linux.
+func dialJournald() (net.Conn, error) {
+	return nil, errors.New("journald is not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package zaphelper
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// lockFile fails: there is no advisory locking wired up for this platform.
+func lockFile(f *os.File) error {
+	return errors.New("file locking is not supported on this platform")
+}
@@ -0,0 +1,27 @@
+package zaphelper
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	allBytes                = ^uint32(0)
+)
+
+// lockFile takes an exclusive lock on the whole of f, failing right away if
+// another handle holds it.
+func lockFile(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately,
+		0, uintptr(allBytes), uintptr(allBytes), uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package zaphelper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+var logfmtPool = buffer.NewPool()
+
+// logfmtEncoder is a zapcore.Encoder writing entries as logfmt: space-separated
+// key=value pairs, with the values quoted if they contain spaces, equals signs,
+// quotes or control characters.  Nested objects are flattened into dotted
+// keys, and arrays are written as [a,b].
+type logfmtEncoder struct {
+	cfg *zapcore.EncoderConfig
+	buf *buffer.Buffer
+	// prefix is prepended to keys, for namespaces and nested objects.
+	prefix string
+}
+
+// newLogfmtEncoder returns a logfmt encoder configured by cfg.
+func newLogfmtEncoder(cfg zapcore.EncoderConfig) *logfmtEncoder {
+	return &logfmtEncoder{cfg: &cfg, buf: logfmtPool.Get()}
+}
+
+func (e *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := &logfmtEncoder{cfg: e.cfg, buf: logfmtPool.Get(), prefix: e.prefix}
+	clone.buf.Write(e.buf.Bytes())
+	return clone
+}
+
+func (e *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	line := &logfmtEncoder{cfg: e.cfg, buf: logfmtPool.Get()}
+	if e.cfg.TimeKey != "" && e.cfg.EncodeTime != nil {
+		line.addEncoded(e.cfg.TimeKey, func(enc zapcore.PrimitiveArrayEncoder) {
+			e.cfg.EncodeTime(ent.Time, enc)
+		})
+	}
+	if e.cfg.LevelKey != "" && e.cfg.EncodeLevel != nil {
+		line.addEncoded(e.cfg.LevelKey, func(enc zapcore.PrimitiveArrayEncoder) {
+			e.cfg.EncodeLevel(ent.Level, enc)
+		})
+	}
+	if e.cfg.NameKey != "" && ent.LoggerName != "" {
+		line.AddString(e.cfg.NameKey, ent.LoggerName)
+	}
+	if e.cfg.CallerKey != "" && ent.Caller.Defined && e.cfg.EncodeCaller != nil {
+		line.addEncoded(e.cfg.CallerKey, func(enc zapcore.PrimitiveArrayEncoder) {
+			e.cfg.EncodeCaller(ent.Caller, enc)
+		})
+	}
+	if e.cfg.MessageKey != "" {
+		line.AddString(e.cfg.MessageKey, ent.Message)
+	}
+	if e.buf.Len() > 0 {
+		line.separate()
+		line.buf.Write(e.buf.Bytes())
+	}
+	line.prefix = e.prefix
+	for _, f := range fields {
+		f.AddTo(line)
+	}
+	line.prefix = ""
+	if e.cfg.StacktraceKey != "" && ent.Stack != "" {
+		line.AddString(e.cfg.StacktraceKey, ent.Stack)
+	}
+	if e.cfg.LineEnding != "" {
+		line.buf.AppendString(e.cfg.LineEnding)
+	} else {
+		line.buf.AppendString(zapcore.DefaultLineEnding)
+	}
+	return line.buf, nil
+}
+
+// separate writes the space preceding a pair, unless it is the first one.
+func (e *logfmtEncoder) separate() {
+	if e.buf.Len() > 0 {
+		e.buf.AppendByte(' ')
+	}
+}
+
+// addKey writes the key of a pair, with the characters that would break the
+// framing replaced by underscores.
+func (e *logfmtEncoder) addKey(key string) {
+	e.separate()
+	key = e.prefix + key
+	for _, r := range key {
+		if r <= ' ' || r == '=' || r == '"' || !unicode.IsPrint(r) {
+			r = '_'
+		}
+		e.buf.AppendString(string(r))
+	}
+	e.buf.AppendByte('=')
+}
+
+// addValue writes a value, quoted if needed.
+func (e *logfmtEncoder) addValue(v string) {
+	if needsQuoting(v) {
+		e.buf.AppendString(strconv.Quote(v))
+		return
+	}
+	e.buf.AppendString(v)
+}
+
+// needsQuoting reports whether v must be quoted to be read back as one value.
+func needsQuoting(v string) bool {
+	if v == "" {
+		return true
+	}
+	for _, r := range v {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// addEncoded writes the pair for key with the value appended by encode, as
+// the time, level and caller encoders of the config do.
+func (e *logfmtEncoder) addEncoded(key string, encode func(zapcore.PrimitiveArrayEncoder)) {
+	arr := &logfmtArray{cfg: e.cfg}
+	encode(arr)
+	e.addKey(key)
+	e.addValue(strings.Join(arr.values, ","))
+}
+
+func (e *logfmtEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	arr := &logfmtArray{cfg: e.cfg}
+	err := marshaler.MarshalLogArray(arr)
+	e.addKey(key)
+	e.addValue("[" + strings.Join(arr.values, ",") + "]")
+	return err
+}
+
+func (e *logfmtEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	prefix := e.prefix
+	e.prefix = prefix + key + "."
+	err := marshaler.MarshalLogObject(e)
+	e.prefix = prefix
+	return err
+}
+
+func (e *logfmtEncoder) AddBinary(key string, value []byte) {
+	e.AddString(key, base64.StdEncoding.EncodeToString(value))
+}
+
+func (e *logfmtEncoder) AddByteString(key string, value []byte) {
+	e.AddString(key, string(value))
+}
+
+func (e *logfmtEncoder) AddBool(key string, value bool) {
+	e.AddString(key, strconv.FormatBool(value))
+}
+
+func (e *logfmtEncoder) AddComplex128(key string, value complex128) {
+	e.AddString(key, formatComplex(value, 64))
+}
+
+func (e *logfmtEncoder) AddComplex64(key string, value complex64) {
+	e.AddString(key, formatComplex(complex128(value), 32))
+}
+
+func (e *logfmtEncoder) AddDuration(key string, value time.Duration) {
+	e.addEncoded(key, func(enc zapcore.PrimitiveArrayEncoder) {
+		if e.cfg.EncodeDuration != nil {
+			e.cfg.EncodeDuration(value, enc)
+		} else {
+			enc.AppendInt64(int64(value))
+		}
+	})
+}
+
+func (e *logfmtEncoder) AddFloat64(key string, value float64) {
+	e.AddString(key, formatFloat(value, 64))
+}
+
+func (e *logfmtEncoder) AddFloat32(key string, value float32) {
+	e.AddString(key, formatFloat(float64(value), 32))
+}
+
+func (e *logfmtEncoder) AddInt(key string, value int)     { e.AddInt64(key, int64(value)) }
+func (e *logfmtEncoder) AddInt32(key string, value int32) { e.AddInt64(key, int64(value)) }
+func (e *logfmtEncoder) AddInt16(key string, value int16) { e.AddInt64(key, int64(value)) }
+func (e *logfmtEncoder) AddInt8(key string, value int8)   { e.AddInt64(key, int64(value)) }
+
+func (e *logfmtEncoder) AddInt64(key string, value int64) {
+	e.addKey(key)
+	e.buf.AppendInt(value)
+}
+
+func (e *logfmtEncoder) AddString(key, value string) {
+	e.addKey(key)
+	e.addValue(value)
+}
+
+func (e *logfmtEncoder) AddTime(key string, value time.Time) {
+	e.addEncoded(key, func(enc zapcore.PrimitiveArrayEncoder) {
+		if e.cfg.EncodeTime != nil {
+			e.cfg.EncodeTime(value, enc)
+		} else {
+			enc.AppendInt64(value.UnixNano())
+		}
+	})
+}
+
+func (e *logfmtEncoder) AddUint(key string, value uint)       { e.AddUint64(key, uint64(value)) }
+func (e *logfmtEncoder) AddUint32(key string, value uint32)   { e.AddUint64(key, uint64(value)) }
+func (e *logfmtEncoder) AddUint16(key string, value uint16)   { e.AddUint64(key, uint64(value)) }
+func (e *logfmtEncoder) AddUint8(key string, value uint8)     { e.AddUint64(key, uint64(value)) }
+func (e *logfmtEncoder) AddUintptr(key string, value uintptr) { e.AddUint64(key, uint64(value)) }
+
+func (e *logfmtEncoder) AddUint64(key string, value uint64) {
+	e.addKey(key)
+	e.buf.AppendUint(value)
+}
+
+func (e *logfmtEncoder) AddReflected(key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	e.AddString(key, string(b))
+	return nil
+}
+
+func (e *logfmtEncoder) OpenNamespace(key string) {
+	e.prefix += key + "."
+}
+
+// logfmtArray is a zapcore.ArrayEncoder collecting the elements of an array
+// as strings.
+type logfmtArray struct {
+	cfg    *zapcore.EncoderConfig
+	values []string
+}
+
+func (a *logfmtArray) append(v string) { a.values = append(a.values, v) }
+
+func (a *logfmtArray) AppendBool(v bool)             { a.append(strconv.FormatBool(v)) }
+func (a *logfmtArray) AppendByteString(v []byte)     { a.append(string(v)) }
+func (a *logfmtArray) AppendComplex128(v complex128) { a.append(formatComplex(v, 64)) }
+func (a *logfmtArray) AppendComplex64(v complex64)   { a.append(formatComplex(complex128(v), 32)) }
+func (a *logfmtArray) AppendFloat64(v float64)       { a.append(formatFloat(v, 64)) }
+func (a *logfmtArray) AppendFloat32(v float32)       { a.append(formatFloat(float64(v), 32)) }
+func (a *logfmtArray) AppendInt(v int)               { a.AppendInt64(int64(v)) }
+func (a *logfmtArray) AppendInt64(v int64)           { a.append(strconv.FormatInt(v, 10)) }
+func (a *logfmtArray) AppendInt32(v int32)           { a.AppendInt64(int64(v)) }
+func (a *logfmtArray) AppendInt16(v int16)           { a.AppendInt64(int64(v)) }
+func (a *logfmtArray) AppendInt8(v int8)             { a.AppendInt64(int64(v)) }
+func (a *logfmtArray) AppendString(v string)         { a.append(v) }
+func (a *logfmtArray) AppendUint(v uint)             { a.AppendUint64(uint64(v)) }
+func (a *logfmtArray) AppendUint64(v uint64)         { a.append(strconv.FormatUint(v, 10)) }
+func (a *logfmtArray) AppendUint32(v uint32)         { a.AppendUint64(uint64(v)) }
+func (a *logfmtArray) AppendUint16(v uint16)         { a.AppendUint64(uint64(v)) }
+func (a *logfmtArray) AppendUint8(v uint8)           { a.AppendUint64(uint64(v)) }
+func (a *logfmtArray) AppendUintptr(v uintptr)       { a.AppendUint64(uint64(v)) }
+
+func (a *logfmtArray) AppendDuration(v time.Duration) {
+	if a.cfg.EncodeDuration != nil {
+		a.cfg.EncodeDuration(v, a)
+	} else {
+		a.AppendInt64(int64(v))
+	}
+}
+
+func (a *logfmtArray) AppendTime(v time.Time) {
+	if a.cfg.EncodeTime != nil {
+		a.cfg.EncodeTime(v, a)
+	} else {
+		a.AppendInt64(v.UnixNano())
+	}
+}
+
+func (a *logfmtArray) AppendArray(v zapcore.ArrayMarshaler) error {
+	arr := &logfmtArray{cfg: a.cfg}
+	err := v.MarshalLogArray(arr)
+	a.append("[" + strings.Join(arr.values, ",") + "]")
+	return err
+}
+
+func (a *logfmtArray) AppendObject(v zapcore.ObjectMarshaler) error {
+	enc := &logfmtEncoder{cfg: a.cfg, buf: logfmtPool.Get()}
+	defer enc.buf.Free()
+	err := v.MarshalLogObject(enc)
+	a.append("{" + enc.buf.String() + "}")
+	return err
+}
+
+func (a *logfmtArray) AppendReflected(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	a.append(string(b))
+	return nil
+}
+
+// formatFloat formats f like the JSON encoder does, with NaN and infinities
+// spelled out.
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, bitSize)
+}
+
+// formatComplex formats c as real+imagi.
+func formatComplex(c complex128, bitSize int) string {
+	return fmt.Sprintf("%s%+gi", formatFloat(real(c), bitSize), imag(c))
+}
@@ -0,0 +1,73 @@
+package zaphelper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type logfmtUser struct {
+	name string
+	tags []string
+}
+
+func (u logfmtUser) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("name", u.name)
+	return enc.AddArray("tags", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+		for _, tag := range u.tags {
+			arr.AppendString(tag)
+		}
+		return nil
+	}))
+}
+
+func TestLogfmtEncoder(t *testing.T) {
+	o := newOptions(false)
+	o.encoding = EncodingLogfmt
+	o.timeFormat = TimeFormatRFC3339Nano
+	o.location = time.UTC
+	enc, err := o.newEncoder()
+	isNil(err, t)
+	enc.AddString("app", "demo")
+
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC),
+		LoggerName: "http",
+		Message:    "request failed",
+	}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{
+		zap.String("plain", "value"),
+		zap.String("spaced", "a b"),
+		zap.String("equals", "a=b"),
+		zap.String("quoted", `say "hi"`),
+		zap.String("newline", "line\nforged=1"),
+		zap.String("empty", ""),
+		zap.String("bad key", "x"),
+		zap.Int("status", 500),
+		zap.Bool("ok", false),
+		zap.Duration("took", 1500*time.Millisecond),
+		zap.Object("user", logfmtUser{name: "alice smith", tags: []string{"a", "b"}}),
+		zap.Namespace("ctx"),
+		zap.Float64("ratio", 0.5),
+	})
+	isNil(err, t)
+	equals(`time=2018-03-01T12:00:00Z level=warn logger=http message="request failed" app=demo `+
+		`plain=value spaced="a b" equals="a=b" quoted="say \"hi\"" newline="line\nforged=1" empty="" bad_key=x `+
+		`status=500 ok=false took=1500000000 user.name="alice smith" user.tags=[a,b] ctx.ratio=0.5`+"\n", buf.String(), t)
+}
+
+func TestInitLoggerLogfmt(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithEncoding(EncodingLogfmt)), t)
+	defer initDefaults(t)
+
+	GetLogger("TestInitLoggerLogfmt").Infow("hello world", "user", "alice")
+	if !strings.HasSuffix(buf.String(), ` level=info message="hello world" user=alice`+"\n") {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
@@ -0,0 +1,19 @@
+package zaphelper
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// NewTestLogger returns a logger that records its entries in memory instead
+// of writing them, for tests to assert on what code logs with recorded.All().
+// It is built with the same options as the loggers returned by GetLogger, but
+// records entries of every level.
+func NewTestLogger() (*zap.SugaredLogger, *observer.ObservedLogs) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	loggers.lock.RLock()
+	opts := settings.zapOptions()
+	loggers.lock.RUnlock()
+	return zap.New(core, opts...).Sugar(), recorded
+}
@@ -0,0 +1,21 @@
+package zaphelper
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNewTestLogger(t *testing.T) {
+	logger, recorded := NewTestLogger()
+	logger.Debugw("hello", "key", "value")
+	logger.Error("failed")
+
+	entries := recorded.All()
+	equals(2, len(entries), t)
+	equals(zapcore.DebugLevel, entries[0].Level, t)
+	equals("hello", entries[0].Message, t)
+	equals("value", entries[0].ContextMap()["key"], t)
+	equals(zapcore.ErrorLevel, entries[1].Level, t)
+	equals(1, recorded.FilterMessage("failed").Len(), t)
+}
@@ -0,0 +1,716 @@
+package zaphelper
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// EncodingJSON encodes entries as JSON objects, one per line.  It is the
+	// default encoding.
+	EncodingJSON = "json"
+	// EncodingConsole encodes entries as tab-separated, human-readable text.
+	EncodingConsole = "console"
+	// EncodingLogfmt encodes entries as logfmt key=value pairs, one entry per
+	// line, with nested objects flattened into dotted keys.
+	EncodingLogfmt = "logfmt"
+	// EncodingGELF encodes entries as GELF JSON objects for Graylog, one per
+	// line.  The keys and time format options don't apply to it.
+	EncodingGELF = "gelf"
+)
+
+const (
+	// TimeFormatDefault formats times as "2006-01-02 15:04:05".
+	TimeFormatDefault = ""
+	// TimeFormatISO8601 formats times as ISO8601 with millisecond precision.
+	TimeFormatISO8601 = "iso8601"
+	// TimeFormatRFC3339Nano formats times as RFC3339 with nanosecond precision.
+	TimeFormatRFC3339Nano = "rfc3339nano"
+	// TimeFormatEpoch encodes times as floating-point seconds since the epoch.
+	TimeFormatEpoch = "epoch"
+	// TimeFormatEpochMillis encodes times as floating-point milliseconds since
+	// the epoch.
+	TimeFormatEpochMillis = "epoch-millis"
+)
+
+const (
+	// TimePrecisionDefault keeps the precision of the TimeFormat preset.
+	TimePrecisionDefault = ""
+	// TimePrecisionSeconds encodes times to the second.
+	TimePrecisionSeconds = "seconds"
+	// TimePrecisionMillis encodes times to the millisecond.
+	TimePrecisionMillis = "millis"
+	// TimePrecisionMicros encodes times to the microsecond.
+	TimePrecisionMicros = "micros"
+	// TimePrecisionNanos encodes times to the nanosecond.
+	TimePrecisionNanos = "nanos"
+)
+
+// timePrecisions are the units of the TimePrecision presets.
+var timePrecisions = map[string]time.Duration{
+	TimePrecisionDefault: 0,
+	TimePrecisionSeconds: time.Second,
+	TimePrecisionMillis:  time.Millisecond,
+	TimePrecisionMicros:  time.Microsecond,
+	TimePrecisionNanos:   time.Nanosecond,
+}
+
+const (
+	// DurationFormatNanos encodes durations as integer nanoseconds.  It is the
+	// default.
+	DurationFormatNanos = ""
+	// DurationFormatSeconds encodes durations as floating-point seconds.
+	DurationFormatSeconds = "seconds"
+	// DurationFormatMillis encodes durations as floating-point milliseconds.
+	DurationFormatMillis = "millis"
+	// DurationFormatString encodes durations as strings such as "1.5s".
+	DurationFormatString = "string"
+)
+
+// durationEncoders are the zapcore.DurationEncoders of the DurationFormat
+// presets.
+var durationEncoders = map[string]zapcore.DurationEncoder{
+	DurationFormatNanos:   zapcore.NanosDurationEncoder,
+	DurationFormatSeconds: zapcore.SecondsDurationEncoder,
+	DurationFormatMillis:  zapcore.MillisDurationEncoder,
+	DurationFormatString:  zapcore.StringDurationEncoder,
+}
+
+const (
+	// LevelFormatLowercase encodes levels as "info".  It is the default.
+	LevelFormatLowercase = ""
+	// LevelFormatUppercase encodes levels as "INFO".
+	LevelFormatUppercase = "uppercase"
+	// LevelFormatColor encodes levels as "info" in an ANSI color.
+	LevelFormatColor = "color"
+	// LevelFormatUppercaseColor encodes levels as "INFO" in an ANSI color.
+	LevelFormatUppercaseColor = "uppercase-color"
+)
+
+// levelEncoders are the zapcore.LevelEncoders of the LevelFormat presets.
+var levelEncoders = map[string]zapcore.LevelEncoder{
+	LevelFormatLowercase:      zapcore.LowercaseLevelEncoder,
+	LevelFormatUppercase:      zapcore.CapitalLevelEncoder,
+	LevelFormatColor:          zapcore.LowercaseColorLevelEncoder,
+	LevelFormatUppercaseColor: zapcore.CapitalColorLevelEncoder,
+}
+
+// LoggerOption configures the loggers built by InitLogger.
+type LoggerOption func(*options)
+
+// options holds the settings InitLogger builds loggers from.
+type options struct {
+	level    zapcore.Level
+	levelSet bool
+	levelEnv string
+	// levelEnvErr is why the value of levelEnv was ignored, if it was.
+	levelEnvErr error
+
+	encoding   string
+	timeFormat string
+	precision  string
+	duration   string
+	levelFmt   string
+	syslog     *syslogConfig
+	journald   *journaldConfig
+	remote     *remoteConfig
+	location   *time.Location
+	keys       Keys
+	gelfHost   string
+	// noTimestamp, noLevel and noCaller leave those fields out of the
+	// entries.
+	noTimestamp bool
+	noLevel     bool
+	noCaller    bool
+	// flattenSep, if set, flattens objects up to flattenDepth deep.
+	flattenSep   string
+	flattenDepth int
+
+	caller          bool
+	callerSkip      int
+	stacktrace      bool
+	stacktraceLevel zapcore.Level
+	syncOnFatal     bool
+	goroutineID     bool
+
+	sampleTick       time.Duration
+	sampleFirst      int
+	sampleThereafter int
+	// sampling, if set, is what the cores sample by instead of the values
+	// above, so that WatchConfig can change it.
+	sampling *samplingSettings
+	// deadlineThreshold, if set, bypasses sampling for the contexts of
+	// FromContext this close to their deadlines.
+	deadlineThreshold time.Duration
+	fieldSampling     *fieldSampling
+
+	dedupWindow time.Duration
+	dedupKey    DedupKey
+
+	writerOptions []Option
+	errorFilename string
+
+	writer      io.Writer
+	errorOutput io.Writer
+	// errorSink is the rate-limited errorOutput, shared by the loggers once
+	// initialized.
+	errorSink   zapcore.WriteSyncer
+	fields      []zap.Field
+	zapOpts     []zap.Option
+	extraCores  []zapcore.Core
+	contextKeys map[string]interface{}
+	redactKeys  map[string]bool
+	levels      map[string]zapcore.Level
+	sanitize    bool
+
+	maxFieldBytes   int
+	maxMessageBytes int
+
+	console         bool
+	consoleColor    bool
+	consoleColorSet bool
+	consoleLevel    zapcore.Level
+	consoleLevelSet bool
+}
+
+// Keys are the names of the fields entries are encoded with.
+type Keys struct {
+	Time       string `json:"time" yaml:"time"`
+	Level      string `json:"level" yaml:"level"`
+	Name       string `json:"name" yaml:"name"`
+	Caller     string `json:"caller" yaml:"caller"`
+	Message    string `json:"message" yaml:"message"`
+	Stacktrace string `json:"stacktrace" yaml:"stacktrace"`
+}
+
+// DefaultKeys are the field names used unless WithKeys overrides them.
+var DefaultKeys = Keys{
+	Time:       "time",
+	Level:      "level",
+	Name:       "logger",
+	Caller:     "caller",
+	Message:    "message",
+	Stacktrace: "stacktrace",
+}
+
+// newOptions returns the options InitLogger uses when it gets no LoggerOption.
+func newOptions(debugLevel bool) options {
+	o := options{
+		level:    zapcore.InfoLevel,
+		encoding: EncodingJSON,
+		keys:     DefaultKeys,
+		levelEnv: DefaultLevelEnv,
+
+		contextKeys: defaultContextKeys,
+	}
+	if debugLevel {
+		o.level = zapcore.DebugLevel
+	}
+	return o
+}
+
+// WithLevel sets the minimum level of the loggers, overriding InitLogger's
+// debugLevel argument.  Use zapcore.Level's UnmarshalText to get a level from
+// a string such as "warn".
+func WithLevel(l zapcore.Level) LoggerOption {
+	return func(o *options) {
+		o.level = l
+		o.levelSet = true
+	}
+}
+
+// DefaultLevelEnv is the environment variable InitLogger reads the level from
+// unless WithLevelEnv overrides it.
+const DefaultLevelEnv = "LOG_LEVEL"
+
+// WithLevelEnv sets the environment variable the minimum level of the loggers
+// is read from, such as "debug", instead of DefaultLevelEnv.  The variable
+// overrides InitLogger's debugLevel argument but not WithLevel, or a Config
+// Level; an invalid value is ignored with a warning.  An empty name disables
+// it.
+func WithLevelEnv(name string) LoggerOption {
+	return func(o *options) {
+		o.levelEnv = name
+	}
+}
+
+// applyLevelEnv sets the level from the levelEnv environment variable, unless
+// it was set explicitly.
+func (o *options) applyLevelEnv() {
+	if o.levelSet || o.levelEnv == "" {
+		return
+	}
+	v := os.Getenv(o.levelEnv)
+	if v == "" {
+		return
+	}
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(v)); err != nil {
+		o.levelEnvErr = errors.Wrapf(err, "bad %s", o.levelEnv)
+		return
+	}
+	o.level = l
+}
+
+// WithLevelOverrides sets the minimum level of the loggers with the given
+// names, e.g. {"sql": zapcore.DebugLevel}, in place of the level shared by all
+// loggers, which SetLevel doesn't change for them.  A name also covers the
+// names it is a dot-separated prefix of, such as "sql.orm" for "sql"; the
+// longest matching name wins.
+func WithLevelOverrides(levels map[string]zapcore.Level) LoggerOption {
+	return func(o *options) {
+		o.levels = levels
+	}
+}
+
+// levelOverride returns the level WithLevelOverrides sets for the logger
+// called name, if any.
+func (o *options) levelOverride(name string) (zapcore.Level, bool) {
+	var match string
+	var l zapcore.Level
+	var ok bool
+	for prefix, pl := range o.levels {
+		if name != prefix && !strings.HasPrefix(name, prefix+".") {
+			continue
+		}
+		if !ok || len(prefix) > len(match) {
+			match, l, ok = prefix, pl, true
+		}
+	}
+	return l, ok
+}
+
+// WithEncoding sets how entries are encoded: EncodingJSON, the default,
+// EncodingConsole, EncodingLogfmt or EncodingGELF.  InitLogger fails with any
+// other value.
+func WithEncoding(encoding string) LoggerOption {
+	return func(o *options) {
+		o.encoding = encoding
+	}
+}
+
+// WithTimeFormat sets how entry times are encoded: one of the TimeFormat
+// presets, or otherwise a layout for time.Format.  Times are formatted in the
+// location passed to InitLogger.
+func WithTimeFormat(format string) LoggerOption {
+	return func(o *options) {
+		o.timeFormat = format
+	}
+}
+
+// WithTimePrecision sets the precision of entry times, one of the
+// TimePrecision presets, whatever the TimeFormat preset: the layouts get as
+// many fractional digits and the epochs are truncated to it, though a
+// float64 of epoch seconds only holds about microseconds.  Custom layouts
+// have their own precision.  InitLogger fails on other values.
+func WithTimePrecision(precision string) LoggerOption {
+	return func(o *options) {
+		o.precision = precision
+	}
+}
+
+// WithDurationFormat sets how duration fields are encoded: one of the
+// DurationFormat presets.  InitLogger fails on other values.
+func WithDurationFormat(format string) LoggerOption {
+	return func(o *options) {
+		o.duration = format
+	}
+}
+
+// WithLevelFormat sets how entry levels are encoded: one of the LevelFormat
+// presets.  InitLogger fails on other values.  WithConsoleColor still colors
+// the levels written to the console.
+func WithLevelFormat(format string) LoggerOption {
+	return func(o *options) {
+		o.levelFmt = format
+	}
+}
+
+// timeEncoder returns the zapcore.TimeEncoder for the given time format and
+// precision, formatting times in loc, or in time.Local if loc is nil.
+func timeEncoder(format, precision string, loc *time.Location) zapcore.TimeEncoder {
+	unit := timePrecisions[precision]
+	layout := format
+	switch format {
+	case TimeFormatEpoch:
+		return epochTimeEncoder(time.Second, unit)
+	case TimeFormatEpochMillis:
+		return epochTimeEncoder(time.Millisecond, unit)
+	case TimeFormatDefault:
+		layout = "2006-01-02 15:04:05" + fraction(unit, "")
+	case TimeFormatISO8601:
+		layout = "2006-01-02T15:04:05" + fraction(unit, ".000") + "Z0700"
+	case TimeFormatRFC3339Nano:
+		layout = "2006-01-02T15:04:05" + fraction(unit, ".999999999") + "Z07:00"
+	}
+	return func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		if loc != nil {
+			t = t.In(loc)
+		} else {
+			t = t.Local()
+		}
+		enc.AppendString(t.Format(layout))
+	}
+}
+
+// fraction returns the fractional seconds of a layout for unit, or def if
+// unit is 0.
+func fraction(unit time.Duration, def string) string {
+	switch unit {
+	case 0:
+		return def
+	case time.Second:
+		return ""
+	case time.Millisecond:
+		return ".000"
+	case time.Microsecond:
+		return ".000000"
+	default:
+		return ".000000000"
+	}
+}
+
+// epochTimeEncoder returns a zapcore.TimeEncoder encoding times as
+// floating-point multiples of scale since the epoch, truncated to unit if
+// set.
+func epochTimeEncoder(scale, unit time.Duration) zapcore.TimeEncoder {
+	if unit == 0 {
+		if scale == time.Second {
+			return zapcore.EpochTimeEncoder
+		}
+		return zapcore.EpochMillisTimeEncoder
+	}
+	return func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		nanos := t.UnixNano()
+		nanos -= nanos % int64(unit)
+		enc.AppendFloat64(float64(nanos) / float64(scale))
+	}
+}
+
+// WithKeys overrides the names of the fields entries are encoded with, e.g.
+// for an aggregator expecting "@timestamp" and "severity".  Empty fields of
+// keys keep their DefaultKeys value.
+func WithKeys(keys Keys) LoggerOption {
+	return func(o *options) {
+		override := func(key *string, name string) {
+			if name != "" {
+				*key = name
+			}
+		}
+		override(&o.keys.Time, keys.Time)
+		override(&o.keys.Level, keys.Level)
+		override(&o.keys.Name, keys.Name)
+		override(&o.keys.Caller, keys.Caller)
+		override(&o.keys.Message, keys.Message)
+		override(&o.keys.Stacktrace, keys.Stacktrace)
+	}
+}
+
+// WithoutTimestamp leaves the time out of the entries, e.g. for journald,
+// which stamps the lines itself.  The GELF encoding always has it.
+func WithoutTimestamp() LoggerOption {
+	return func(o *options) {
+		o.noTimestamp = true
+	}
+}
+
+// WithoutLevel leaves the level out of the entries, for minimal output.  The
+// GELF encoding always has it.
+func WithoutLevel() LoggerOption {
+	return func(o *options) {
+		o.noLevel = true
+	}
+}
+
+// WithoutCaller leaves the caller out of the entries even if WithCaller or
+// WithCallerSkip records it, e.g. for a Config whose Options must override
+// its Caller.
+func WithoutCaller() LoggerOption {
+	return func(o *options) {
+		o.noCaller = true
+	}
+}
+
+// WithCaller sets whether entries record the file and line they were logged
+// from.  It is off by default.
+func WithCaller(enabled bool) LoggerOption {
+	return func(o *options) {
+		o.caller = enabled
+	}
+}
+
+// WithCallerSkip sets how many extra stack frames to skip when recording the
+// caller, so that logging wrappers report their own caller.  It implies
+// WithCaller(true).
+func WithCallerSkip(skip int) LoggerOption {
+	return func(o *options) {
+		o.caller = true
+		o.callerSkip = skip
+	}
+}
+
+// WithStacktraceLevel attaches a stacktrace to entries at l and above.  No
+// stacktraces are recorded by default.
+func WithStacktraceLevel(l zapcore.Level) LoggerOption {
+	return func(o *options) {
+		o.stacktrace = true
+		o.stacktraceLevel = l
+	}
+}
+
+// WithSampling samples entries to bound the volume of repeated lines: within
+// each tick, the first entries with a given level and message are logged, then
+// only every thereafter-th one.  A zero tick defaults to one second.  Sampling
+// is disabled when first and thereafter are both zero, which is the default.
+func WithSampling(first, thereafter int, tick time.Duration) LoggerOption {
+	return func(o *options) {
+		o.sampleFirst = first
+		o.sampleThereafter = thereafter
+		o.sampleTick = tick
+	}
+}
+
+// samplingParams returns the WithSampling arguments.
+func (o *options) samplingParams() *samplingParams {
+	return &samplingParams{o.sampleTick, o.sampleFirst, o.sampleThereafter}
+}
+
+// WithWriterOptions configures every Writer the loggers write to, e.g. with
+// rotation settings.
+func WithWriterOptions(opts ...Option) LoggerOption {
+	return func(o *options) {
+		o.writerOptions = append(o.writerOptions, opts...)
+	}
+}
+
+// WithErrorFilename additionally writes the entries at error level and above
+// of all loggers to a file with the given name in the log directory, e.g. for
+// on-call to grep.  The file shares the WithWriterOptions of the main files.
+func WithErrorFilename(name string) LoggerOption {
+	return func(o *options) {
+		o.errorFilename = name
+	}
+}
+
+// WithWriter makes all loggers write to w instead of their files, e.g. a
+// bytes.Buffer in tests or a network connection.  The log directory isn't
+// used then, and no files are rotated.
+func WithWriter(w io.Writer) LoggerOption {
+	return func(o *options) {
+		o.writer = w
+	}
+}
+
+// WithConsole additionally writes the entries of all loggers to stdout, using
+// the console encoding whatever the encoding of the files, e.g. for local
+// development.
+func WithConsole(enabled bool) LoggerOption {
+	return func(o *options) {
+		o.console = enabled
+	}
+}
+
+// WithConsoleColor sets whether the levels of the entries written by
+// WithConsole are colored.  By default they are if stdout is a terminal, so
+// that piping the output to a file or another program doesn't fill it with
+// escape codes.
+func WithConsoleColor(enabled bool) LoggerOption {
+	return func(o *options) {
+		o.consoleColor = enabled
+		o.consoleColorSet = true
+	}
+}
+
+// WithConsoleLevel sets the minimum level of the entries written by
+// WithConsole independently of the level of the files, which it follows by
+// default.
+func WithConsoleLevel(l zapcore.Level) LoggerOption {
+	return func(o *options) {
+		o.consoleLevel = l
+		o.consoleLevelSet = true
+	}
+}
+
+// newWriter returns a Writer for filename configured by the writer options.
+func (o *options) newWriter(filename string) *Writer {
+	return NewWriter(filename, o.writerOptions...)
+}
+
+// newCore returns the core of the logger called name writing entries to ws,
+// and to the shared outputs.
+func (o *options) newCore(name string, ws zapcore.WriteSyncer, shared sinks) (zapcore.Core, error) {
+	var enab zapcore.LevelEnabler = level
+	if l, ok := o.levelOverride(name); ok {
+		enab = l
+	}
+	return o.newCoreAt(enab, ws, shared)
+}
+
+// newCoreAt is newCore for the entries enab enables.
+func (o *options) newCoreAt(enab zapcore.LevelEnabler, ws zapcore.WriteSyncer, shared sinks) (zapcore.Core, error) {
+	enc, err := o.newEncoder()
+	if err != nil {
+		return nil, err
+	}
+	core := o.newLeafCore(enc, ws, enab)
+	if shared.console != nil {
+		cfg := o.encoderConfig()
+		upper := o.levelFmt == LevelFormatUppercase || o.levelFmt == LevelFormatUppercaseColor
+		cfg.EncodeLevel = consoleLevelEncoder(upper, shared.consoleColor)
+		consoleLevel := enab
+		if o.consoleLevelSet {
+			consoleLevel = o.consoleLevel
+		}
+		core = zapcore.NewTee(core, o.newLeafCore(zapcore.NewConsoleEncoder(cfg), shared.console, consoleLevel))
+	}
+	if shared.errWriter != nil {
+		errLevel := zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l >= zapcore.ErrorLevel && enab.Enabled(l)
+		})
+		core = zapcore.NewTee(core, o.newLeafCore(enc.Clone(), shared.errWriter, errLevel))
+	}
+	if shared.remote != nil {
+		core = zapcore.NewTee(core, o.newLeafCore(enc.Clone(), zapcore.AddSync(shared.remote), enab))
+	}
+	if shared.syslog != nil {
+		core = zapcore.NewTee(core, o.wrapLeafCore(newSyslogCore(enc.Clone(), shared.syslog, enab)))
+	}
+	if shared.journald != nil {
+		core = zapcore.NewTee(core, o.wrapLeafCore(newJournaldCore(shared.journald, o.journald.tag, enab)))
+	}
+	for _, extra := range o.extraCores {
+		core = TeeCores(core, newLevelFilterCore(extra, enab))
+	}
+	if o.sampling != nil {
+		core = newSamplerCore(core, o.sampling, o.deadlineThreshold)
+	} else {
+		core = o.samplingParams().wrap(core)
+	}
+	core = newFieldSamplerCore(core, o.fieldSampling)
+	core = newDedupCore(core, o.dedupWindow, o.dedupKey, o.errorSink)
+	return newGoroutineIDCore(core, o.goroutineID), nil
+}
+
+// newLeafCore returns a core encoding entries with enc to ws, redacting,
+// sanitizing and truncating them as configured.
+func (o *options) newLeafCore(enc zapcore.Encoder, ws zapcore.WriteSyncer, enab zapcore.LevelEnabler) zapcore.Core {
+	return o.wrapLeafCore(zapcore.NewCore(enc, ws, enab))
+}
+
+// wrapLeafCore wraps a core writing what it is given in the redacting,
+// sanitizing and truncating cores as configured.
+func (o *options) wrapLeafCore(core zapcore.Core) zapcore.Core {
+	core = newTruncateCore(core, o.maxFieldBytes, o.maxMessageBytes)
+	core = newSanitizeCore(core, o.sanitize)
+	return newRedactCore(core, o.redactKeys)
+}
+
+// WithFields attaches fields to every entry of all loggers, e.g. the version
+// of the application.
+func WithFields(fields ...zap.Field) LoggerOption {
+	return func(o *options) {
+		o.fields = append(o.fields, fields...)
+	}
+}
+
+// WithProcessFields attaches the hostname and process id to every entry of
+// all loggers, as "host" and "pid".
+func WithProcessFields() LoggerOption {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return WithFields(zap.String("host", host), zap.Int("pid", os.Getpid()))
+}
+
+// WithOptions passes opts to zap.New when building the loggers, for the zap
+// settings no LoggerOption covers, such as zap.Hooks or zap.WrapCore.  They
+// are applied after the package's own, so they override them, e.g. a
+// zap.WrapCore wraps the cores the package built.
+func WithOptions(opts ...zap.Option) LoggerOption {
+	return func(o *options) {
+		o.zapOpts = append(o.zapOpts, opts...)
+	}
+}
+
+// zapOptions returns the zap.Options the loggers are built with.
+func (o *options) zapOptions() []zap.Option {
+	var opts []zap.Option
+	if len(o.fields) > 0 {
+		opts = append(opts, zap.Fields(o.fields...))
+	}
+	if o.caller && !o.noCaller {
+		opts = append(opts, zap.AddCaller(), zap.AddCallerSkip(o.callerSkip))
+	}
+	if o.stacktrace {
+		opts = append(opts, zap.AddStacktrace(o.stacktraceLevel))
+	}
+	if o.errorSink != nil {
+		opts = append(opts, zap.ErrorOutput(o.errorSink))
+	}
+	opts = append(opts, zap.WithFatalHook(fatalHook{sync: o.syncOnFatal}))
+	return append(opts, o.zapOpts...)
+}
+
+// encoderConfig returns the zapcore.EncoderConfig of the loggers.
+func (o *options) encoderConfig() zapcore.EncoderConfig {
+	cfg := zapcore.EncoderConfig{
+		TimeKey:        o.keys.Time,
+		LevelKey:       o.keys.Level,
+		NameKey:        o.keys.Name,
+		CallerKey:      o.keys.Caller,
+		MessageKey:     o.keys.Message,
+		StacktraceKey:  o.keys.Stacktrace,
+		EncodeLevel:    levelEncoders[o.levelFmt],
+		EncodeTime:     timeEncoder(o.timeFormat, o.precision, o.location),
+		EncodeDuration: durationEncoders[o.duration],
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+		EncodeName:     zapcore.FullNameEncoder,
+	}
+	if o.noTimestamp {
+		cfg.TimeKey = ""
+	}
+	if o.noLevel {
+		cfg.LevelKey = ""
+	}
+	if o.noCaller {
+		cfg.CallerKey = ""
+	}
+	return cfg
+}
+
+// newEncoder returns the encoder selected by the encoding option.
+func (o *options) newEncoder() (zapcore.Encoder, error) {
+	if _, ok := durationEncoders[o.duration]; !ok {
+		return nil, errors.Errorf("unknown duration format %q", o.duration)
+	}
+	if _, ok := timePrecisions[o.precision]; !ok {
+		return nil, errors.Errorf("unknown time precision %q", o.precision)
+	}
+	if _, ok := levelEncoders[o.levelFmt]; !ok {
+		return nil, errors.Errorf("unknown level format %q", o.levelFmt)
+	}
+	var enc zapcore.Encoder
+	switch o.encoding {
+	case EncodingJSON:
+		enc = zapcore.NewJSONEncoder(o.encoderConfig())
+	case EncodingConsole:
+		enc = zapcore.NewConsoleEncoder(o.encoderConfig())
+	case EncodingLogfmt:
+		enc = newLogfmtEncoder(o.encoderConfig())
+	case EncodingGELF:
+		enc = newGELFEncoder(o.encoderConfig(), o.gelfHost)
+	default:
+		return nil, errors.Errorf("unknown encoding %q", o.encoding)
+	}
+	if o.flattenSep != "" {
+		enc = newFlattenEncoder(enc, o.flattenSep, o.flattenDepth)
+	}
+	return enc, nil
+}
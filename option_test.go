@@ -0,0 +1,192 @@
+package zaphelper
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestTimeFormat(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	ts := time.Date(2018, 3, 1, 4, 5, 6, 789123456, time.UTC)
+
+	tests := []struct {
+		format string
+		want   interface{}
+	}{
+		{TimeFormatDefault, "2018-03-01 12:05:06"},
+		{TimeFormatISO8601, "2018-03-01T12:05:06.789+0800"},
+		{TimeFormatRFC3339Nano, "2018-03-01T12:05:06.789123456+08:00"},
+		{TimeFormatEpoch, float64(ts.UnixNano()) / float64(time.Second)},
+		{TimeFormatEpochMillis, float64(ts.UnixNano()) / float64(time.Millisecond)},
+		{"02/01/2006 15:04", "01/03/2018 12:05"},
+	}
+	for _, tt := range tests {
+		o := newOptions(false)
+		o.location = loc
+		WithTimeFormat(tt.format)(&o)
+		entry := encodeEntry(&o, zapcore.Entry{Time: ts, Message: "hello"}, t)
+		equals(tt.want, entry["time"], t)
+	}
+}
+
+func TestTimePrecision(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	ts := time.Date(2018, 3, 1, 4, 5, 6, 789123456, time.UTC)
+
+	tests := []struct {
+		precision string
+		// fraction is the fractional seconds of the layouts
+		fraction string
+		unit     time.Duration
+	}{
+		{TimePrecisionSeconds, "", time.Second},
+		{TimePrecisionMillis, ".789", time.Millisecond},
+		{TimePrecisionMicros, ".789123", time.Microsecond},
+		{TimePrecisionNanos, ".789123456", time.Nanosecond},
+	}
+	for _, tt := range tests {
+		o := newOptions(false)
+		o.location = loc
+		WithTimePrecision(tt.precision)(&o)
+		for format, want := range map[string]string{
+			TimeFormatDefault:     "2018-03-01 12:05:06" + tt.fraction,
+			TimeFormatISO8601:     "2018-03-01T12:05:06" + tt.fraction + "+0800",
+			TimeFormatRFC3339Nano: "2018-03-01T12:05:06" + tt.fraction + "+08:00",
+		} {
+			WithTimeFormat(format)(&o)
+			entry := encodeEntry(&o, zapcore.Entry{Time: ts, Message: "hello"}, t)
+			equals(want, entry["time"], t)
+		}
+
+		truncated := float64(ts.Truncate(tt.unit).UnixNano())
+		WithTimeFormat(TimeFormatEpoch)(&o)
+		entry := encodeEntry(&o, zapcore.Entry{Time: ts, Message: "hello"}, t)
+		equals(truncated/float64(time.Second), entry["time"], t)
+		WithTimeFormat(TimeFormatEpochMillis)(&o)
+		entry = encodeEntry(&o, zapcore.Entry{Time: ts, Message: "hello"}, t)
+		equals(truncated/float64(time.Millisecond), entry["time"], t)
+	}
+
+	o := newOptions(false)
+	WithTimePrecision("picos")(&o)
+	if _, err := o.newEncoder(); err == nil || !strings.Contains(err.Error(), "time precision") {
+		t.Fatalf("expected an unknown time precision error, got %v", err)
+	}
+}
+
+func TestDurationFormat(t *testing.T) {
+	d := 1500 * time.Millisecond
+	tests := []struct {
+		format string
+		want   interface{}
+	}{
+		{DurationFormatNanos, float64(1500000000)},
+		{DurationFormatSeconds, 1.5},
+		{DurationFormatMillis, float64(1500)},
+		{DurationFormatString, "1.5s"},
+	}
+	for _, tt := range tests {
+		o := newOptions(false)
+		WithDurationFormat(tt.format)(&o)
+		entry := encodeEntry(&o, zapcore.Entry{Message: "hello"}, t, zap.Duration("elapsed", d))
+		equals(tt.want, entry["elapsed"], t)
+	}
+}
+
+func TestLevelFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{LevelFormatLowercase, "warn"},
+		{LevelFormatUppercase, "WARN"},
+		{LevelFormatColor, "\x1b[33mwarn\x1b[0m"},
+		{LevelFormatUppercaseColor, "\x1b[33mWARN\x1b[0m"},
+	}
+	for _, tt := range tests {
+		o := newOptions(false)
+		WithLevelFormat(tt.format)(&o)
+		entry := encodeEntry(&o, zapcore.Entry{Level: zapcore.WarnLevel, Message: "hello"}, t)
+		equals(tt.want, entry["level"], t)
+	}
+}
+
+func TestUnknownFormats(t *testing.T) {
+	for _, opt := range []LoggerOption{WithDurationFormat("fortnights"), WithLevelFormat("shouty")} {
+		if err := InitLogger("", false, nil, WithWriter(ioutil.Discard), opt); err == nil {
+			t.Fatal("expected an error for an unknown format")
+		}
+	}
+}
+
+// encodeEntry encodes the given entry with the encoder configured by o and
+// decodes it back from JSON.
+func TestWithOptions(t *testing.T) {
+	var buf bytes.Buffer
+	var messages []string
+	hook := zap.Hooks(func(ent zapcore.Entry) error {
+		messages = append(messages, ent.Message)
+		return nil
+	})
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithOptions(hook, zap.IncreaseLevel(zapcore.WarnLevel))), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestWithOptions")
+	logger.Info("dropped")
+	logger.Warn("first")
+	logger.Error("second")
+	equals("first,second", strings.Join(messages, ","), t)
+	equals(2, len(decodeEntries(buf.String(), t)), t)
+}
+
+func TestWithoutTimestamp(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithCaller(true), WithoutTimestamp()), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestWithoutTimestamp")
+	logger.Infow("hello", "user", "bob")
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	if _, ok := entries[0]["time"]; ok {
+		t.Fatalf("unexpected time field in %v", entries[0])
+	}
+	equals("info", entries[0]["level"], t)
+	equals("hello", entries[0]["message"], t)
+	equals("bob", entries[0]["user"], t)
+	if _, ok := entries[0]["caller"]; !ok {
+		t.Fatalf("expected a caller field in %v", entries[0])
+	}
+
+	buf.Reset()
+	isNil(InitFromConfig(Config{Caller: true, DisableLevel: true, DisableCaller: true, Options: []LoggerOption{WithWriter(&buf)}}), t)
+	GetLogger("TestWithoutTimestamp").Infow("hello", "user", "bob")
+	entries = decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	for _, key := range []string{"level", "caller"} {
+		if _, ok := entries[0][key]; ok {
+			t.Fatalf("unexpected %s field in %v", key, entries[0])
+		}
+	}
+	equals(3, len(entries[0]), t)
+	equals("hello", entries[0]["message"], t)
+	equals("bob", entries[0]["user"], t)
+}
+
+func encodeEntry(o *options, ent zapcore.Entry, t testing.TB, fields ...zapcore.Field) map[string]interface{} {
+	t.Helper()
+	enc, err := o.newEncoder()
+	isNil(err, t)
+	buf, err := enc.EncodeEntry(ent, fields)
+	isNil(err, t)
+	entry := map[string]interface{}{}
+	isNil(json.Unmarshal(buf.Bytes(), &entry), t)
+	return entry
+}
@@ -0,0 +1,57 @@
+package zaphelper
+
+import (
+	"context"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// WithSyncOnFatal makes Fatal flush all loggers with Sync before exiting, so
+// that the entries buffered by other loggers aren't lost with the process.
+// By default Fatal only flushes the logger it is called on.
+func WithSyncOnFatal(enabled bool) LoggerOption {
+	return func(o *options) {
+		o.syncOnFatal = enabled
+	}
+}
+
+// exit is how Fatal ends the process.  It exists so that tests can mock it
+// out to record the exit code instead of exiting; it is for testing only.
+var exit = os.Exit
+
+// fatalHook ends the process after a Fatal entry through exit, flushing all
+// loggers first with WithSyncOnFatal.
+type fatalHook struct {
+	sync bool
+}
+
+func (h fatalHook) OnWrite(*zapcore.CheckedEntry, []zapcore.Field) {
+	if h.sync {
+		// the process exits whether or not the files could be flushed
+		_ = Sync()
+	}
+	exit(1)
+}
+
+// RecoverAndLog recovers a panic, logs it with its stacktrace as "stack" to
+// Logger at error level, flushes all loggers with Sync, and panics again with the same
+// value.  It is meant to be deferred at the top of goroutines, so that the
+// panic and the entries before it reach the files:
+//
+//	go func() {
+//		defer zaphelper.RecoverAndLog()
+//		...
+//	}()
+func RecoverAndLog() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	logger := LoggerFromContext(context.Background()).Desugar()
+	logger.Error("panic", zap.Any("panic", r), zap.StackSkip("stack", 1))
+	// the panic goes on whether or not the files could be flushed
+	_ = Sync()
+	panic(r)
+}
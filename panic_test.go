@@ -0,0 +1,63 @@
+package zaphelper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverAndLog(t *testing.T) {
+	dir := makeTempDir("TestRecoverAndLog", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil, WithWriterOptions(func(w *Writer) { w.BufferSize = 64 * 1024 })), t)
+	defer initDefaults(t)
+
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		defer RecoverAndLog()
+		panic("boom")
+	}()
+	equals("boom", recovered, t)
+
+	// flushed from the buffer
+	entries := readEntries(filepath.Join(dir, time.Now().Format("2006-01-02")+".log"), t)
+	equals(1, len(entries), t)
+	equals("panic", entries[0]["message"], t)
+	equals("error", entries[0]["level"], t)
+	equals("boom", entries[0]["panic"], t)
+	if !strings.Contains(entries[0]["stack"].(string), "TestRecoverAndLog") {
+		t.Fatalf("expected the panicking function in the stack: %v", entries[0]["stack"])
+	}
+}
+
+func TestRecoverAndLogNoPanic(t *testing.T) {
+	func() {
+		defer RecoverAndLog()
+	}()
+}
+
+func TestFatalExit(t *testing.T) {
+	code := -1
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithSyncOnFatal(true)), t)
+	defer initDefaults(t)
+
+	GetLogger("TestFatalExit").Fatal("bye")
+	equals(1, code, t)
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	equals("fatal", entries[0]["level"], t)
+
+	code = -1
+	isNil(InitLogger("", false, nil, WithWriter(&buf)), t)
+	GetRawLogger("TestFatalExit").Fatal("bye again")
+	equals(1, code, t)
+}
@@ -0,0 +1,73 @@
+// Package promcollector exports the counters of zaphelper Writers as
+// Prometheus metrics, kept out of the zaphelper package so that it doesn't
+// depend on the Prometheus client.
+package promcollector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yeeuu/zaphelper"
+)
+
+var (
+	bytesWrittenDesc = prometheus.NewDesc(
+		"zaphelper_writer_bytes_written_total",
+		"Bytes written to log files.",
+		[]string{"filename"}, nil,
+	)
+	rotationsDesc = prometheus.NewDesc(
+		"zaphelper_writer_rotations_total",
+		"Rotations of log files performed.",
+		[]string{"filename"}, nil,
+	)
+	writeErrorsDesc = prometheus.NewDesc(
+		"zaphelper_writer_write_errors_total",
+		"Writes to log files that failed.",
+		[]string{"filename"}, nil,
+	)
+	fileSizeDesc = prometheus.NewDesc(
+		"zaphelper_writer_file_size_bytes",
+		"Size of the current log file.",
+		[]string{"filename"}, nil,
+	)
+	backupsDesc = prometheus.NewDesc(
+		"zaphelper_writer_backups",
+		"Old log files left by rotation.",
+		[]string{"filename"}, nil,
+	)
+)
+
+// collector is a prometheus.Collector reading the counters of a Writer on
+// each scrape.
+type collector struct {
+	w *zaphelper.Writer
+}
+
+// NewPrometheusCollector returns a prometheus.Collector exporting the bytes
+// written, rotations and write errors of w, the size of its current file and
+// the number of its backups, labelled with its filename so that several
+// Writers can be registered.
+func NewPrometheusCollector(w *zaphelper.Writer) prometheus.Collector {
+	return &collector{w: w}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bytesWrittenDesc
+	ch <- rotationsDesc
+	ch <- writeErrorsDesc
+	ch <- fileSizeDesc
+	ch <- backupsDesc
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	name := c.w.CurrentFilename()
+	stats := c.w.Stats()
+	ch <- prometheus.MustNewConstMetric(bytesWrittenDesc, prometheus.CounterValue, float64(stats.BytesWritten), name)
+	ch <- prometheus.MustNewConstMetric(rotationsDesc, prometheus.CounterValue, float64(stats.Rotations), name)
+	ch <- prometheus.MustNewConstMetric(writeErrorsDesc, prometheus.CounterValue, float64(stats.WriteErrors), name)
+	ch <- prometheus.MustNewConstMetric(fileSizeDesc, prometheus.GaugeValue, float64(c.w.Size()), name)
+	if backups, err := c.w.Backups(); err == nil {
+		ch <- prometheus.MustNewConstMetric(backupsDesc, prometheus.GaugeValue, float64(len(backups)), name)
+	} else {
+		ch <- prometheus.NewInvalidMetric(backupsDesc, err)
+	}
+}
@@ -0,0 +1,58 @@
+package promcollector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/yeeuu/zaphelper"
+)
+
+func TestNewPrometheusCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestNewPrometheusCollector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &zaphelper.Writer{Filename: filename}
+	defer w.Close()
+	if _, err := w.Write([]byte("boo!\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("bar\n")); err != nil {
+		t.Fatal(err)
+	}
+	older := filepath.Join(dir, "app-2018-03-01T12-00-00.000.log")
+	if err := ioutil.WriteFile(older, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.NewReplacer("FILENAME", filename).Replace(`
+# HELP zaphelper_writer_backups Old log files left by rotation.
+# TYPE zaphelper_writer_backups gauge
+zaphelper_writer_backups{filename="FILENAME"} 2
+# HELP zaphelper_writer_bytes_written_total Bytes written to log files.
+# TYPE zaphelper_writer_bytes_written_total counter
+zaphelper_writer_bytes_written_total{filename="FILENAME"} 9
+# HELP zaphelper_writer_file_size_bytes Size of the current log file.
+# TYPE zaphelper_writer_file_size_bytes gauge
+zaphelper_writer_file_size_bytes{filename="FILENAME"} 4
+# HELP zaphelper_writer_rotations_total Rotations of log files performed.
+# TYPE zaphelper_writer_rotations_total counter
+zaphelper_writer_rotations_total{filename="FILENAME"} 1
+# HELP zaphelper_writer_write_errors_total Writes to log files that failed.
+# TYPE zaphelper_writer_write_errors_total counter
+zaphelper_writer_write_errors_total{filename="FILENAME"} 0
+`)
+	if err := testutil.CollectAndCompare(NewPrometheusCollector(w), strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,357 @@
+package zaphelper
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redacted replaces the values of the fields named by WithRedactKeys.
+const redacted = "[REDACTED]"
+
+// WithRedactKeys replaces the values of the fields with the given keys, matched
+// case-insensitively, with "[REDACTED]" before they are encoded, as a safety
+// net for secrets such as "password", "authorization" or "token".  The keys of
+// the objects, arrays and reflected values nested in the fields are matched
+// too; reflected values are walked as they encode to JSON.
+func WithRedactKeys(keys ...string) LoggerOption {
+	return func(o *options) {
+		if o.redactKeys == nil {
+			o.redactKeys = make(map[string]bool, len(keys))
+		}
+		for _, key := range keys {
+			o.redactKeys[strings.ToLower(key)] = true
+		}
+	}
+}
+
+// redactCore is a zapcore.Core redacting the fields named by keys before
+// passing them on.
+type redactCore struct {
+	zapcore.Core
+	keys map[string]bool
+}
+
+// newRedactCore returns core redacting the fields named by keys, or core
+// itself if there are none.  core must be a leaf core, which writes what it is
+// given: wrapping a tee or sampler would bypass their Check.
+func newRedactCore(core zapcore.Core, keys map[string]bool) zapcore.Core {
+	if len(keys) == 0 {
+		return core
+	}
+	return &redactCore{Core: core, keys: keys}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.redact(fields)), keys: c.keys}
+}
+
+func (c *redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *redactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, c.redact(fields))
+}
+
+// redact returns fields with the values of the ones named by keys replaced,
+// and those nesting keys wrapped to redact them, copying fields only if
+// needed.
+func (c *redactCore) redact(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		rf, ok := redactField(f, c.keys)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = make([]zapcore.Field, len(fields))
+			copy(out, fields)
+		}
+		out[i] = rf
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// redactField returns f redacted as keys say, and whether it differs from f.
+func redactField(f zapcore.Field, keys map[string]bool) (zapcore.Field, bool) {
+	if keys[strings.ToLower(f.Key)] && f.Type != zapcore.NamespaceType && f.Type != zapcore.InlineMarshalerType {
+		return zap.String(f.Key, redacted), true
+	}
+	switch f.Type {
+	case zapcore.ObjectMarshalerType:
+		return zap.Object(f.Key, redactObject{f.Interface.(zapcore.ObjectMarshaler), keys}), true
+	case zapcore.InlineMarshalerType:
+		return zap.Inline(redactObject{f.Interface.(zapcore.ObjectMarshaler), keys}), true
+	case zapcore.ArrayMarshalerType:
+		return zap.Array(f.Key, redactArray{f.Interface.(zapcore.ArrayMarshaler), keys}), true
+	case zapcore.ReflectType:
+		if v, ok := redactReflected(f.Interface, keys); ok {
+			return zap.Reflect(f.Key, v), true
+		}
+	}
+	return f, false
+}
+
+// redactReflected returns v, as it encodes to JSON, with the values named by
+// keys replaced, and whether any was.
+func redactReflected(v interface{}, keys map[string]bool) (interface{}, bool) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// left to the encoder to report
+		return v, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return v, false
+	}
+	return redactTree(tree, keys)
+}
+
+// redactTree replaces the values named by keys in the decoded JSON tree.
+func redactTree(tree interface{}, keys map[string]bool) (interface{}, bool) {
+	changed := false
+	switch t := tree.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			if keys[strings.ToLower(k)] {
+				t[k] = redacted
+				changed = true
+			} else if rv, ok := redactTree(v, keys); ok {
+				t[k] = rv
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i, v := range t {
+			if rv, ok := redactTree(v, keys); ok {
+				t[i] = rv
+				changed = true
+			}
+		}
+	}
+	return tree, changed
+}
+
+// redactObject is a zapcore.ObjectMarshaler redacting the keys that the one
+// it wraps adds.
+type redactObject struct {
+	m    zapcore.ObjectMarshaler
+	keys map[string]bool
+}
+
+func (o redactObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return o.m.MarshalLogObject(&redactEncoder{ObjectEncoder: enc, keys: o.keys})
+}
+
+// redactArray is a zapcore.ArrayMarshaler redacting the keys of the objects
+// that the one it wraps appends.
+type redactArray struct {
+	m    zapcore.ArrayMarshaler
+	keys map[string]bool
+}
+
+func (a redactArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return a.m.MarshalLogArray(&redactArrayEncoder{ArrayEncoder: enc, keys: a.keys})
+}
+
+// redactEncoder is a zapcore.ObjectEncoder adding "[REDACTED]" for the keys
+// named by keys instead of their values.
+type redactEncoder struct {
+	zapcore.ObjectEncoder
+	keys map[string]bool
+}
+
+// redact adds key as redacted if it is named by keys, and reports whether it
+// was.
+func (e *redactEncoder) redact(key string) bool {
+	if !e.keys[strings.ToLower(key)] {
+		return false
+	}
+	e.ObjectEncoder.AddString(key, redacted)
+	return true
+}
+
+func (e *redactEncoder) AddArray(key string, m zapcore.ArrayMarshaler) error {
+	if e.redact(key) {
+		return nil
+	}
+	return e.ObjectEncoder.AddArray(key, redactArray{m, e.keys})
+}
+
+func (e *redactEncoder) AddObject(key string, m zapcore.ObjectMarshaler) error {
+	if e.redact(key) {
+		return nil
+	}
+	return e.ObjectEncoder.AddObject(key, redactObject{m, e.keys})
+}
+
+func (e *redactEncoder) AddReflected(key string, v interface{}) error {
+	if e.redact(key) {
+		return nil
+	}
+	if rv, ok := redactReflected(v, e.keys); ok {
+		v = rv
+	}
+	return e.ObjectEncoder.AddReflected(key, v)
+}
+
+func (e *redactEncoder) AddBinary(key string, v []byte) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddBinary(key, v)
+	}
+}
+
+func (e *redactEncoder) AddByteString(key string, v []byte) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddByteString(key, v)
+	}
+}
+
+func (e *redactEncoder) AddBool(key string, v bool) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddBool(key, v)
+	}
+}
+
+func (e *redactEncoder) AddComplex128(key string, v complex128) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddComplex128(key, v)
+	}
+}
+
+func (e *redactEncoder) AddComplex64(key string, v complex64) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddComplex64(key, v)
+	}
+}
+
+func (e *redactEncoder) AddDuration(key string, v time.Duration) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddDuration(key, v)
+	}
+}
+
+func (e *redactEncoder) AddFloat64(key string, v float64) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddFloat64(key, v)
+	}
+}
+
+func (e *redactEncoder) AddFloat32(key string, v float32) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddFloat32(key, v)
+	}
+}
+
+func (e *redactEncoder) AddInt(key string, v int) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddInt(key, v)
+	}
+}
+
+func (e *redactEncoder) AddInt64(key string, v int64) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddInt64(key, v)
+	}
+}
+
+func (e *redactEncoder) AddInt32(key string, v int32) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddInt32(key, v)
+	}
+}
+
+func (e *redactEncoder) AddInt16(key string, v int16) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddInt16(key, v)
+	}
+}
+
+func (e *redactEncoder) AddInt8(key string, v int8) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddInt8(key, v)
+	}
+}
+
+func (e *redactEncoder) AddString(key, v string) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddString(key, v)
+	}
+}
+
+func (e *redactEncoder) AddTime(key string, v time.Time) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddTime(key, v)
+	}
+}
+
+func (e *redactEncoder) AddUint(key string, v uint) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddUint(key, v)
+	}
+}
+
+func (e *redactEncoder) AddUint64(key string, v uint64) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddUint64(key, v)
+	}
+}
+
+func (e *redactEncoder) AddUint32(key string, v uint32) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddUint32(key, v)
+	}
+}
+
+func (e *redactEncoder) AddUint16(key string, v uint16) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddUint16(key, v)
+	}
+}
+
+func (e *redactEncoder) AddUint8(key string, v uint8) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddUint8(key, v)
+	}
+}
+
+func (e *redactEncoder) AddUintptr(key string, v uintptr) {
+	if !e.redact(key) {
+		e.ObjectEncoder.AddUintptr(key, v)
+	}
+}
+
+// redactArrayEncoder is a zapcore.ArrayEncoder redacting the keys of the
+// objects appended to it.
+type redactArrayEncoder struct {
+	zapcore.ArrayEncoder
+	keys map[string]bool
+}
+
+func (e *redactArrayEncoder) AppendArray(m zapcore.ArrayMarshaler) error {
+	return e.ArrayEncoder.AppendArray(redactArray{m, e.keys})
+}
+
+func (e *redactArrayEncoder) AppendObject(m zapcore.ObjectMarshaler) error {
+	return e.ArrayEncoder.AppendObject(redactObject{m, e.keys})
+}
+
+func (e *redactArrayEncoder) AppendReflected(v interface{}) error {
+	if rv, ok := redactReflected(v, e.keys); ok {
+		v = rv
+	}
+	return e.ArrayEncoder.AppendReflected(v)
+}
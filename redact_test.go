@@ -0,0 +1,77 @@
+package zaphelper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestWithRedactKeys(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithRedactKeys("password", "Authorization"),
+		WithFields(zap.String("authorization", "Bearer s3cr3t"))), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestWithRedactKeys")
+	logger.With("PASSWORD", "hunter2").Infow("login", "user", "alice", "Password", "hunter2")
+
+	if strings.Contains(buf.String(), "hunter2") || strings.Contains(buf.String(), "s3cr3t") {
+		t.Fatalf("secret leaked: %s", buf.String())
+	}
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	equals("alice", entries[0]["user"], t)
+	equals(redacted, entries[0]["Password"], t)
+	equals(redacted, entries[0]["PASSWORD"], t)
+	equals(redacted, entries[0]["authorization"], t)
+}
+
+// credentials is an object nesting a secret.
+type credentials struct {
+	user, password string
+}
+
+func (c credentials) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("user", c.user)
+	enc.AddString("password", c.password)
+	return enc.AddObject("token", credentials{})
+}
+
+func TestWithRedactKeysNested(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithRedactKeys("password", "token")), t)
+	defer initDefaults(t)
+
+	logger := GetRawLogger("TestWithRedactKeysNested")
+	logger.Info("login",
+		zap.Object("creds", credentials{"alice", "hunter2"}),
+		zap.Array("all", zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+			return enc.AppendObject(credentials{"bob", "hunter2"})
+		})),
+		zap.Any("headers", map[string]interface{}{"Token": "hunter2", "nested": map[string]string{"password": "hunter2"}, "id": 1}),
+		zap.Any("form", struct {
+			User     string `json:"user"`
+			Password string `json:"password"`
+		}{"carol", "hunter2"}),
+		zap.Namespace("request"),
+		zap.String("password", "hunter2"),
+	)
+
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("secret leaked: %s", buf.String())
+	}
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	creds := entries[0]["creds"].(map[string]interface{})
+	equals("alice", creds["user"], t)
+	equals(redacted, creds["password"], t)
+	equals(redacted, creds["token"], t)
+	headers := entries[0]["headers"].(map[string]interface{})
+	equals(redacted, headers["Token"], t)
+	equals(float64(1), headers["id"], t)
+	equals("carol", entries[0]["form"].(map[string]interface{})["user"], t)
+	equals(redacted, entries[0]["request"].(map[string]interface{})["password"], t)
+}
@@ -0,0 +1,205 @@
+package zaphelper
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ensure we always implement io.WriteCloser
+var _ io.WriteCloser = (*RemoteWriter)(nil)
+
+// defaultRemoteDepth is the queue depth of a RemoteWriter given none.
+const defaultRemoteDepth = 1024
+
+var (
+	// remoteMinBackoff and remoteMaxBackoff bound the delay between two
+	// connection attempts of a RemoteWriter.  They are variables so tests can
+	// mock them out.
+	remoteMinBackoff = 100 * time.Millisecond
+	remoteMaxBackoff = 30 * time.Second
+	// remoteDialTimeout and remoteWriteTimeout bound a connection attempt and
+	// a write of a RemoteWriter, so that an unreachable collector can't hang
+	// it.  They are variables so tests can mock them out.
+	remoteDialTimeout  = 5 * time.Second
+	remoteWriteTimeout = 5 * time.Second
+)
+
+// RemoteWriter is an io.WriteCloser forwarding writes over a network
+// connection, e.g. newline-delimited JSON entries to a log collector over TCP.
+// Writes are queued in memory and sent by a background goroutine, which
+// connects on demand and reconnects with exponential backoff when the
+// connection drops.  When the queue is full, writes are dropped and counted
+// instead.  Note that TCP may accept a few writes after the peer is gone, which
+// are then lost.
+type RemoteWriter struct {
+	// dropped is accessed atomically and kept first for 64-bit alignment.
+	dropped uint64
+
+	network, addr string
+	depth         int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewRemoteWriter returns a RemoteWriter connecting to addr over network, as
+// for net.Dial, with a queue holding up to depth pending writes, 1024 if not
+// positive.
+func NewRemoteWriter(network, addr string, depth int) *RemoteWriter {
+	if depth <= 0 {
+		depth = defaultRemoteDepth
+	}
+	r := &RemoteWriter{
+		network: network,
+		addr:    addr,
+		depth:   depth,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mu)
+	go r.run()
+	return r
+}
+
+// WithRemote additionally sends the entries of all loggers to addr over
+// network through a RemoteWriter queueing up to depth entries, 1024 if not
+// positive.
+func WithRemote(network, addr string, depth int) LoggerOption {
+	return func(o *options) {
+		o.remote = &remoteConfig{network: network, addr: addr, depth: depth}
+	}
+}
+
+// remoteConfig is the destination WithRemote sends entries to.
+type remoteConfig struct {
+	network, addr string
+	depth         int
+}
+
+// Write implements io.Writer.  It queues a copy of p and returns immediately,
+// dropping p if the queue is full.
+func (r *RemoteWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errors.New("write to closed RemoteWriter")
+	}
+	if len(r.queue) >= r.depth {
+		atomic.AddUint64(&r.dropped, 1)
+		return len(p), nil
+	}
+	// zap reuses its buffers once Write returns
+	b := make([]byte, len(p))
+	copy(b, p)
+	r.queue = append(r.queue, b)
+	r.cond.Signal()
+	return len(p), nil
+}
+
+// Dropped returns the number of writes dropped because the queue was full.
+func (r *RemoteWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Stats returns the number of dropped writes.
+func (r *RemoteWriter) Stats() WriterStats {
+	return WriterStats{Dropped: r.Dropped()}
+}
+
+// Close implements io.Closer.  It stops accepting writes, sends the queued
+// ones if connected, and closes the connection.  Queued writes are dropped if
+// there is no connection, or if sending them takes longer than 10s, in which
+// case the connection is closed in the background.
+func (r *RemoteWriter) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.stop)
+	r.cond.Signal()
+	r.mu.Unlock()
+
+	timer := time.NewTimer(closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+		return nil
+	case <-timer.C:
+		return errors.Errorf("timed out after %v sending the queued entries", closeTimeout)
+	}
+}
+
+// run sends the queued writes, until Close is called.
+func (r *RemoteWriter) run() {
+	defer close(r.done)
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	backoff := remoteMinBackoff
+	for {
+		r.mu.Lock()
+		for len(r.queue) == 0 && !r.closed {
+			r.cond.Wait()
+		}
+		if len(r.queue) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		p := r.queue[0]
+		closed := r.closed
+		r.mu.Unlock()
+
+		if conn == nil {
+			var err error
+			conn, err = net.DialTimeout(r.network, r.addr, remoteDialTimeout)
+			if err != nil {
+				if closed {
+					return
+				}
+				select {
+				case <-time.After(backoff):
+				case <-r.stop:
+					return
+				}
+				if backoff *= 2; backoff > remoteMaxBackoff {
+					backoff = remoteMaxBackoff
+				}
+				continue
+			}
+			backoff = remoteMinBackoff
+		}
+		// without a deadline the write is only bounded by the OS
+		_ = conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout))
+		if _, err := conn.Write(p); err != nil {
+			conn.Close()
+			conn = nil
+			r.mu.Lock()
+			closed = r.closed
+			r.mu.Unlock()
+			// the rest of the queue is dropped if the connection breaks
+			// while closing, rather than reconnecting
+			if closed {
+				return
+			}
+			continue
+		}
+		r.mu.Lock()
+		r.queue[0] = nil
+		r.queue = r.queue[1:]
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,166 @@
+package zaphelper
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteWriterReconnects(t *testing.T) {
+	remoteMinBackoff = time.Millisecond
+	defer func() { remoteMinBackoff = 100 * time.Millisecond }()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	isNil(err, t)
+	defer lis.Close()
+	lines := make(chan string, 100)
+	go func() {
+		for conn := 0; ; conn++ {
+			c, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			scanner := bufio.NewScanner(c)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+				// drop the first connection mid-stream
+				if conn == 0 {
+					break
+				}
+			}
+			c.Close()
+		}
+	}()
+
+	r := NewRemoteWriter("tcp", lis.Addr().String(), 100)
+	_, err = r.Write([]byte("first\n"))
+	isNil(err, t)
+	equals("first", <-lines, t)
+
+	// writes right after the drop may be lost, so keep writing until one gets
+	// through the new connection
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		_, err = r.Write([]byte("again\n"))
+		isNil(err, t)
+		select {
+		case line := <-lines:
+			equals("again", line, t)
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for the writer to reconnect")
+		}
+	}
+	isNil(r.Close(), t)
+	equals(uint64(0), r.Dropped(), t)
+}
+
+func TestRemoteWriterDrops(t *testing.T) {
+	// nothing listens there anymore
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	isNil(err, t)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	r := NewRemoteWriter("tcp", addr, 2)
+	for i := 0; i < 5; i++ {
+		_, err := r.Write([]byte("boo!\n"))
+		isNil(err, t)
+	}
+	equals(uint64(3), r.Dropped(), t)
+	equals(uint64(3), r.Stats().Dropped, t)
+	isNil(r.Close(), t)
+
+	_, err = r.Write([]byte("boo!\n"))
+	if err == nil {
+		t.Fatal("expected an error writing to a closed RemoteWriter")
+	}
+}
+
+func TestRemoteWriterDefaultDepth(t *testing.T) {
+	// nothing listens there anymore
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	isNil(err, t)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	for _, depth := range []int{0, -1} {
+		r := NewRemoteWriter("tcp", addr, depth)
+		equals(defaultRemoteDepth, r.depth, t)
+		for i := 0; i < 5; i++ {
+			_, err := r.Write([]byte("boo!\n"))
+			isNil(err, t)
+		}
+		equals(uint64(0), r.Dropped(), t)
+		isNil(r.Close(), t)
+	}
+}
+
+func TestRemoteWriterCloseTimeout(t *testing.T) {
+	closeTimeout = 50 * time.Millisecond
+	defer func() { closeTimeout = 10 * time.Second }()
+
+	// a collector accepting the connection but never reading from it
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	isNil(err, t)
+	defer lis.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	r := NewRemoteWriter("tcp", lis.Addr().String(), 100)
+	chunk := make([]byte, 1<<20)
+	for i := 0; i < 64; i++ {
+		_, err := r.Write(chunk)
+		isNil(err, t)
+	}
+	start := time.Now()
+	if err := r.Close(); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected Close to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Close took %v", elapsed)
+	}
+	// the writes fail once the collector goes away, which ends the writer
+	(<-accepted).Close()
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the writer to stop")
+	}
+}
+
+func TestWithRemote(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	isNil(err, t)
+	defer lis.Close()
+	received := make(chan string, 1)
+	go func() {
+		c, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		received <- line
+	}()
+
+	dir := makeTempDir("TestWithRemote", t)
+	defer os.RemoveAll(dir)
+	isNil(InitLogger(dir, false, nil, WithRemote("tcp", lis.Addr().String(), 10)), t)
+	defer InitLogger(dir, false, nil)
+
+	GetLogger("TestWithRemote").Info("hello")
+	line := <-received
+	if !strings.Contains(line, `"message":"hello"`) {
+		t.Fatalf("unexpected line %q", line)
+	}
+}
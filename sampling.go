@@ -0,0 +1,285 @@
+package zaphelper
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// deadlineKey is the key of the field FromContext passes the deadline of its
+// context to the samplerCore with.
+const deadlineKey = "zaphelper.deadline"
+
+// WithDeadlineSampling keeps every entry of the loggers returned by
+// FromContext for a context whose deadline is less than threshold away, or
+// past, instead of sampling them, as those requests are the likely ones to
+// fail and their logs the ones wanted.  The tradeoff is that the volume goes
+// up precisely when requests run slow: under an overload that brings every
+// request near its deadline, nothing is sampled anymore.  Entries are still
+// deduplicated by WithDedup.
+func WithDeadlineSampling(threshold time.Duration) LoggerOption {
+	return func(o *options) {
+		o.deadlineThreshold = threshold
+	}
+}
+
+// deadlineField returns the field FromContext adds for the deadline of ctx,
+// if sampling by deadline and ctx has one.
+func (o *options) deadlineField(ctx context.Context) (zap.Field, bool) {
+	if o.deadlineThreshold <= 0 {
+		return zap.Field{}, false
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return zap.Field{}, false
+	}
+	// a skipped field, which the encoders ignore
+	return zap.Field{Key: deadlineKey, Type: zapcore.SkipType, Interface: deadline}, true
+}
+
+// samplingParams are the arguments of WithSampling.
+type samplingParams struct {
+	tick              time.Duration
+	first, thereafter int
+}
+
+// wrap returns core sampled according to p, or core itself if sampling is
+// disabled.
+func (p *samplingParams) wrap(core zapcore.Core) zapcore.Core {
+	if p.first == 0 && p.thereafter == 0 {
+		return core
+	}
+	tick := p.tick
+	if tick == 0 {
+		tick = time.Second
+	}
+	return zapcore.NewSamplerWithOptions(core, tick, p.first, p.thereafter)
+}
+
+// samplingSettings hold the sampling of the loggers built by InitLogger, which
+// WatchConfig may change while they run.
+type samplingSettings struct {
+	params atomic.Value // *samplingParams
+}
+
+func newSamplingSettings(p samplingParams) *samplingSettings {
+	s := &samplingSettings{}
+	s.set(p)
+	return s
+}
+
+func (s *samplingSettings) get() *samplingParams {
+	return s.params.Load().(*samplingParams)
+}
+
+func (s *samplingSettings) set(p samplingParams) {
+	s.params.Store(&p)
+}
+
+// samplerCore is a zapcore.Core sampling its entries according to the current
+// samplingSettings.  The cores derived from it by With share its counts, which
+// start over when the settings change.
+type samplerCore struct {
+	zapcore.Core
+	settings *samplingSettings
+	shared   *sharedSampler
+	// fields are those added by With since newSamplerCore.
+	fields  []zapcore.Field
+	sampled atomic.Value // *sampledCore
+	// threshold is the WithDeadlineSampling argument, and deadline that of
+	// the context of the logger, if any.
+	threshold time.Duration
+	deadline  time.Time
+}
+
+// sharedSampler is the sampler of a samplerCore and of those derived from it,
+// rebuilt whenever the samplingSettings change.
+type sharedSampler struct {
+	core    zapcore.Core
+	mu      sync.Mutex
+	sampled *sampledCore
+}
+
+// sampledCore is the sampler built for a samplingParams.
+type sampledCore struct {
+	params *samplingParams
+	core   zapcore.Core
+}
+
+func newSamplerCore(core zapcore.Core, settings *samplingSettings, threshold time.Duration) *samplerCore {
+	return &samplerCore{Core: core, settings: settings, shared: &sharedSampler{core: core}, threshold: threshold}
+}
+
+// get returns the sampler for p.
+func (s *sharedSampler) get(p *samplingParams) *sampledCore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sampled == nil || s.sampled.params != p {
+		s.sampled = &sampledCore{params: p, core: p.wrap(s.core)}
+	}
+	return s.sampled
+}
+
+// current returns the sampler for the current samplingSettings.
+func (c *samplerCore) current() zapcore.Core {
+	p := c.settings.get()
+	if s, ok := c.sampled.Load().(*sampledCore); ok && s.params == p {
+		return s.core
+	}
+	core := c.shared.get(p).core
+	if len(c.fields) > 0 {
+		// the sampler's With keeps its counts
+		core = core.With(c.fields)
+	}
+	c.sampled.Store(&sampledCore{params: p, core: core})
+	return core
+}
+
+func (c *samplerCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &samplerCore{
+		Core:      c.Core.With(fields),
+		settings:  c.settings,
+		shared:    c.shared,
+		fields:    append(c.fields[:len(c.fields):len(c.fields)], fields...),
+		threshold: c.threshold,
+		deadline:  c.deadline,
+	}
+	for _, f := range fields {
+		if deadline, ok := f.Interface.(time.Time); ok && f.Key == deadlineKey && f.Type == zapcore.SkipType {
+			clone.deadline = deadline
+		}
+	}
+	return clone
+}
+
+func (c *samplerCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.deadline.IsZero() && c.deadline.Sub(currentTime()) < c.threshold {
+		return c.Core.Check(ent, ce)
+	}
+	return c.current().Check(ent, ce)
+}
+
+// WithFieldSampling samples entries like WithSampling, but counting them by
+// the value of the field called key rather than by message, e.g. "tenant_id"
+// so that each tenant gets its share instead of a chatty one crowding out the
+// rest.  Entries without the field are counted by message.  The counts are
+// shared by all loggers and start over every tick, one second if 0.  It
+// applies on top of WithSampling.
+func WithFieldSampling(key string, first, thereafter int, tick time.Duration) LoggerOption {
+	return func(o *options) {
+		if tick <= 0 {
+			tick = time.Second
+		}
+		o.fieldSampling = &fieldSampling{
+			key:        key,
+			first:      first,
+			thereafter: thereafter,
+			tick:       tick,
+			counts:     &fieldCounts{counts: map[string]int{}},
+		}
+	}
+}
+
+// fieldSampling are the arguments of WithFieldSampling, and the counts of the
+// cores built from them.
+type fieldSampling struct {
+	key               string
+	first, thereafter int
+	tick              time.Duration
+	counts            *fieldCounts
+}
+
+// fieldSamplerCore is a zapcore.Core sampling its entries by the value of a
+// field, which only Write gets to see.
+type fieldSamplerCore struct {
+	zapcore.Core
+	params *fieldSampling
+	// value is the value of the field among those of With, if any.
+	value    string
+	hasValue bool
+}
+
+// fieldCounts are the counts of the entries by sampling key within the
+// current tick.
+type fieldCounts struct {
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+// newFieldSamplerCore returns core sampled by field as p says, or core itself
+// if p is nil.  Sampled entries are passed on through core's Check.
+func newFieldSamplerCore(core zapcore.Core, p *fieldSampling) zapcore.Core {
+	if p == nil {
+		return core
+	}
+	return &fieldSamplerCore{Core: core, params: p}
+}
+
+func (c *fieldSamplerCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.Core = c.Core.With(fields)
+	if v, ok := fieldValue(fields, c.params.key); ok {
+		clone.value, clone.hasValue = v, true
+	}
+	return &clone
+}
+
+func (c *fieldSamplerCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *fieldSamplerCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	key := ent.Level.String() + "\x00"
+	if v, ok := fieldValue(fields, c.params.key); ok {
+		key += "field\x00" + v
+	} else if c.hasValue {
+		key += "field\x00" + c.value
+	} else {
+		key += "message\x00" + ent.Message
+	}
+	if !c.params.counts.sample(key, ent.Time, c.params) {
+		return nil
+	}
+	return writeThrough(c.Core, ent, fields)
+}
+
+// sample counts the entry at t sampled by key, and reports whether it is
+// kept.
+func (s *fieldCounts) sample(key string, t time.Time, p *fieldSampling) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.Sub(s.start) >= p.tick || t.Before(s.start) {
+		// a fresh map, so that the keys of the past ticks don't pile up
+		s.start = t
+		s.counts = map[string]int{}
+	}
+	n := s.counts[key] + 1
+	s.counts[key] = n
+	if n <= p.first {
+		return true
+	}
+	return p.thereafter > 0 && (n-p.first)%p.thereafter == 0
+}
+
+// fieldValue returns the value of the last field called key among fields.
+func fieldValue(fields []zapcore.Field, key string) (string, bool) {
+	for i := len(fields) - 1; i >= 0; i-- {
+		f := fields[i]
+		if f.Key != key {
+			continue
+		}
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		return fmt.Sprint(enc.Fields[key]), true
+	}
+	return "", false
+}
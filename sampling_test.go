@@ -0,0 +1,72 @@
+package zaphelper
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFieldSampling(t *testing.T) {
+	inner, recorded := observer.New(zapcore.DebugLevel)
+	o := newOptions(false)
+	WithFieldSampling("tenant_id", 2, 3, time.Hour)(&o)
+	logger := zap.New(newFieldSamplerCore(inner, o.fieldSampling))
+
+	// a chatty tenant doesn't eat into the share of the others
+	for i := 0; i < 10; i++ {
+		logger.Info("request", zap.String("tenant_id", "chatty"), zap.Int("i", i))
+	}
+	for i := 0; i < 2; i++ {
+		logger.Info("request", zap.String("tenant_id", "quiet"), zap.Int("i", i))
+	}
+	// the field may come from With, and the others are counted by message
+	tenant := logger.With(zap.String("tenant_id", "bound"))
+	for i := 0; i < 5; i++ {
+		tenant.Info("request", zap.Int("i", i))
+		logger.Info("untagged", zap.Int("i", i))
+	}
+
+	counts := map[string][]int64{}
+	for _, ent := range recorded.AllUntimed() {
+		key := ent.Message
+		if v, ok := ent.ContextMap()["tenant_id"]; ok {
+			key = v.(string)
+		}
+		counts[key] = append(counts[key], ent.ContextMap()["i"].(int64))
+	}
+	equals("[0 1 4 7]", fmt.Sprint(counts["chatty"]), t)
+	equals("[0 1]", fmt.Sprint(counts["quiet"]), t)
+	equals("[0 1 4]", fmt.Sprint(counts["bound"]), t)
+	equals("[0 1 4]", fmt.Sprint(counts["untagged"]), t)
+}
+
+func TestWithFieldSampling(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithFieldSampling("tenant_id", 1, 0, time.Hour)), t)
+	defer initDefaults(t)
+
+	// the counts are shared by the loggers
+	for _, name := range []string{"first", "second"} {
+		logger := GetLogger(name)
+		logger.Infow("request", "tenant_id", "a")
+		logger.Infow("request", "tenant_id", "b")
+	}
+	equals(2, len(decodeEntries(buf.String(), t)), t)
+}
+
+func TestFieldSamplingWriteError(t *testing.T) {
+	var out bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(brokenWriter{}), WithErrorOutput(&out), WithFieldSampling("tenant_id", 1, 0, time.Hour)), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestFieldSamplingWriteError")
+	logger.Infow("request", "tenant_id", "a")
+	logger.Infow("request", "tenant_id", "a")
+	equals(1, strings.Count(out.String(), "write error: no space left on device"), t)
+}
@@ -0,0 +1,98 @@
+package zaphelper
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// WithSanitize escapes the newlines and other control characters in messages
+// and string fields before they are encoded, so that logging untrusted input
+// can't forge log lines.  The JSON encoding escapes them already, but the
+// console encoding doesn't.
+func WithSanitize(enabled bool) LoggerOption {
+	return func(o *options) {
+		o.sanitize = enabled
+	}
+}
+
+// sanitizeCore is a zapcore.Core escaping the control characters of messages
+// and string fields before passing them on.
+type sanitizeCore struct {
+	zapcore.Core
+}
+
+// newSanitizeCore returns core sanitizing its input if enabled, or core
+// itself.  Like for newRedactCore, core must be a leaf core.
+func newSanitizeCore(core zapcore.Core, enabled bool) zapcore.Core {
+	if !enabled {
+		return core
+	}
+	return &sanitizeCore{Core: core}
+}
+
+func (c *sanitizeCore) With(fields []zapcore.Field) zapcore.Core {
+	return &sanitizeCore{Core: c.Core.With(sanitizeFields(fields))}
+}
+
+func (c *sanitizeCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *sanitizeCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = sanitize(ent.Message)
+	return c.Core.Write(ent, sanitizeFields(fields))
+}
+
+// sanitizeFields returns fields with their string values sanitized, copying
+// fields only if needed.
+func sanitizeFields(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		if f.Type != zapcore.StringType {
+			continue
+		}
+		s := sanitize(f.String)
+		if s == f.String {
+			continue
+		}
+		if out == nil {
+			out = make([]zapcore.Field, len(fields))
+			copy(out, fields)
+		}
+		out[i].String = s
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// sanitize escapes the control characters in s, e.g. a newline as `\n`.
+func sanitize(s string) string {
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
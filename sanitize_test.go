@@ -0,0 +1,27 @@
+package zaphelper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWithSanitize(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithEncoding(EncodingConsole), WithSanitize(true)), t)
+	defer initDefaults(t)
+
+	forged := "hello\n{\"level\":\"fatal\",\"message\":\"forged\"}"
+	GetLogger("TestWithSanitize").Infow(forged, "input", forged)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	equals(1, len(lines), t)
+	if !strings.Contains(lines[0], `hello\n{`) {
+		t.Fatalf("expected the newline to be escaped: %q", lines[0])
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	equals("plain", sanitize("plain"), t)
+	equals(`a\nb\rc\td\u001be`, sanitize("a\nb\rc\td\x1be"), t)
+}
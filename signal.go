@@ -0,0 +1,69 @@
+//go:build !js
+// +build !js
+
+package zaphelper
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	// sighupStops stop the goroutines of HandleSIGHUP, for Shutdown, which
+	// waits for them with sighupDone.
+	sighupStops []chan struct{}
+	sighupMu    sync.Mutex
+	sighupDone  sync.WaitGroup
+)
+
+// HandleSIGHUP rotates all log files with RotateLog each time the process
+// receives SIGHUP, until ctx is done or Shutdown is called.  Signals are
+// handled one at a time, so rotations never overlap; a SIGHUP arriving during
+// a rotation triggers one more afterwards.
+func HandleSIGHUP(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	stop := make(chan struct{})
+	sighupMu.Lock()
+	sighupStops = append(sighupStops, stop)
+	sighupDone.Add(1)
+	sighupMu.Unlock()
+	go func() {
+		defer sighupDone.Done()
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-sig:
+				RotateLog()
+			case <-ctx.Done():
+				sighupMu.Lock()
+				defer sighupMu.Unlock()
+				for i, s := range sighupStops {
+					if s == stop {
+						sighupStops = append(sighupStops[:i], sighupStops[i+1:]...)
+						break
+					}
+				}
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopSIGHUP stops the goroutines started by HandleSIGHUP and waits for them,
+// along with the rotations they are running.
+func stopSIGHUP() {
+	sighupMu.Lock()
+	stops := sighupStops
+	sighupStops = nil
+	sighupMu.Unlock()
+	for _, stop := range stops {
+		close(stop)
+	}
+	sighupDone.Wait()
+}
@@ -0,0 +1,12 @@
+//go:build js
+// +build js
+
+package zaphelper
+
+import "context"
+
+// HandleSIGHUP does nothing: there are no signals on this platform.
+func HandleSIGHUP(ctx context.Context) {}
+
+// stopSIGHUP does nothing, as HandleSIGHUP starts no goroutines.
+func stopSIGHUP() {}
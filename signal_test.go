@@ -0,0 +1,78 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package zaphelper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleSIGHUP(t *testing.T) {
+	dir := makeTempDir("TestHandleSIGHUP", t)
+	defer os.RemoveAll(dir)
+
+	isNil(InitLogger(dir, false, nil), t)
+	logger := GetLogger("app")
+	logger.Info("before")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	HandleSIGHUP(ctx)
+
+	isNil(syscall.Kill(os.Getpid(), syscall.SIGHUP), t)
+	waitFor(func() bool { return len(backupFiles(dir, t)) == 1 }, t)
+
+	logger.Info("after")
+	isNil(logger.Sync(), t)
+	entries := readEntries(filepath.Join(dir, "app.log"), t)
+	equals(1, len(entries), t)
+	equals("after", entries[0]["message"], t)
+}
+
+func TestHandleSIGHUPDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	HandleSIGHUP(ctx)
+	cancel()
+	// the goroutine leaves nothing behind for Shutdown to stop
+	waitFor(func() bool {
+		sighupMu.Lock()
+		defer sighupMu.Unlock()
+		return len(sighupStops) == 0
+	}, t)
+}
+
+func TestShutdownWaitsForSIGHUP(t *testing.T) {
+	defer initDefaults(t)
+	dir := makeTempDir("TestShutdownWaitsForSIGHUP", t)
+	defer os.RemoveAll(dir)
+
+	started := make(chan struct{}, 1)
+	var finished int32
+	hook := func(w *Writer) {
+		w.OnRotate = func(oldPath, newPath string) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			time.Sleep(50 * time.Millisecond)
+			atomic.StoreInt32(&finished, 1)
+		}
+	}
+	isNil(InitLogger(dir, false, nil, WithWriterOptions(hook)), t)
+	HandleSIGHUP(context.Background())
+
+	isNil(syscall.Kill(os.Getpid(), syscall.SIGHUP), t)
+	<-started
+	// Shutdown stops the goroutine of HandleSIGHUP once its rotation is done
+	isNil(Shutdown(context.Background()), t)
+	equals(int32(1), atomic.LoadInt32(&finished), t)
+	sighupMu.Lock()
+	equals(0, len(sighupStops), t)
+	sighupMu.Unlock()
+}
@@ -0,0 +1,95 @@
+package zaphelper
+
+import (
+	"bytes"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogConfig is the syslog daemon WithSyslog sends entries to.
+type syslogConfig struct {
+	network, addr, tag string
+}
+
+// WithSyslog additionally sends the entries of all loggers to the syslog
+// daemon at addr over network, as for syslog.Dial, tagged with tag: an empty
+// network and addr mean the local daemon.  Levels are mapped to syslog
+// severities.  Syslog isn't supported on Windows and Plan 9, where
+// InitLogger fails if WithSyslog is used.
+func WithSyslog(network, addr, tag string) LoggerOption {
+	return func(o *options) {
+		o.syslog = &syslogConfig{network: network, addr: addr, tag: tag}
+	}
+}
+
+// syslogWriter is the part of *syslog.Writer the syslog core uses, one method
+// per severity.
+type syslogWriter interface {
+	Debug(m string) error
+	Info(m string) error
+	Warning(m string) error
+	Err(m string) error
+	Crit(m string) error
+	Close() error
+}
+
+// syslogCore is a zapcore.Core sending the entries it encodes to syslog with
+// the severity matching their level.
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	w   syslogWriter
+}
+
+// newSyslogCore returns a core sending entries encoded by enc to w.
+func newSyslogCore(enc zapcore.Encoder, w syslogWriter, enab zapcore.LevelEnabler) zapcore.Core {
+	return &syslogCore{LevelEnabler: enab, enc: enc, w: w}
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &syslogCore{LevelEnabler: c.LevelEnabler, enc: c.enc.Clone(), w: c.w}
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	return clone
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	// syslog frames messages itself
+	msg := string(bytes.TrimRight(buf.Bytes(), "\n"))
+	buf.Free()
+	return multierr.Append(err, syslogSend(c.w, ent.Level, msg))
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
+
+// syslogSend sends msg to w with the severity matching l.
+func syslogSend(w syslogWriter, l zapcore.Level, msg string) error {
+	switch l {
+	case zapcore.DebugLevel:
+		return w.Debug(msg)
+	case zapcore.InfoLevel:
+		return w.Info(msg)
+	case zapcore.WarnLevel:
+		return w.Warning(msg)
+	case zapcore.ErrorLevel:
+		return w.Err(msg)
+	default:
+		// DPanic, Panic and Fatal
+		return w.Crit(msg)
+	}
+}
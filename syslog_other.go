@@ -0,0 +1,13 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package zaphelper
+
+import (
+	"github.com/pkg/errors"
+)
+
+// dialSyslog fails: log/syslog isn't available on this platform.
+func dialSyslog(cfg *syslogConfig) (syslogWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package zaphelper
+
+import (
+	"log/syslog"
+
+	"github.com/pkg/errors"
+)
+
+// dialSyslog connects to the syslog daemon configured by WithSyslog.
+func dialSyslog(cfg *syslogConfig) (syslogWriter, error) {
+	w, err := syslog.Dial(cfg.network, cfg.addr, syslog.LOG_USER|syslog.LOG_INFO, cfg.tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't connect to syslog")
+	}
+	return w, nil
+}
@@ -0,0 +1,55 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package zaphelper
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	isNil(err, t)
+	defer conn.Close()
+
+	dir := makeTempDir("TestWithSyslog", t)
+	defer os.RemoveAll(dir)
+	isNil(InitLogger(dir, true, nil, WithSyslog("udp", conn.LocalAddr().String(), "myapp")), t)
+	defer InitLogger(dir, false, nil)
+
+	logger := GetLogger("TestWithSyslog")
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+	logger.DPanic("dpanic")
+
+	// LOG_USER is facility 1, so priorities are 8 + severity
+	for _, expected := range []struct {
+		priority, message string
+	}{
+		{"<15>", "debug"},
+		{"<14>", "info"},
+		{"<12>", "warn"},
+		{"<11>", "error"},
+		{"<10>", "dpanic"},
+	} {
+		buf := make([]byte, 4096)
+		isNil(conn.SetReadDeadline(time.Now().Add(5*time.Second)), t)
+		n, _, err := conn.ReadFrom(buf)
+		isNil(err, t)
+		packet := string(buf[:n])
+		if !strings.HasPrefix(packet, expected.priority) || !strings.Contains(packet, "myapp") ||
+			!strings.Contains(packet, `"message":"`+expected.message+`"`) {
+			t.Fatalf("expected %s %s, got %q", expected.priority, expected.message, packet)
+		}
+	}
+	// the file still gets the entries
+	isNil(logger.Sync(), t)
+	equals(5, len(readEntries(filepath.Join(dir, "TestWithSyslog.log"), t)), t)
+}
@@ -0,0 +1,61 @@
+package zaphelper
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// TeeCores returns a core duplicating entries to each of cores, skipping the
+// nil ones, like zapcore.NewTee.
+func TeeCores(cores ...zapcore.Core) zapcore.Core {
+	var tee []zapcore.Core
+	for _, core := range cores {
+		if core != nil {
+			tee = append(tee, core)
+		}
+	}
+	switch len(tee) {
+	case 0:
+		return zapcore.NewNopCore()
+	case 1:
+		return tee[0]
+	default:
+		return zapcore.NewTee(tee...)
+	}
+}
+
+// WithExtraCore additionally sends the entries of all loggers to core, e.g. an
+// observer in tests or the core of a log shipper.  core only gets the entries
+// enabled by the level of the loggers, on top of its own, and is sampled and
+// deduplicated along with the files, but the entries aren't redacted,
+// sanitized or truncated for it.
+func WithExtraCore(core zapcore.Core) LoggerOption {
+	return func(o *options) {
+		o.extraCores = append(o.extraCores, core)
+	}
+}
+
+// levelFilterCore is a zapcore.Core only passing on the entries enab enables.
+type levelFilterCore struct {
+	zapcore.Core
+	enab zapcore.LevelEnabler
+}
+
+// newLevelFilterCore returns core restricted to the entries enab enables.
+func newLevelFilterCore(core zapcore.Core, enab zapcore.LevelEnabler) zapcore.Core {
+	return &levelFilterCore{core, enab}
+}
+
+func (c *levelFilterCore) Enabled(l zapcore.Level) bool {
+	return c.enab.Enabled(l) && c.Core.Enabled(l)
+}
+
+func (c *levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelFilterCore{c.Core.With(fields), c.enab}
+}
+
+func (c *levelFilterCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enab.Enabled(ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
@@ -0,0 +1,55 @@
+package zaphelper
+
+import (
+	"bytes"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTeeCores(t *testing.T) {
+	equals(zapcore.NewNopCore(), TeeCores(nil, nil), t)
+	first, firstLogs := observer.New(zapcore.InfoLevel)
+	equals(first, TeeCores(nil, first), t)
+
+	second, secondLogs := observer.New(zapcore.WarnLevel)
+	core := TeeCores(first, nil, second)
+	for _, ent := range []zapcore.Entry{
+		{Level: zapcore.InfoLevel, Message: "info"},
+		{Level: zapcore.WarnLevel, Message: "warn"},
+	} {
+		if ce := core.Check(ent, nil); ce != nil {
+			ce.Write()
+		}
+	}
+	equals(2, firstLogs.Len(), t)
+	equals(1, secondLogs.Len(), t)
+	equals("warn", secondLogs.All()[0].Message, t)
+}
+
+func TestWithExtraCore(t *testing.T) {
+	var buf bytes.Buffer
+	first, firstLogs := observer.New(zapcore.DebugLevel)
+	second, secondLogs := observer.New(zapcore.WarnLevel)
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithExtraCore(first), WithExtraCore(second)), t)
+	defer initDefaults(t)
+
+	logger := GetLogger("TestWithExtraCore").With("user", "bob")
+	logger.Debug("dropped")
+	logger.Info("first")
+	logger.Warn("second")
+
+	// the extra cores only get what the level enables, on top of their own
+	equals(2, len(decodeEntries(buf.String(), t)), t)
+	equals(2, firstLogs.Len(), t)
+	equals(1, secondLogs.Len(), t)
+	ent := secondLogs.All()[0]
+	equals("second", ent.Message, t)
+	equals("bob", ent.ContextMap()["user"], t)
+
+	SetLevel(zapcore.DebugLevel)
+	logger.Debug("kept")
+	equals(3, firstLogs.Len(), t)
+	equals("kept", firstLogs.All()[2].Message, t)
+}
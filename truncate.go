@@ -0,0 +1,100 @@
+package zaphelper
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// WithMaxFieldBytes truncates the values of string fields longer than n bytes
+// to n bytes followed by "...(12345 bytes)", giving their original length, so
+// that logging a request body or a base64 payload can't bloat the files.
+// Numeric and object fields are left alone.  Zero, the default, disables it.
+func WithMaxFieldBytes(n int) LoggerOption {
+	return func(o *options) {
+		o.maxFieldBytes = n
+	}
+}
+
+// WithMaxMessageBytes truncates messages longer than n bytes the same way
+// WithMaxFieldBytes does string fields.  Zero, the default, disables it.
+func WithMaxMessageBytes(n int) LoggerOption {
+	return func(o *options) {
+		o.maxMessageBytes = n
+	}
+}
+
+// truncateCore is a zapcore.Core truncating long messages and string fields
+// before passing them on.
+type truncateCore struct {
+	zapcore.Core
+	maxField, maxMessage int
+}
+
+// newTruncateCore returns core truncating its input to the given limits, or
+// core itself if there are none.  Like for newRedactCore, core must be a leaf
+// core.
+func newTruncateCore(core zapcore.Core, maxField, maxMessage int) zapcore.Core {
+	if maxField <= 0 && maxMessage <= 0 {
+		return core
+	}
+	return &truncateCore{Core: core, maxField: maxField, maxMessage: maxMessage}
+}
+
+func (c *truncateCore) With(fields []zapcore.Field) zapcore.Core {
+	return &truncateCore{
+		Core:       c.Core.With(truncateFields(fields, c.maxField)),
+		maxField:   c.maxField,
+		maxMessage: c.maxMessage,
+	}
+}
+
+func (c *truncateCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *truncateCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	ent.Message = truncate(ent.Message, c.maxMessage)
+	return c.Core.Write(ent, truncateFields(fields, c.maxField))
+}
+
+// truncateFields returns fields with their string values truncated to max
+// bytes, copying fields only if needed.
+func truncateFields(fields []zapcore.Field, max int) []zapcore.Field {
+	if max <= 0 {
+		return fields
+	}
+	var out []zapcore.Field
+	for i, f := range fields {
+		if f.Type != zapcore.StringType || len(f.String) <= max {
+			continue
+		}
+		if out == nil {
+			out = make([]zapcore.Field, len(fields))
+			copy(out, fields)
+		}
+		out[i].String = truncate(f.String, max)
+	}
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+// truncate cuts s to at most max bytes, without splitting a UTF-8 sequence,
+// and notes its original length.  s is returned as is if it fits or max isn't
+// positive.
+func truncate(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "...(" + strconv.Itoa(len(s)) + " bytes)"
+}
@@ -0,0 +1,33 @@
+package zaphelper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWithMaxFieldBytes(t *testing.T) {
+	var buf bytes.Buffer
+	isNil(InitLogger("", false, nil, WithWriter(&buf), WithMaxFieldBytes(8), WithMaxMessageBytes(5)), t)
+	defer initDefaults(t)
+
+	blob := strings.Repeat("x", 12345)
+	logger := GetLogger("TestWithMaxFieldBytes")
+	logger.With("body", blob).Infow("hello world", "short", "ok", "count", 1234567890, "payload", blob)
+
+	entries := decodeEntries(buf.String(), t)
+	equals(1, len(entries), t)
+	equals("hello...(11 bytes)", entries[0]["message"], t)
+	equals("xxxxxxxx...(12345 bytes)", entries[0]["body"], t)
+	equals("xxxxxxxx...(12345 bytes)", entries[0]["payload"], t)
+	equals("ok", entries[0]["short"], t)
+	equals(float64(1234567890), entries[0]["count"], t)
+}
+
+func TestTruncate(t *testing.T) {
+	equals("short", truncate("short", 5), t)
+	equals("short", truncate("short", 0), t)
+	equals("sh...(5 bytes)", truncate("short", 2), t)
+	// "é" is two bytes, which aren't split
+	equals("...(2 bytes)", truncate("é", 1), t)
+}
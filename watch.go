@@ -0,0 +1,117 @@
+package zaphelper
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// configPollInterval is how often WatchConfig checks the config file.  It is
+// a variable so tests can mock it out.
+var configPollInterval = time.Second
+
+// WatchConfig reloads the Config at path whenever the file changes, until ctx
+// is done, applying the settings that can change while the loggers run: Level
+// and Sampling.  A change is only loaded once the file stays the same for a
+// poll, so that a file still being written isn't loaded half-way.  Changes to the other settings are logged to
+// LoggerFromContext(ctx) as requiring a restart and ignored, as are files that
+// can't be loaded.  The loggers are expected to have been initialized from
+// path, e.g. by InitFromConfig; the file is loaded right away, and its Level
+// and Sampling applied in case it changed since.
+// WatchConfig blocks, so it is meant to run in its own goroutine.  It returns
+// ctx.Err() once ctx is done, or right away the error loading path if it
+// can't.
+func WatchConfig(ctx context.Context, path string) error {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	applyLive(LoggerFromContext(ctx), path, cfg)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	// pending is the change seen at the last poll, not loaded yet
+	var pending os.FileInfo
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		latest, err := os.Stat(path)
+		if err != nil || sameStat(latest, info) {
+			continue
+		}
+		// the file may be half-written, so wait for it to settle
+		if pending == nil || !sameStat(latest, pending) {
+			pending = latest
+			continue
+		}
+		info, pending = latest, nil
+		next, err := LoadConfig(path)
+		logger := LoggerFromContext(ctx)
+		if err != nil {
+			logger.Warnw("can't reload config", "path", path, "error", err)
+			continue
+		}
+		applyLive(logger, path, next)
+		if changed := restartSettings(cfg, next); len(changed) > 0 {
+			logger.Warnw("config change requires restart, ignored", "path", path, "settings", strings.Join(changed, ","))
+		}
+		cfg = next
+	}
+}
+
+// sameStat reports whether a and b, of the same file, have the same size and
+// modification time.
+func sameStat(a, b os.FileInfo) bool {
+	return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// applyLive applies the Level and Sampling of cfg, loaded from path, if they
+// differ from those of the loggers, reporting to logger.
+func applyLive(logger *zap.SugaredLogger, path string, cfg Config) {
+	cfg.Options = nil
+	o, err := cfg.options()
+	if err != nil {
+		logger.Warnw("can't reload config", "path", path, "error", err)
+		return
+	}
+	if o.level != GetLevel() {
+		SetLevel(o.level)
+		logger.Infow("reloaded config level", "path", path, "level", o.level)
+	}
+	loggers.lock.RLock()
+	sampling := settings.sampling
+	loggers.lock.RUnlock()
+	if sampling != nil && *sampling.get() != *o.samplingParams() {
+		sampling.set(*o.samplingParams())
+		logger.Infow("reloaded config sampling", "path", path)
+	}
+}
+
+// restartSettings returns the names of the settings other than Level and
+// Sampling that differ between a and b.
+func restartSettings(a, b Config) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	typ := va.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if f.PkgPath != "" || name == "-" || f.Name == "Level" || f.Name == "Sampling" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,93 @@
+package zaphelper
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// syncBuffer is a bytes.Buffer safe to read while loggers write to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWatchConfig(t *testing.T) {
+	configPollInterval = 5 * time.Millisecond
+	defer func() { configPollInterval = time.Second }()
+
+	dir := makeTempDir("TestWatchConfig", t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.yaml")
+	write := func(content string, age time.Duration) {
+		isNil(ioutil.WriteFile(path, []byte(content), 0644), t)
+		mtime := time.Now().Add(-age)
+		isNil(os.Chtimes(path, mtime, mtime), t)
+	}
+	write("path: "+dir+"\nlevel: info\n", time.Hour)
+
+	var buf syncBuffer
+	cfg, err := LoadConfig(path)
+	isNil(err, t)
+	cfg.Options = []LoggerOption{WithWriter(&buf)}
+	isNil(InitFromConfig(cfg), t)
+	defer initDefaults(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- WatchConfig(ctx, path) }()
+
+	write("path: "+dir+"\nlevel: debug\n", time.Minute)
+	waitFor(func() bool { return GetLevel() == zapcore.DebugLevel }, t)
+
+	write("path: "+dir+"\nlevel: warn\nencoding: console\nsampling:\n  first: 1\n  thereafter: 100\n", 0)
+	waitFor(func() bool { return GetLevel() == zapcore.WarnLevel }, t)
+	waitFor(func() bool { return strings.Contains(buf.String(), "requires restart") }, t)
+	if !strings.Contains(buf.String(), `"settings":"encoding"`) {
+		t.Fatalf("expected the encoding to be reported: %s", buf.String())
+	}
+	waitFor(func() bool {
+		loggers.lock.RLock()
+		defer loggers.lock.RUnlock()
+		return settings.sampling.get().first == 1
+	}, t)
+	logger := GetLogger("TestWatchConfig")
+	for i := 0; i < 10; i++ {
+		logger.Warn("sampled")
+	}
+	equals(1, strings.Count(buf.String(), `"message":"sampled"`), t)
+
+	cancel()
+	equals(context.Canceled, <-done, t)
+	write("path: "+dir+"\nlevel: error\n", 2*time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	equals(zapcore.WarnLevel, GetLevel(), t)
+}
+
+func TestWatchConfigMissingFile(t *testing.T) {
+	if err := WatchConfig(context.Background(), "/does/not/exist.yaml"); err == nil {
+		t.Fatal("expected an error for a missing config")
+	}
+}
@@ -1,129 +1,1471 @@
 package zaphelper
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.uber.org/multierr"
 )
 
+const (
+	// backupTimeFormat is the layout of the timestamp embedded in the names of
+	// rotated backup files.
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	// compressSuffix is appended to the names of compressed backup files.
+	compressSuffix = ".gz"
+)
+
+// newline is what MaxLines counts.
+var newline = []byte{'\n'}
+
 var (
 	// ensure we always implement io.WriteCloser
 	_ io.WriteCloser = (*Writer)(nil)
-	// osStat exists so it can be mocked out by tests.
-	osStat = os.Stat
+	// and io.StringWriter, which io.WriteString looks for
+	_ interface {
+		WriteString(s string) (int, error)
+	} = (*Writer)(nil)
+	// currentTime exists so it can be mocked out by tests.
+	currentTime = time.Now
+	// closeTimeout bounds how long Close waits for the background goroutines.
+	// It is a variable so tests can mock it out.
+	closeTimeout = 10 * time.Second
+	// freeSpace returns the free bytes on the disk of a directory.  It exists
+	// so it can be mocked out by tests.
+	freeSpace = diskFree
+	// freeSpaceInterval is how often MinFreeBytes is checked.
+	freeSpaceInterval = time.Second
+	// megabyte is the conversion factor between MaxSize and bytes.  It is a
+	// variable so tests can mock it out and not need to write megabytes of data
+	// to disk.
+	megabyte = 1024 * 1024
 )
 
 // Writer is an io.WriteCloser that writes to the specified filename.
 type Writer struct {
+	// stats is accessed atomically and kept first for 64-bit alignment.
+	stats WriterStats
+
 	// Filename is the file to write logs to.  Backup log files will be retained
 	// in the same directory.  It uses <processname>-lumberjack.log in
 	// os.TempDir() if empty.
 	Filename string `json:"filename" yaml:"filename"`
 
-	file *os.File
-	mu   sync.Mutex
+	// MaxSize is the maximum size in megabytes of the log file before it gets
+	// rotated.  It defaults to 0, which means the file is never rotated because
+	// of its size.
+	MaxSize int `json:"maxsize" yaml:"maxsize"`
+
+	// MaxLines is the maximum number of lines of the log file before it gets
+	// rotated, counting the newlines written, so a multi-line entry such as a
+	// stacktrace counts for all its lines.  An entry is never split: one
+	// longer than MaxLines gets a file of its own.  It defaults to 0, which
+	// means the file is never rotated because of its line count.
+	MaxLines int `json:"maxlines" yaml:"maxlines"`
+
+	// MaxBackups is the maximum number of old log files to retain.  The default
+	// is to retain all old log files.
+	MaxBackups int `json:"maxbackups" yaml:"maxbackups"`
+
+	// MaxAge is the maximum number of days to retain old log files based on the
+	// timestamp encoded in their filename.  Note that a day is defined as 24
+	// hours and may not exactly correspond to calendar days due to daylight
+	// savings, leap seconds, etc.  The default is not to remove old log files
+	// based on age.
+	MaxAge int `json:"maxage" yaml:"maxage"`
+
+	// MaxTotalSize is the maximum combined size in bytes of the old log files
+	// to retain.  Once it is exceeded the oldest ones are removed until the
+	// rest fit, on top of what MaxBackups and MaxAge remove.  Sizes are those
+	// on disk, so compressed backups count compressed.  The default of 0
+	// doesn't bound the total size.
+	MaxTotalSize int64 `json:"maxtotalsize" yaml:"maxtotalsize"`
+
+	// Compress determines if the rotated log files should be compressed
+	// using gzip.  The default is not to perform compression.
+	Compress bool `json:"compress" yaml:"compress"`
+
+	// CompressAlgorithm is the algorithm Compress uses: "gzip", the default,
+	// writes .gz backups, and others can be added with RegisterCompressor, such
+	// as "zstd" by importing the zstdcompress subpackage.
+	CompressAlgorithm string `json:"compressalgorithm" yaml:"compressalgorithm"`
+
+	// CompressLevel is the compression level passed to the algorithm, 0
+	// standing for its default.
+	CompressLevel int `json:"compresslevel" yaml:"compresslevel"`
+
+	// CompressConcurrency is the maximum number of backups compressed at the
+	// same time, by the background cleanup or CompressExisting.  It defaults
+	// to 1, so that a backlog of backups doesn't hog the CPU and disks.
+	CompressConcurrency int `json:"compressconcurrency" yaml:"compressconcurrency"`
+
+	// LocalTime determines if the time used for formatting the timestamps in
+	// backup files is the computer's local time.  The default is to use UTC
+	// time.  The same choice is used when parsing the timestamps back for
+	// MaxAge, so the two never disagree.
+	LocalTime bool `json:"localtime" yaml:"localtime"`
+
+	// FileMode is the permission bits used when creating log files.  It
+	// defaults to 0644 if zero.
+	FileMode os.FileMode `json:"filemode" yaml:"filemode"`
+
+	// DirMode is the permission bits used when creating the log directory.  It
+	// defaults to 0744 if zero.
+	DirMode os.FileMode `json:"dirmode" yaml:"dirmode"`
+
+	// ReopenOnMissing makes every write check that the open file is still the
+	// one at Filename, and reopen Filename if it was removed or replaced, e.g.
+	// by an external logrotate.  It costs a stat per write and is off by default.
+	ReopenOnMissing bool `json:"reopenonmissing" yaml:"reopenonmissing"`
+
+	// ExclusiveLock makes the Writer take an advisory lock on the logfile
+	// when opening it (flock on Unix, LockFileEx on Windows), so that a
+	// second process misconfigured with the same Filename gets an error from
+	// Write instead of interleaving its lines.  As the lock on Windows keeps
+	// other handles from reading the file too, Tail and MaxLines then read
+	// through the one written to, which is opened for reading as well.
+	ExclusiveLock bool `json:"exclusivelock" yaml:"exclusivelock"`
+
+	// TruncateOnOpen discards the content of an existing logfile when the
+	// Writer first opens it, e.g. for a test harness wanting a fresh log each
+	// run.  The files opened by later rotations are new anyway.  By default
+	// the Writer appends to an existing logfile.
+	TruncateOnOpen bool `json:"truncateonopen" yaml:"truncateonopen"`
+
+	// OnRotate, if set, is called after each rotation that moved a logfile
+	// aside, with the path of the backup and of the fresh active file.  It is
+	// called without holding the Writer's lock, so it may log through it.
+	OnRotate func(oldPath, newPath string) `json:"-" yaml:"-"`
+
+	// BeforeRotate, if set, is called right before each rotation closes the
+	// logfile, e.g. to snapshot state tied to it.  If it returns an error, the
+	// logfile stays in place and the rotation fails with that error.  It is
+	// called holding the Writer's lock, so it must not log through it.
+	BeforeRotate func() error `json:"-" yaml:"-"`
+
+	// OnRemove, if set, is called with the path of each backup removed by the
+	// cleanup for MaxBackups, MaxAge or MaxTotalSize, once it is gone, e.g. to
+	// keep an audit trail.  It is called from the cleanup goroutine, or from
+	// PruneBackups, without holding the Writer's lock, so it may log through
+	// it.
+	OnRemove func(path string) `json:"-" yaml:"-"`
+
+	// FallbackWriter, if set, receives the bytes that couldn't be written to
+	// the logfile (disk full, permission denied, ...), typically os.Stderr, so
+	// that they aren't lost.  Write then doesn't report an error.  A warning
+	// describing the failure is written to it once each time the Writer starts
+	// falling back.
+	FallbackWriter io.Writer `json:"-" yaml:"-"`
+
+	// BufferSize is the size in bytes of an in-memory buffer in front of the
+	// logfile, trading the risk of losing buffered lines on a crash for fewer
+	// write syscalls.  The buffer is flushed when full, on Sync and Close, and
+	// before the file is rotated.  The default of 0 disables buffering.
+	BufferSize int `json:"buffersize" yaml:"buffersize"`
+
+	// FlushInterval is how often a background goroutine flushes the buffer
+	// when BufferSize is set.  The default of 0 only flushes as described for
+	// BufferSize.
+	FlushInterval time.Duration `json:"flushinterval" yaml:"flushinterval"`
+
+	// SyncOnWrite makes every write wait until its bytes are on disk, by
+	// calling fsync on the logfile after it, e.g. for an audit log that must
+	// survive a power failure.  This costs a lot of throughput, and is off by
+	// default.  With BufferSize set, the buffer is flushed before each sync,
+	// so that it no longer saves any write syscalls.
+	SyncOnWrite bool `json:"synconwrite" yaml:"synconwrite"`
+
+	// MinFreeBytes makes the Writer refuse writes while the disk of the
+	// logfile has less free space than that, checked at most once per second,
+	// so that a full disk doesn't leave a partially written line behind.
+	// Refused writes go to the FallbackWriter if set, and fail otherwise,
+	// until the space is back.  Where the free space can't be determined,
+	// e.g. on platforms other than Linux, macOS, FreeBSD, DragonFly BSD and
+	// Windows, writes are never refused.  The default of 0 disables the check.
+	MinFreeBytes int64 `json:"minfreebytes" yaml:"minfreebytes"`
+
+	// MinRotateInterval is the minimum time between two rotations triggered
+	// by MaxSize or MaxLines.  Within that window the logfile keeps growing
+	// past them instead, so a burst of logs doesn't produce lots of tiny
+	// backups with colliding timestamps; the price is that they are only soft
+	// limits.
+	// Rotate is never suppressed.  The default of 0 disables the guard.
+	MinRotateInterval time.Duration `json:"minrotateinterval" yaml:"minrotateinterval"`
+
+	// RotationInterval makes the logfile rotate on the first write after the
+	// wall clock crosses a multiple of the interval, independently of MaxSize:
+	// 24 * time.Hour rotates daily at midnight, time.Hour at the top of every
+	// hour.  Boundaries are aligned in the time zone chosen by LocalTime, and
+	// intervals that don't divide a day evenly drift from midnight.  The
+	// default of 0 disables time-based rotation.
+	RotationInterval time.Duration `json:"rotationinterval" yaml:"rotationinterval"`
+
+	// LinkName, if set, is the path of a symlink kept pointing at the active
+	// logfile, replaced atomically each time a logfile is opened, for log
+	// shippers tailing a fixed path.  Failing to maintain it doesn't fail
+	// writes; notably it isn't maintained on Windows without the privilege to
+	// create symlinks.
+	LinkName string `json:"linkname" yaml:"linkname"`
+
+	// SubdirLayout, if set, is a time layout such as "2006/01/02" naming the
+	// subdirectory of the logfile's directory each backup is moved into, by
+	// the time of the rotation, so that backups are grouped by date.
+	// Cleanup then looks for backups in all subdirectories, and removes the
+	// ones it empties.
+	SubdirLayout string `json:"subdirlayout" yaml:"subdirlayout"`
+
+	// BackupPattern is a text/template naming the backups relative to the
+	// logfile's directory, from the placeholders {{.Name}}, the logfile name
+	// without its extension, {{.Ext}}, its extension including the dot, and
+	// {{.Time}}, the time of the rotation.  {{.Time}} takes an optional
+	// time.Format layout, and must appear exactly once so that cleanup can
+	// parse the timestamps back: "{{.Name}}{{.Ext}}.{{.Time \"20060102-150405\"}}"
+	// names backups like app.log.20240102-150405, and
+	// "{{.Name}}/{{.Time \"20060102/150405\"}}{{.Ext}}" like
+	// app/20240102/150405.log.  A bad pattern makes opening the logfile fail.
+	// It defaults to "{{.Name}}-{{.Time}}{{.Ext}}", naming backups like
+	// app-2024-01-02T15-04-05.000.log.
+	BackupPattern string `json:"backuppattern" yaml:"backuppattern"`
+
+	// fs is where the logfiles live, the disk unless mocked out by tests.
+	fs    fileSystem
+	size  int64
+	lines int
+	file  file
+	buf   *bufio.Writer
+	mu    sync.Mutex
+
+	rotated    []rotation
+	fellBack   bool
+	opened     bool
+	lastRotate time.Time
+	// lowSpace is whether there were only freeBytes free on the disk at
+	// spaceChecked.
+	lowSpace     bool
+	freeBytes    uint64
+	spaceChecked time.Time
+	// period is the start of the RotationInterval the logfile belongs to.
+	period time.Time
+
+	// millCh signals the mill goroutine, running if not nil, which closes
+	// millDone when it exits.
+	millCh   chan bool
+	millDone chan struct{}
+	// millMu serializes millRunOnce between the mill goroutine and
+	// PruneBackups.
+	millMu sync.Mutex
+
+	flushStop chan struct{}
+	flushDone chan struct{}
+}
+
+// WriterStats are the counters of a Writer since it was created.
+type WriterStats struct {
+	// Rotations is the number of rotations performed.
+	Rotations uint64 `json:"rotations"`
+	// BytesWritten is the number of bytes written to logfiles.
+	BytesWritten uint64 `json:"bytes_written"`
+	// WriteErrors is the number of writes that failed, whether or not they
+	// were then written to the FallbackWriter.
+	WriteErrors uint64 `json:"write_errors"`
+	// Dropped is the number of writes an AsyncWriter dropped because its queue
+	// was full.  It is always 0 for a Writer.
+	Dropped uint64 `json:"dropped"`
+}
+
+// BackupInfo describes an old log file left behind by rotation.
+type BackupInfo struct {
+	// Path is the path of the file.
+	Path string `json:"path"`
+	// Timestamp is the rotation time encoded in the file name.
+	Timestamp time.Time `json:"timestamp"`
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+	// Compressed is set if the file is compressed.
+	Compressed bool `json:"compressed"`
 }
 
-// Write implements io.Writer.
+// rotation records a completed rotation for OnRotate.
+type rotation struct {
+	oldPath, newPath string
+}
+
+// Write implements io.Writer.  If the logfile can't be opened or written and
+// a FallbackWriter is set, the bytes go to the FallbackWriter instead.
 func (w *Writer) Write(p []byte) (n int, err error) {
+	defer w.runRotateHooks()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err = w.write(p)
+	w.count(n, err)
+	if err != nil && w.FallbackWriter != nil {
+		return w.fallback(p, n, err)
+	}
+	w.fellBack = false
+	return n, err
+}
+
+// WriteString implements io.StringWriter, writing s like Write does without
+// converting it to a byte slice first, unless it has to go to the
+// FallbackWriter.
+func (w *Writer) WriteString(s string) (n int, err error) {
+	defer w.runRotateHooks()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	n, err = w.writeString(s)
+	w.count(n, err)
+	if err != nil && w.FallbackWriter != nil {
+		return w.fallback([]byte(s), n, err)
+	}
+	w.fellBack = false
+	return n, err
+}
+
+// count records a write of n bytes that failed with err, if not nil.
+func (w *Writer) count(n int, err error) {
+	atomic.AddUint64(&w.stats.BytesWritten, uint64(n))
+	if err != nil {
+		atomic.AddUint64(&w.stats.WriteErrors, 1)
+	}
+}
+
+// write writes p to the logfile, opening or rotating it as needed.
+func (w *Writer) write(p []byte) (n int, err error) {
+	var lines int
+	if w.MaxLines > 0 {
+		lines = bytes.Count(p, newline)
+	}
+	if err = w.prepare(int64(len(p)), lines); err != nil {
+		return 0, err
+	}
+
+	if w.buf != nil {
+		n, err = w.buf.Write(p)
+	} else {
+		n, err = w.file.Write(p)
+	}
+	w.size += int64(n)
+	if w.MaxLines > 0 {
+		w.lines += bytes.Count(p[:n], newline)
+	}
+	if err == nil {
+		err = w.syncWrite()
+	}
+
+	return n, err
+}
+
+// writeString is write for a string.
+func (w *Writer) writeString(s string) (n int, err error) {
+	var lines int
+	if w.MaxLines > 0 {
+		lines = strings.Count(s, "\n")
+	}
+	if err = w.prepare(int64(len(s)), lines); err != nil {
+		return 0, err
+	}
+
+	if w.buf != nil {
+		n, err = w.buf.WriteString(s)
+	} else {
+		n, err = io.WriteString(w.file, s)
+	}
+	w.size += int64(n)
+	if w.MaxLines > 0 {
+		w.lines += strings.Count(s[:n], "\n")
+	}
+	if err == nil {
+		err = w.syncWrite()
+	}
+
+	return n, err
+}
+
+// syncWrite flushes the buffer and syncs the logfile after a write if
+// SyncOnWrite is set.
+func (w *Writer) syncWrite() error {
+	if !w.SyncOnWrite {
+		return nil
+	}
+	if w.buf != nil {
+		if err := w.buf.Flush(); err != nil {
+			return errors.Wrap(err, "flush buffer failed.")
+		}
+	}
+	if err := w.file.Sync(); err != nil {
+		return errors.Wrap(err, "sync failed.")
+	}
+	return nil
+}
+
+// prepare opens or rotates the logfile as needed for a write of writeLen
+// bytes holding the given number of lines.
+func (w *Writer) prepare(writeLen int64, lines int) error {
+	if w.MaxSize > 0 && writeLen > w.max() {
+		return errors.Errorf(
+			"write length %d exceeds maximum file size %d", writeLen, w.max(),
+		)
+	}
+
+	if err := w.checkSpace(); err != nil {
+		return err
+	}
+
+	if w.file != nil && w.ReopenOnMissing {
+		if err := w.reopenIfMoved(); err != nil {
+			return err
+		}
+	}
+
 	if w.file == nil {
-		if err = w.openExistingOrNew(len(p)); err != nil {
-			return 0, err
+		if err := w.openExistingOrNew(int(writeLen)); err != nil {
+			return err
 		}
 	}
 
-	n, err = w.file.Write(p)
+	tooBig := w.MaxSize > 0 && w.size+writeLen > w.max()
+	tooLong := w.MaxLines > 0 && w.lines > 0 && w.lines+lines > w.MaxLines
+	if ((tooBig || tooLong) && w.mayRotate()) || w.periodOver() {
+		return w.rotate()
+	}
+	return nil
+}
 
-	return n, err
+// checkSpace fails if MinFreeBytes is set and the disk of the logfile had less
+// free space than that when last checked, checking again if freeSpaceInterval
+// has passed since.
+func (w *Writer) checkSpace() error {
+	if w.MinFreeBytes <= 0 {
+		return nil
+	}
+	if now := currentTime(); w.spaceChecked.IsZero() || now.Sub(w.spaceChecked) >= freeSpaceInterval {
+		w.spaceChecked = now
+		free, err := freeSpace(w.dir())
+		// writes aren't refused on a hunch
+		w.lowSpace = err == nil && free < uint64(w.MinFreeBytes)
+		w.freeBytes = free
+	}
+	if w.lowSpace {
+		return errors.Errorf("only %d bytes free on the disk of %s, less than MinFreeBytes", w.freeBytes, w.filename())
+	}
+	return nil
+}
+
+// fallback writes what's left of p after the first n bytes to the
+// FallbackWriter, preceded by a warning describing the cause the first time the
+// Writer falls back after a successful write.
+func (w *Writer) fallback(p []byte, n int, cause error) (int, error) {
+	if !w.fellBack {
+		w.fellBack = true
+		fmt.Fprintf(w.FallbackWriter, "zaphelper: can't write to %s, falling back: %v\n", w.filename(), cause)
+	}
+	m, err := w.FallbackWriter.Write(p[n:])
+	return n + m, err
 }
 
-// Close implements io.Closer, and closes the current logfile.
+// Close implements io.Closer, and closes the current logfile after flushing
+// any buffered data.  The background flush and cleanup goroutines, if any,
+// are stopped, and the cleanup and compression of old log files in progress,
+// if any, is waited for, for up to ten seconds.  The logfile is closed even if
+// they don't stop in time, and the Writer may still be written to afterwards,
+// reopening the logfile and restarting them as needed.
 func (w *Writer) Close() error {
+	w.mu.Lock()
+	flushStop, flushDone := w.flushStop, w.flushDone
+	w.flushStop, w.flushDone = nil, nil
+	millCh, millDone := w.millCh, w.millDone
+	w.millCh, w.millDone = nil, nil
+	w.mu.Unlock()
+
+	if flushStop != nil {
+		close(flushStop)
+	}
+	if millCh != nil {
+		close(millCh)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if flushDone != nil {
+			<-flushDone
+		}
+		if millDone != nil {
+			<-millDone
+		}
+		// and for PruneBackups or CompressExisting
+		w.millMu.Lock()
+		w.millMu.Unlock()
+	}()
+	timer := time.NewTimer(closeTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-stopped:
+	case <-timer.C:
+		err = errors.Errorf("timed out after %v waiting for the background goroutines", closeTimeout)
+	}
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.close()
+	return multierr.Append(err, w.close())
 }
 
-// close closes the file if it is open.
+// Open opens the logfile if it isn't open yet, so that errors such as a bad
+// path or missing permissions surface right away rather than on the first
+// write, which otherwise opens it lazily.
+func (w *Writer) Open() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		return nil
+	}
+	return w.openExistingOrNew(0)
+}
+
+// Size returns the size in bytes of the current logfile, as tracked by the
+// Writer rather than read from disk.  It returns 0 if no file is open.
+func (w *Writer) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return 0
+	}
+	return w.size
+}
+
+// CurrentFilename returns the name of the logfile, as last set by
+// SetFilename.  It is safe to call concurrently with SetFilename, unlike
+// reading Filename.
+func (w *Writer) CurrentFilename() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.filename()
+}
+
+// Stats returns the current counters of the Writer.  It is safe to call
+// concurrently with Write.
+func (w *Writer) Stats() WriterStats {
+	return WriterStats{
+		Rotations:    atomic.LoadUint64(&w.stats.Rotations),
+		BytesWritten: atomic.LoadUint64(&w.stats.BytesWritten),
+		WriteErrors:  atomic.LoadUint64(&w.stats.WriteErrors),
+	}
+}
+
+// Backups returns the old log files found next to the logfile, newest first,
+// as MaxBackups, MaxAge and MaxTotalSize see them.
+func (w *Writer) Backups() ([]BackupInfo, error) {
+	w.mu.Lock()
+	filename := w.filename()
+	w.mu.Unlock()
+
+	files, err := w.oldLogFiles(filename)
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]BackupInfo, 0, len(files))
+	for _, f := range files {
+		backups = append(backups, BackupInfo{
+			Path:       f.path,
+			Timestamp:  f.timestamp,
+			Size:       f.Size(),
+			Compressed: isCompressed(f.Name()),
+		})
+	}
+	return backups, nil
+}
+
+// Tail returns about the last n bytes of the logfile, for showing the recent
+// entries: the first line is dropped if cut, unless it is the only one.  The
+// buffer is flushed first, and writes wait while the file is read through a
+// descriptor of its own, which leaves the one written to alone, or through the
+// one written to if ExclusiveLock is set.  It returns nothing if the logfile
+// doesn't exist yet.
+func (w *Writer) Tail(n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buf != nil {
+		if err := w.buf.Flush(); err != nil {
+			return nil, errors.Wrap(err, "flush buffer failed.")
+		}
+	}
+	var b []byte
+	if ra, ok := w.lockedFile(); ok {
+		info, err := w.file.Stat()
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting log file info")
+		}
+		start := tailStart(info.Size(), n)
+		b = make([]byte, info.Size()-start)
+		if _, err := ra.ReadAt(b, start); err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "can't read logfile")
+		}
+	} else {
+		f, err := w.fsys().Open(w.filename())
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "can't open logfile")
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting log file info")
+		}
+		start := tailStart(info.Size(), n)
+		if s, ok := f.(io.Seeker); ok {
+			_, err = s.Seek(start, io.SeekStart)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, f, start)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "can't read logfile")
+		}
+		b = make([]byte, info.Size()-start)
+		if _, err := io.ReadFull(f, b); err != nil {
+			return nil, errors.Wrap(err, "can't read logfile")
+		}
+	}
+	if int64(len(b)) > int64(n) {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 && i < len(b)-1 {
+			b = b[i+1:]
+		} else {
+			b = b[1:]
+		}
+	}
+	return b, nil
+}
+
+// tailStart returns where to read the last n bytes of a file of size from,
+// starting a byte early to tell whether the first line is cut.
+func tailStart(size int64, n int) int64 {
+	start := size - int64(n) - 1
+	if start < 0 {
+		return 0
+	}
+	return start
+}
+
+// Sync commits the current contents of the logfile to stable storage.  It
+// satisfies zapcore.WriteSyncer, so logger.Sync() flushes the file.  It is a
+// no-op if no file is open.
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	if w.buf != nil {
+		if err := w.buf.Flush(); err != nil {
+			return errors.Wrap(err, "flush buffer failed.")
+		}
+	}
+	return w.file.Sync()
+}
+
+// close flushes the buffer and closes the file if it is open.
 func (w *Writer) close() error {
 	if w.file == nil {
 		return nil
 	}
+	var flushErr error
+	if w.buf != nil {
+		flushErr = w.buf.Flush()
+		w.buf = nil
+	}
 	err := w.file.Close()
 	w.file = nil
+	if flushErr != nil {
+		return errors.Wrap(flushErr, "flush buffer failed.")
+	}
 	return err
 }
 
+// setFile makes f the current logfile, wrapping it in a buffer and starting
+// the background flush goroutine if buffering is enabled.  Every path opening
+// a logfile closes the current one first; should one not, the current file is
+// closed here rather than leaked.
+func (w *Writer) setFile(f file) {
+	if w.file != nil {
+		// f replaces the current file whether or not it closes cleanly
+		_ = w.close()
+	}
+	w.file = f
+	if w.BufferSize <= 0 {
+		return
+	}
+	w.buf = bufio.NewWriterSize(f, w.BufferSize)
+	if w.FlushInterval > 0 && w.flushStop == nil {
+		w.flushStop = make(chan struct{})
+		w.flushDone = make(chan struct{})
+		go w.flushRun(w.FlushInterval, w.flushStop, w.flushDone)
+	}
+}
+
+// flushRun runs in a goroutine to flush the buffer every interval, until stop
+// is closed.
+func (w *Writer) flushRun(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.buf != nil {
+				// the buffer keeps the error for the next Write or Sync
+				_ = w.buf.Flush()
+			}
+			w.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
 // Rotate causes Logger to close the existing log file and immediately create a
 // new one.  This is a helper function for applications that want to initiate
 // rotations outside of the normal rotation rules, such as in response to
 // SIGHUP.
 func (w *Writer) Rotate() error {
+	defer w.runRotateHooks()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	return w.rotate()
 }
 
+// SetFilename changes the file the Writer logs to.  The current file is
+// closed, and name is opened lazily on the next write.  It is safe to call
+// concurrently with Write.  Any error closing the current file is returned.
+func (w *Writer) SetFilename(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.close()
+	w.Filename = name
+	return err
+}
+
+// Reopen closes the current logfile and opens Filename again, creating it if
+// necessary.  Unlike Rotate it never moves anything aside: it is meant for
+// external rotation tools (logrotate with copytruncate, or a rename followed
+// by SIGUSR1) which have already dealt with the old file and just need the
+// Writer to let go of the old descriptor.
+func (w *Writer) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reopen()
+}
+
+// reopen closes the current file and opens Filename in append mode.
+func (w *Writer) reopen() error {
+	if err := w.close(); err != nil {
+		return errors.Wrap(err, "close old file failed.")
+	}
+	if err := w.openNew(); err != nil {
+		return errors.Wrap(err, "open file failed.")
+	}
+	if info, err := w.file.Stat(); err == nil {
+		w.size = info.Size()
+	}
+	w.lines = w.countLines()
+	return nil
+}
+
 // rotate closes the current file, moves it aside with a timestamp in the name,
 // (if it exists), opens a new file with the original filename, and then runs
 // post-rotation processing and removal.
 func (w *Writer) rotate() error {
+	if w.BeforeRotate != nil {
+		if err := w.BeforeRotate(); err != nil {
+			return errors.Wrap(err, "before rotate hook failed.")
+		}
+	}
 	if err := w.close(); err != nil {
 		return errors.Wrap(err, "close old file failed.")
 	}
+	backup, err := w.backup()
+	if err != nil {
+		return errors.Wrap(err, "move old file failed.")
+	}
 	if err := w.openNew(); err != nil {
 		return errors.Wrap(err, "open new file failed.")
 	}
+	w.lastRotate = currentTime()
+	atomic.AddUint64(&w.stats.Rotations, 1)
+	if backup != "" && w.OnRotate != nil {
+		w.rotated = append(w.rotated, rotation{backup, w.filename()})
+	}
+	w.mill()
 	return nil
 }
 
+// mayRotate reports whether MinRotateInterval allows a size-based rotation.
+func (w *Writer) mayRotate() bool {
+	if w.MinRotateInterval <= 0 || w.lastRotate.IsZero() {
+		return true
+	}
+	return currentTime().Sub(w.lastRotate) >= w.MinRotateInterval
+}
+
+// periodOver reports whether the RotationInterval the logfile belongs to has
+// ended.
+func (w *Writer) periodOver() bool {
+	return w.RotationInterval > 0 && w.periodStart(currentTime()).After(w.period)
+}
+
+// periodStart returns the start of the RotationInterval t falls in, aligned in
+// the Writer's time zone.
+func (w *Writer) periodStart(t time.Time) time.Time {
+	if w.RotationInterval <= 0 {
+		return time.Time{}
+	}
+	t = t.In(w.location())
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(w.RotationInterval).Add(-shift)
+}
+
+// backup moves the current logfile aside with a timestamp in its name, if the
+// logfile exists, and returns the name it was moved to.
+func (w *Writer) backup() (string, error) {
+	name := w.filename()
+	_, err := w.fsys().Stat(name)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "can't get log file info")
+	}
+	dest := name
+	if w.SubdirLayout != "" {
+		sub := filepath.Join(filepath.Dir(name), currentTime().In(w.location()).Format(w.SubdirLayout))
+		if err := w.fsys().MkdirAll(sub, w.dirMode()); err != nil {
+			return "", errors.Wrap(err, "can't make backup directory")
+		}
+		dest = filepath.Join(sub, filepath.Base(name))
+	}
+	pattern, err := parseBackupPattern(w.BackupPattern)
+	if err != nil {
+		return "", err
+	}
+	newname, err := backupName(w.fsys(), dest, pattern, w.location())
+	if err != nil {
+		return "", err
+	}
+	if dir := filepath.Dir(newname); dir != filepath.Dir(dest) {
+		if err := w.fsys().MkdirAll(dir, w.dirMode()); err != nil {
+			return "", errors.Wrap(err, "can't make backup directory")
+		}
+	}
+	if err := moveFile(w.fsys(), name, newname); err != nil {
+		return "", errors.Wrap(err, "can't rename log file")
+	}
+	return newname, nil
+}
+
+// moveFile renames src to dst, falling back to copying and removing src when
+// they are on different filesystems, e.g. a SubdirLayout directory mounted
+// elsewhere.
+func moveFile(fs fileSystem, src, dst string) error {
+	err := fs.Rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	if err := copyFile(fs, src, dst); err != nil {
+		fs.Remove(dst)
+		return err
+	}
+	return fs.Remove(src)
+}
+
+// copyFile copies src to dst with the same mode, syncing dst before returning
+// so that src may be removed safely.
+func copyFile(fs fileSystem, src, dst string) error {
+	f, err := fs.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	defer f.Close()
+	fi, err := fs.Stat(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat log file")
+	}
+	out, err := fs.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		return errors.Wrap(err, "failed to open backup file")
+	}
+	if _, err := io.Copy(out, f); err != nil {
+		out.Close()
+		return errors.Wrap(err, "failed to copy log file")
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return errors.Wrap(err, "failed to sync backup file")
+	}
+	return out.Close()
+}
+
+// runRotateHooks calls OnRotate for the rotations performed since it last ran.
+// It must be called without holding the mutex, so that OnRotate may log.
+func (w *Writer) runRotateHooks() {
+	w.mu.Lock()
+	rotated := w.rotated
+	w.rotated = nil
+	w.mu.Unlock()
+	for _, r := range rotated {
+		w.OnRotate(r.oldPath, r.newPath)
+	}
+}
+
+// backupName creates a new filename from the given name following pattern,
+// with a timestamp formatted in loc.  If a file with that name already exists,
+// compressed or not, a numeric suffix is appended to the timestamp to keep it
+// unique.
+func backupName(fs fileSystem, name string, pattern *backupPattern, loc *time.Location) (string, error) {
+	dir := filepath.Dir(name)
+	before, layout, after, err := pattern.split(filepath.Base(name))
+	if err != nil {
+		return "", err
+	}
+	stamp := before + currentTime().In(loc).Format(layout)
+
+	suffixes := compressSuffixes()
+	taken := func(candidate string) bool {
+		if _, err := fs.Stat(candidate); err == nil {
+			return true
+		}
+		// a compressed backup would keep the new one from being compressed
+		for _, suffix := range suffixes {
+			if _, err := fs.Stat(candidate + suffix); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+	candidate := filepath.Join(dir, filepath.FromSlash(stamp+after))
+	for i := 1; taken(candidate); i++ {
+		candidate = filepath.Join(dir, filepath.FromSlash(fmt.Sprintf("%s.%d%s", stamp, i, after)))
+	}
+	return candidate, nil
+}
+
 // openNew opens a new log file for writing.
 func (w *Writer) openNew() error {
-	err := os.MkdirAll(w.dir(), 0744)
+	err := w.fsys().MkdirAll(w.dir(), w.dirMode())
 	if err != nil {
+		if dirErr := checkDir(w.fsys(), w.dir()); dirErr != nil {
+			return dirErr
+		}
 		return errors.Wrap(err, "can't make directories for new logfile")
 	}
 
 	name := w.filename()
-	mode := os.FileMode(0644)
-
-	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	f, err := w.fsys().OpenFile(name, os.O_CREATE|w.accessMode()|os.O_APPEND, w.fileMode())
 	if err != nil {
 		return errors.Wrap(err, "can't open new logfile")
 	}
-	w.file = f
+	if err := w.lock(f); err != nil {
+		return err
+	}
+	// truncate only once locked, not to wipe the file of another process; as
+	// the file is in append mode, writes go to the new end without seeking
+	if w.TruncateOnOpen && !w.opened {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return errors.Wrap(err, "can't truncate logfile")
+		}
+	}
+	w.setFile(f)
+	w.size = 0
+	w.lines = 0
+	w.period = w.periodStart(currentTime())
+	w.onOpen()
+	return nil
+}
+
+// checkDir returns a clear error if dir or one of its parents exists but is no
+// directory, which MkdirAll only reports as "not a directory".
+func checkDir(fs fileSystem, dir string) error {
+	for p := dir; ; p = filepath.Dir(p) {
+		if info, err := fs.Stat(p); err == nil {
+			if !info.IsDir() {
+				return errors.Errorf("log directory path %q is a file", p)
+			}
+			return nil
+		}
+		if filepath.Dir(p) == p {
+			return nil
+		}
+	}
+}
+
+// accessMode is the mode logfiles are opened in: for reading too if
+// ExclusiveLock is set, see lockedFile, and write-only otherwise.
+func (w *Writer) accessMode() int {
+	if w.ExclusiveLock {
+		return os.O_RDWR
+	}
+	return os.O_WRONLY
+}
+
+// lockedFile returns the open logfile, for reading, if ExclusiveLock is set:
+// on Windows the lock keeps any other handle from reading it.
+func (w *Writer) lockedFile() (io.ReaderAt, bool) {
+	if !w.ExclusiveLock || w.file == nil {
+		return nil, false
+	}
+	ra, ok := w.file.(io.ReaderAt)
+	return ra, ok
+}
+
+// lock takes the exclusive lock on f if ExclusiveLock is set, closing f if it
+// can't.  The lock is released when f is closed.
+func (w *Writer) lock(f file) error {
+	if !w.ExclusiveLock {
+		return nil
+	}
+	of, ok := f.(*os.File)
+	if !ok {
+		f.Close()
+		return errors.Errorf("can't lock %s, not on disk", f.Name())
+	}
+	if err := lockFile(of); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "can't lock %s, is another process writing to it?", f.Name())
+	}
+	return nil
+}
+
+// countLines returns the number of lines in the logfile if MaxLines is set,
+// and 0 otherwise or if the file can't be read.
+func (w *Writer) countLines() int {
+	if w.MaxLines <= 0 {
+		return 0
+	}
+	var f io.Reader
+	if ra, ok := w.lockedFile(); ok {
+		info, err := w.file.Stat()
+		if err != nil {
+			return 0
+		}
+		f = io.NewSectionReader(ra, 0, info.Size())
+	} else {
+		of, err := w.fsys().Open(w.filename())
+		if err != nil {
+			return 0
+		}
+		defer of.Close()
+		f = of
+	}
+	lines := 0
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.Read(buf)
+		lines += bytes.Count(buf[:n], newline)
+		if err != nil {
+			return lines
+		}
+	}
+}
+
+// onOpen is called when a logfile has been opened, to start the cleanup of
+// the backups left by previous runs the first time if Compress is set.
+func (w *Writer) onOpen() {
+	if !w.opened && w.Compress {
+		w.mill()
+	}
+	w.opened = true
+	// LinkName is best-effort, failing it doesn't fail writes
+	_ = w.link()
+}
+
+// link points LinkName at the logfile, if set, by renaming a fresh symlink
+// over it.  The target is relative if both live in the same directory.
+func (w *Writer) link() error {
+	if w.LinkName == "" {
+		return nil
+	}
+	target := w.filename()
+	if filepath.Dir(target) == filepath.Dir(w.LinkName) {
+		target = filepath.Base(target)
+	} else if abs, err := filepath.Abs(target); err == nil {
+		target = abs
+	}
+	tmp := w.LinkName + ".tmp"
+	fs := w.fsys()
+	fs.Remove(tmp)
+	if err := fs.Symlink(target, tmp); err != nil {
+		return errors.Wrap(err, "can't create symlink")
+	}
+	if err := fs.Rename(tmp, w.LinkName); err != nil {
+		fs.Remove(tmp)
+		return errors.Wrap(err, "can't rename symlink")
+	}
 	return nil
 }
 
+// reopenIfMoved closes the current file if Filename no longer refers to it, so
+// that the next write opens Filename again.
+func (w *Writer) reopenIfMoved() error {
+	info, err := w.fsys().Stat(w.filename())
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "error getting log file info")
+	}
+	if err == nil {
+		current, err := w.file.Stat()
+		if err != nil {
+			return errors.Wrap(err, "error getting open file info")
+		}
+		if os.SameFile(info, current) {
+			return nil
+		}
+	}
+	return w.close()
+}
+
 // openExistingOrNew opens the logfile if it exists and if the current write
 // would not put it over MaxSize.  If there is no such file or the write would
 // put it over the MaxSize, a new file is created.
 func (w *Writer) openExistingOrNew(writeLen int) error {
+	if _, err := parseBackupPattern(w.BackupPattern); err != nil {
+		return err
+	}
 	filename := w.filename()
-	_, err := osStat(filename)
+	info, err := w.fsys().Stat(filename)
 	if os.IsNotExist(err) {
 		return w.openNew()
 	}
 	if err != nil {
+		if dirErr := checkDir(w.fsys(), w.dir()); dirErr != nil {
+			return dirErr
+		}
 		return errors.Wrap(err, "error getting log file info")
 	}
+	if w.TruncateOnOpen && !w.opened {
+		return w.openNew()
+	}
+	if w.MaxSize > 0 && info.Size()+int64(writeLen) > w.max() && w.mayRotate() {
+		return w.rotate()
+	}
 
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	file, err := w.fsys().OpenFile(filename, os.O_APPEND|w.accessMode(), w.fileMode())
 	if err != nil {
 		// if we fail to open the old log file for some reason, just ignore
 		// it and open a new log file.
 		return w.openNew()
 	}
-	w.file = file
+	if err := w.lock(file); err != nil {
+		return err
+	}
+	w.setFile(file)
+	w.size = info.Size()
+	w.lines = w.countLines()
+	w.period = w.periodStart(info.ModTime())
+	w.onOpen()
+	return nil
+}
+
+// millRunOnce performs compression and removal of stale log files.  Old log
+// files beyond MaxBackups are removed, as are those older than MaxAge and
+// those that don't fit in MaxTotalSize; a file is removed if it violates any
+// rule.  The remaining uncompressed backups
+// are compressed if Compress is set.
+func (w *Writer) millRunOnce() error {
+	if w.MaxBackups == 0 && w.MaxAge == 0 && w.MaxTotalSize == 0 && !w.Compress {
+		return nil
+	}
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+
+	// Filename may be changed by SetFilename while the mill runs.
+	w.mu.Lock()
+	filename := w.filename()
+	w.mu.Unlock()
+	dir := filepath.Dir(filename)
+
+	files, err := w.oldLogFiles(filename)
+	if err != nil {
+		return err
+	}
+
+	var cutoff time.Time
+	if w.MaxAge > 0 {
+		cutoff = currentTime().Add(-time.Duration(int64(24*time.Hour) * int64(w.MaxAge)))
+	}
+
+	var codec compression
+	if w.Compress {
+		var ok bool
+		if codec, ok = lookupCompression(w.CompressAlgorithm); !ok {
+			return errors.Errorf("can't mill old log files: unknown compression algorithm %q", w.CompressAlgorithm)
+		}
+	}
+
+	var errs []string
+	var kept []logInfo
+	// a backup that is both present uncompressed and compressed (e.g. after
+	// a crash mid-compression) only counts once against MaxBackups,
+	// nor against MaxTotalSize, where only the compressed copy counts.
+	seen := make(map[string]bool)
+	compressed := make(map[string]bool)
+	for _, f := range files {
+		if base, ok := trimCompressSuffix(f.path); ok {
+			compressed[base] = true
+		}
+	}
+	var total int64
+	for _, f := range files {
+		base, _ := trimCompressSuffix(f.path)
+		seen[base] = true
+		tooMany := w.MaxBackups > 0 && len(seen) > w.MaxBackups
+		tooOld := w.MaxAge > 0 && f.timestamp.Before(cutoff)
+		// once a file doesn't fit, neither does any older one
+		if !compressed[f.path] {
+			total += f.Size()
+		}
+		tooBig := w.MaxTotalSize > 0 && total > w.MaxTotalSize
+		if !tooMany && !tooOld && !tooBig {
+			kept = append(kept, f)
+			continue
+		}
+		errRemove := w.fsys().Remove(f.path)
+		if errRemove != nil && !os.IsNotExist(errRemove) {
+			errs = append(errs, errRemove.Error())
+		}
+		if errRemove == nil && w.OnRemove != nil {
+			w.OnRemove(f.path)
+		}
+		removeEmptyDirs(w.fsys(), filepath.Dir(f.path), dir)
+	}
+
+	if w.Compress {
+		errs = append(errs, w.compress(kept, codec)...)
+	}
+
+	if len(errs) > 0 {
+		return errors.New("can't mill old log files: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// compress compresses the uncompressed files with codec, except those that
+// have a compressed sibling already, and returns the errors met.
+func (w *Writer) compress(files []logInfo, codec compression) []string {
+	compressed := make(map[string]bool)
+	for _, f := range files {
+		if base, ok := trimCompressSuffix(f.path); ok {
+			compressed[base] = true
+		}
+	}
+	jobs := w.CompressConcurrency
+	if jobs < 1 {
+		jobs = 1
+	}
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []string
+	for _, f := range files {
+		if isCompressed(f.Name()) || compressed[f.path] {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(f logInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errCompress := compressLogFile(w.fsys(), f.path, f.path+codec.suffix, codec.compress, w.CompressLevel)
+			if errCompress != nil {
+				mu.Lock()
+				errs = append(errs, errCompress.Error())
+				mu.Unlock()
+			}
+		}(f)
+	}
+	wg.Wait()
+	return errs
+}
+
+// CompressExisting compresses the backups that aren't compressed yet, with
+// CompressAlgorithm, whether or not Compress is set, e.g. those left behind
+// before Compress was enabled.  Backups with a compressed sibling, left by an
+// interrupted compression, are skipped, so it is idempotent.  The same is done
+// in the background when the logfile is first opened with Compress set.
+func (w *Writer) CompressExisting() error {
+	codec, ok := lookupCompression(w.CompressAlgorithm)
+	if !ok {
+		return errors.Errorf("unknown compression algorithm %q", w.CompressAlgorithm)
+	}
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+
+	w.mu.Lock()
+	filename := w.filename()
+	w.mu.Unlock()
+	files, err := w.oldLogFiles(filename)
+	if err != nil {
+		return err
+	}
+	if errs := w.compress(files, codec); len(errs) > 0 {
+		return errors.New("can't compress old log files: " + strings.Join(errs, "; "))
+	}
 	return nil
 }
 
+// PruneBackups removes and compresses old log files according to MaxBackups,
+// MaxAge, MaxTotalSize and Compress right away, as is otherwise done in the
+// background after each rotation, e.g. after lowering MaxBackups.  It is safe
+// to call concurrently with Write.
+func (w *Writer) PruneBackups() error {
+	return w.millRunOnce()
+}
+
+// millRun runs in a goroutine to manage post-rotation compression and removal
+// of old log files, until ch is closed.
+func (w *Writer) millRun(ch <-chan bool, done chan<- struct{}) {
+	defer close(done)
+	for range ch {
+		// the next rotation retries; PruneBackups reports the errors
+		_ = w.millRunOnce()
+	}
+}
+
+// mill performs post-rotation compression and removal of stale log files, starting the mill
+// goroutine if necessary.  It never blocks the caller, and must be called with
+// the mutex held.
+func (w *Writer) mill() {
+	if w.millCh == nil {
+		w.millCh = make(chan bool, 1)
+		w.millDone = make(chan struct{})
+		go w.millRun(w.millCh, w.millDone)
+	}
+	select {
+	case w.millCh <- true:
+	default:
+	}
+}
+
+// oldLogFiles returns the list of backup log files stored in the same
+// directory as filename, sorted by the timestamp in their names, newest first.
+// Files that don't match the backup naming scheme are ignored.
+func (w *Writer) oldLogFiles(filename string) ([]logInfo, error) {
+	pattern, err := parseBackupPattern(w.BackupPattern)
+	if err != nil {
+		return nil, err
+	}
+	before, layout, after, err := pattern.split(filepath.Base(filename))
+	if err != nil {
+		return nil, err
+	}
+	depth := strings.Count(before+layout+after, "/")
+	files, err := w.listFiles(filepath.Dir(filename), w.SubdirLayout != "" || depth > 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read log file directory")
+	}
+	logFiles := []logInfo{}
+
+	loc := w.location()
+
+	for _, f := range files {
+		name, _ := trimCompressSuffix(trailingPath(f.path, depth))
+		if t, seq, err := timeFromName(name, before, layout, after, loc); err == nil {
+			logFiles = append(logFiles, logInfo{t, seq, f.path, f})
+		}
+	}
+
+	sort.Sort(byFormatTime(logFiles))
+
+	return logFiles, nil
+}
+
+// dirEntry is a file found by listFiles.
+type dirEntry struct {
+	path string
+	os.FileInfo
+}
+
+// listFiles returns the files in dir, and in its subdirectories if nested is
+// set.
+func (w *Writer) listFiles(dir string, nested bool) ([]dirEntry, error) {
+	var files []dirEntry
+	if !nested {
+		infos, err := w.fsys().ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if !info.IsDir() {
+				files = append(files, dirEntry{filepath.Join(dir, info.Name()), info})
+			}
+		}
+		return files, nil
+	}
+	err := walkFiles(w.fsys(), dir, func(path string, info os.FileInfo) {
+		files = append(files, dirEntry{path, info})
+	})
+	return files, err
+}
+
+// timeFromName extracts the formatted time from the filename by stripping off
+// what the backup pattern puts before and after it, and parses it with layout
+// in loc.  This prevents someone's filename from confusing time.parse.  The
+// numeric suffix backupName appends to the timestamp on collisions is
+// returned as seq, 0 if there is none.
+func timeFromName(filename, before, layout, after string, loc *time.Location) (time.Time, int, error) {
+	if len(filename) < len(before)+len(after) {
+		return time.Time{}, 0, errors.New("mismatched name")
+	}
+	if !strings.HasPrefix(filename, before) {
+		return time.Time{}, 0, errors.New("mismatched prefix")
+	}
+	if !strings.HasSuffix(filename, after) {
+		return time.Time{}, 0, errors.New("mismatched extension")
+	}
+	ts := filename[len(before) : len(filename)-len(after)]
+	t, err := time.ParseInLocation(layout, ts, loc)
+	if err == nil {
+		return t, 0, nil
+	}
+	i := strings.LastIndexByte(ts, '.')
+	if i < 0 || !isDigits(ts[i+1:]) {
+		return time.Time{}, 0, err
+	}
+	seq, errSeq := strconv.Atoi(ts[i+1:])
+	t, errTime := time.ParseInLocation(layout, ts[:i], loc)
+	if errSeq != nil || seq == 0 || errTime != nil {
+		return time.Time{}, 0, err
+	}
+	return t, seq, nil
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
 // genFilename generates the name of the logfile from the current time.
 func (w *Writer) filename() string {
 	if w.Filename != "" {
@@ -133,7 +1475,143 @@ func (w *Writer) filename() string {
 	return filepath.Join(os.TempDir(), name)
 }
 
+// fsys returns the fileSystem of the logfiles.
+func (w *Writer) fsys() fileSystem {
+	if w.fs == nil {
+		return osFS{}
+	}
+	return w.fs
+}
+
+// fileMode returns the permission bits for new log files.
+func (w *Writer) fileMode() os.FileMode {
+	if w.FileMode == 0 {
+		return 0644
+	}
+	return w.FileMode
+}
+
+// dirMode returns the permission bits for new log directories.
+func (w *Writer) dirMode() os.FileMode {
+	if w.DirMode == 0 {
+		return 0744
+	}
+	return w.DirMode
+}
+
+// location returns the time zone backup timestamps are expressed in.
+func (w *Writer) location() *time.Location {
+	if w.LocalTime {
+		return time.Local
+	}
+	return time.UTC
+}
+
+// max returns the maximum size in bytes of log files.
+func (w *Writer) max() int64 {
+	return int64(w.MaxSize) * int64(megabyte)
+}
+
 // dir returns the directory for the current filename.
 func (w *Writer) dir() string {
 	return filepath.Dir(w.filename())
 }
+
+// removeEmptyDirs removes dir and its parents up to, but excluding, root for as
+// long as they are empty.
+func removeEmptyDirs(fs fileSystem, dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root) && fs.Remove(dir) == nil {
+		dir = filepath.Dir(dir)
+	}
+}
+
+// isCompressed reports whether name has the suffix of a compression
+// algorithm.
+func isCompressed(name string) bool {
+	_, ok := trimCompressSuffix(name)
+	return ok
+}
+
+// compressLogFile compresses the given log file with compress at level,
+// removing the uncompressed log file if successful.  The compressed data is
+// written to a temporary file that is only renamed to dst once it has been
+// completely written and synced, so a crash mid-compression never leaves a
+// truncated dst behind.
+func compressLogFile(fs fileSystem, src, dst string, compress Compressor, level int) (err error) {
+	f, err := fs.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	defer f.Close()
+
+	fi, err := fs.Stat(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat log file")
+	}
+
+	tmp := dst + ".tmp"
+	gzf, err := fs.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		return errors.Wrap(err, "failed to open compressed log file")
+	}
+	defer func() {
+		if err != nil {
+			gzf.Close()
+			fs.Remove(tmp)
+		}
+	}()
+
+	gz, err := compress(gzf, level)
+	if err != nil {
+		return errors.Wrap(err, "failed to start compression")
+	}
+	if _, err = io.Copy(gz, f); err != nil {
+		return errors.Wrap(err, "failed to compress log file")
+	}
+	if err = gz.Close(); err != nil {
+		return errors.Wrap(err, "failed to flush compressed log file")
+	}
+	if err = gzf.Sync(); err != nil {
+		return errors.Wrap(err, "failed to sync compressed log file")
+	}
+	if err = gzf.Close(); err != nil {
+		return errors.Wrap(err, "failed to close compressed log file")
+	}
+	if err = fs.Rename(tmp, dst); err != nil {
+		return errors.Wrap(err, "failed to rename compressed log file")
+	}
+	f.Close()
+	if err = fs.Remove(src); err != nil {
+		return errors.Wrap(err, "failed to remove uncompressed log file")
+	}
+	return nil
+}
+
+// logInfo is a convenience struct to return the filename and its embedded
+// timestamp.
+type logInfo struct {
+	timestamp time.Time
+	// seq orders the backups with the same timestamp.
+	seq  int
+	path string
+	os.FileInfo
+}
+
+// byFormatTime sorts by newest time formatted in the name, then by the
+// collision suffix.
+type byFormatTime []logInfo
+
+func (b byFormatTime) Less(i, j int) bool {
+	if b[i].timestamp.Equal(b[j].timestamp) {
+		return b[i].seq > b[j].seq
+	}
+	return b[i].timestamp.After(b[j].timestamp)
+}
+
+func (b byFormatTime) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+func (b byFormatTime) Len() int {
+	return len(b)
+}
@@ -0,0 +1,89 @@
+package zaphelper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/goleak"
+)
+
+func TestWriterFileDescriptors(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestWriterFileDescriptors", t)
+	defer os.RemoveAll(dir)
+	dir, err := filepath.EvalSymlinks(dir)
+	isNil(err, t)
+
+	w := &Writer{
+		Filename:   filepath.Join(dir, "a.log"),
+		MaxSize:    10,
+		MaxBackups: 3,
+		Compress:   true,
+		BufferSize: 4096,
+	}
+	defer w.Close()
+	for i := 0; i < 50; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("%c.log", 'a'+i%3))
+		isNil(w.SetFilename(name), t)
+		_, err := w.Write([]byte("boo!\n"))
+		isNil(err, t)
+		switch i % 3 {
+		case 0:
+			isNil(w.Rotate(), t)
+		case 1:
+			isNil(w.Reopen(), t)
+		}
+		_, err = w.Write([]byte("boo!\nboo!\n"))
+		isNil(err, t)
+
+		equals(name, strings.Join(openLogfiles(dir, t), ","), t)
+	}
+	// opening over the current file closes it
+	w.mu.Lock()
+	isNil(w.openNew(), t)
+	w.mu.Unlock()
+	equals(w.Filename, strings.Join(openLogfiles(dir, t), ","), t)
+
+	isNil(w.Close(), t)
+	equals("", strings.Join(openFiles(dir, t), ","), t)
+}
+
+// openLogfiles returns the logfiles of TestWriterFileDescriptors the process
+// has a descriptor of, leaving out the backups being compressed.
+func openLogfiles(dir string, t testing.TB) []string {
+	t.Helper()
+	var logfiles []string
+	for _, f := range openFiles(dir, t) {
+		switch filepath.Base(f) {
+		case "a.log", "b.log", "c.log":
+			logfiles = append(logfiles, f)
+		}
+	}
+	return logfiles
+}
+
+// openFiles returns the files in dir the process has a descriptor of.
+func openFiles(dir string, t testing.TB) []string {
+	t.Helper()
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	isNil(err, t)
+	var files []string
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err != nil {
+			// closed since it was listed
+			continue
+		}
+		if strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			files = append(files, target)
+		}
+	}
+	return files
+}
@@ -0,0 +1,76 @@
+package zaphelper
+
+import "os"
+
+// Option configures a Writer created by NewWriter.
+type Option func(*Writer)
+
+// NewWriter returns a Writer that writes to filename, configured by opts.
+// Settings that are not given keep their zero-value defaults.
+func NewWriter(filename string, opts ...Option) *Writer {
+	w := &Writer{Filename: filename}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// OpenWriter is NewWriter, but opens the logfile right away, so that a bad
+// configuration fails at startup rather than on the first write.
+func OpenWriter(filename string, opts ...Option) (*Writer, error) {
+	w := NewWriter(filename, opts...)
+	if err := w.Open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WithMaxSize sets the maximum size in megabytes of the log file before it
+// gets rotated.
+func WithMaxSize(megabytes int) Option {
+	return func(w *Writer) {
+		w.MaxSize = megabytes
+	}
+}
+
+// WithMaxBackups sets the maximum number of old log files to retain.
+func WithMaxBackups(n int) Option {
+	return func(w *Writer) {
+		w.MaxBackups = n
+	}
+}
+
+// WithMaxAge sets the maximum number of days to retain old log files.
+func WithMaxAge(days int) Option {
+	return func(w *Writer) {
+		w.MaxAge = days
+	}
+}
+
+// WithCompress sets whether rotated log files are compressed.
+func WithCompress(compress bool) Option {
+	return func(w *Writer) {
+		w.Compress = compress
+	}
+}
+
+// WithLocalTime sets whether backup timestamps use local time instead of UTC.
+func WithLocalTime(local bool) Option {
+	return func(w *Writer) {
+		w.LocalTime = local
+	}
+}
+
+// WithFileMode sets the permission bits used when creating log files.
+func WithFileMode(mode os.FileMode) Option {
+	return func(w *Writer) {
+		w.FileMode = mode
+	}
+}
+
+// WithDirMode sets the permission bits used when creating the log directory.
+func WithDirMode(mode os.FileMode) Option {
+	return func(w *Writer) {
+		w.DirMode = mode
+	}
+}
@@ -0,0 +1,65 @@
+package zaphelper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewWriterDefaults(t *testing.T) {
+	w := NewWriter("app.log")
+	equals("app.log", w.Filename, t)
+	equals(0, w.MaxSize, t)
+	equals(0, w.MaxBackups, t)
+	equals(0, w.MaxAge, t)
+	equals(false, w.Compress, t)
+	equals(false, w.LocalTime, t)
+	equals(os.FileMode(0644), w.fileMode(), t)
+	equals(os.FileMode(0744), w.dirMode(), t)
+}
+
+func TestNewWriterOptions(t *testing.T) {
+	w := NewWriter("app.log",
+		WithMaxSize(100),
+		WithMaxBackups(3),
+		WithMaxAge(7),
+		WithCompress(true),
+		WithLocalTime(true),
+		WithFileMode(0640),
+		WithDirMode(0750),
+	)
+	equals(100, w.MaxSize, t)
+	equals(3, w.MaxBackups, t)
+	equals(7, w.MaxAge, t)
+	equals(true, w.Compress, t)
+	equals(true, w.LocalTime, t)
+	equals(os.FileMode(0640), w.FileMode, t)
+	equals(os.FileMode(0750), w.DirMode, t)
+}
+
+func TestOpenWriter(t *testing.T) {
+	dir := makeTempDir("TestOpenWriter", t)
+	defer os.RemoveAll(dir)
+
+	// NewWriter stays lazy
+	filename := filepath.Join(dir, "app.log")
+	w := NewWriter(filename)
+	notExist(filename, t)
+	isNil(w.Close(), t)
+
+	w, err := OpenWriter(filename, WithMaxSize(100))
+	isNil(err, t)
+	defer w.Close()
+	existsWithContent(filename, []byte{}, t)
+	equals(100, w.MaxSize, t)
+
+	// a file where the directory should be
+	notDir := filepath.Join(dir, "file")
+	isNil(ioutil.WriteFile(notDir, nil, 0644), t)
+	w, err = OpenWriter(filepath.Join(notDir, "app.log"))
+	if err == nil {
+		t.Fatal("expected an error opening a logfile in a file")
+	}
+	equals(true, w == nil, t)
+}
@@ -1 +1,1598 @@
 package zaphelper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestRotateMovesFileAside(t *testing.T) {
+	dir := makeTempDir("TestRotateMovesFileAside", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	b := []byte("before rotate\n")
+	n, err := w.Write(b)
+	isNil(err, t)
+	equals(len(b), n, t)
+
+	isNil(w.Rotate(), t)
+
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], b, t)
+	existsWithContent(filename, []byte{}, t)
+
+	b2 := []byte("after rotate\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+	existsWithContent(filename, b2, t)
+	existsWithContent(backups[0], b, t)
+}
+
+func TestRotateWithoutFile(t *testing.T) {
+	dir := makeTempDir("TestRotateWithoutFile", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	isNil(w.Rotate(), t)
+	equals(0, len(backupFiles(dir, t)), t)
+	existsWithContent(filename, []byte{}, t)
+}
+
+func TestRotateRenameFails(t *testing.T) {
+	fs := renameFS{osFS{}, func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}}
+
+	dir := makeTempDir("TestRotateRenameFails", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, fs: fs}
+	defer w.Close()
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	if w.Rotate() == nil {
+		t.Fatal("expected the rename error")
+	}
+	equals(0, len(backupFiles(dir, t)), t)
+	existsWithContent(filename, []byte("boo!\n"), t)
+}
+
+func TestBackupNameCollision(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestBackupNameCollision", t)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "app.log")
+	stamp := fakeCurrentTime.UTC().Format(backupTimeFormat)
+
+	pattern, err := parseBackupPattern("")
+	isNil(err, t)
+	first, err := backupName(osFS{}, name, pattern, time.UTC)
+	isNil(err, t)
+	equals(filepath.Join(dir, "app-"+stamp+".log"), first, t)
+	isNil(ioutil.WriteFile(first, []byte("first"), 0644), t)
+
+	second, err := backupName(osFS{}, name, pattern, time.UTC)
+	isNil(err, t)
+	equals(filepath.Join(dir, "app-"+stamp+".1.log"), second, t)
+}
+
+func TestRotateSameSecond(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestRotateSameSecond", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, MaxSize: 10, MaxBackups: 2}
+	defer w.Close()
+
+	// every write rotates the previous one aside, all at the same time
+	for _, s := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := w.Write([]byte(s))
+		isNil(err, t)
+	}
+	stamp := fakeCurrentTime.UTC().Format(backupTimeFormat)
+	isNil(w.PruneBackups(), t)
+	notExist(filepath.Join(dir, "app-"+stamp+".log"), t)
+	existsWithContent(filepath.Join(dir, "app-"+stamp+".1.log"), []byte("second\n"), t)
+	existsWithContent(filepath.Join(dir, "app-"+stamp+".2.log"), []byte("third\n"), t)
+	existsWithContent(filename, []byte("fourth\n"), t)
+
+	backups, err := w.Backups()
+	isNil(err, t)
+	equals(2, len(backups), t)
+	equals(filepath.Join(dir, "app-"+stamp+".2.log"), backups[0].Path, t)
+	equals(filepath.Join(dir, "app-"+stamp+".1.log"), backups[1].Path, t)
+	equals(fakeCurrentTime.Unix(), backups[1].Timestamp.Unix(), t)
+}
+
+func TestRotateSameSecondCompressed(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestRotateSameSecondCompressed", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, Compress: true}
+	defer w.Close()
+
+	stamp := fakeCurrentTime.UTC().Format(backupTimeFormat)
+	for i, s := range []string{"first\n", "second\n"} {
+		_, err := w.Write([]byte(s))
+		isNil(err, t)
+		isNil(w.Rotate(), t)
+		// the first backup is compressed before the second rotation
+		backup := filepath.Join(dir, "app-"+stamp+".log")
+		if i > 0 {
+			backup = filepath.Join(dir, "app-"+stamp+".1.log")
+		}
+		waitFor(func() bool {
+			_, err := os.Stat(backup + compressSuffix)
+			_, plainErr := os.Stat(backup)
+			return err == nil && os.IsNotExist(plainErr)
+		}, t)
+	}
+	equals(0, len(backupFiles(dir, t)), t)
+}
+
+func TestRotateBackupName(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestRotateBackupName", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log")}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+
+	backup := filepath.Join(dir, "app-"+fakeCurrentTime.UTC().Format(backupTimeFormat)+".log")
+	existsWithContent(backup, b, t)
+}
+
+func TestLocalTime(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+	local := time.Local
+	time.Local = time.FixedZone("TestLocalTime", -8*60*60)
+	defer func() { time.Local = local }()
+
+	dir := makeTempDir("TestLocalTime", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), LocalTime: true, MaxAge: 2}
+	defer w.Close()
+
+	// move the file aside directly rather than through Rotate, so that no
+	// mill goroutine races with restoring time.Local.
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	isNil(w.Close(), t)
+	_, err = w.backup()
+	isNil(err, t)
+	backup := filepath.Join(dir, "app-"+fakeCurrentTime.In(time.Local).Format(backupTimeFormat)+".log")
+	existsWithContent(backup, []byte("boo!\n"), t)
+
+	// 44 hours old in local time; misreading the stamp as UTC would make it
+	// look 52 hours old and have it pruned.
+	ts := fakeCurrentTime.Add(-44 * time.Hour).In(time.Local)
+	aging := filepath.Join(dir, "app-"+ts.Format(backupTimeFormat)+".log")
+	isNil(ioutil.WriteFile(aging, []byte("old"), 0644), t)
+
+	files, err := w.oldLogFiles(w.Filename)
+	isNil(err, t)
+	equals(2, len(files), t)
+	if !files[0].timestamp.Equal(fakeCurrentTime.Truncate(time.Millisecond)) {
+		t.Fatalf("expected timestamp %v, got %v", fakeCurrentTime, files[0].timestamp)
+	}
+
+	isNil(w.millRunOnce(), t)
+	existsWithContent(aging, []byte("old"), t)
+}
+
+func TestWriteString(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestWriteString", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, MaxSize: 10}
+	defer w.Close()
+
+	n, err := io.WriteString(w, "boo!\n")
+	isNil(err, t)
+	equals(5, n, t)
+	existsWithContent(filename, []byte("boo!\n"), t)
+
+	_, err = w.WriteString("foooooo!\n")
+	isNil(err, t)
+	existsWithContent(filename, []byte("foooooo!\n"), t)
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], []byte("boo!\n"), t)
+	equals(uint64(14), w.Stats().BytesWritten, t)
+
+	_, err = w.WriteString("this is too long\n")
+	if err == nil {
+		t.Fatal("expected an error for a write over MaxSize")
+	}
+}
+
+func TestWriteStringBuffered(t *testing.T) {
+	dir := makeTempDir("TestWriteStringBuffered", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, BufferSize: 1024, MaxLines: 2}
+	defer w.Close()
+
+	for _, s := range []string{"one\n", "two\n", "three\n"} {
+		_, err := w.WriteString(s)
+		isNil(err, t)
+	}
+	isNil(w.Close(), t)
+	existsWithContent(filename, []byte("three\n"), t)
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], []byte("one\ntwo\n"), t)
+}
+
+func BenchmarkWrite(b *testing.B) {
+	benchmarkWrite(b, func(w *Writer, s string) { w.Write([]byte(s)) })
+}
+
+func BenchmarkWriteString(b *testing.B) {
+	benchmarkWrite(b, func(w *Writer, s string) { w.WriteString(s) })
+}
+
+func benchmarkWrite(b *testing.B, write func(w *Writer, s string)) {
+	dir := makeTempDir("BenchmarkWrite", b)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), BufferSize: 64 * 1024}
+	defer w.Close()
+	s := strings.Repeat("boo!", 32) + "\n"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		write(w, s)
+	}
+}
+
+func TestDirIsFile(t *testing.T) {
+	dir := makeTempDir("TestDirIsFile", t)
+	defer os.RemoveAll(dir)
+
+	logDir := filepath.Join(dir, "logs")
+	isNil(ioutil.WriteFile(logDir, []byte("not a dir"), 0644), t)
+	for _, d := range []string{logDir, filepath.Join(logDir, "app")} {
+		w := &Writer{Filename: filepath.Join(d, "app.log")}
+		_, err := w.Write([]byte("boo!\n"))
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("log directory path %q is a file", logDir)) {
+			t.Fatalf("expected %s to be reported as a file, got %v", logDir, err)
+		}
+	}
+
+	err := InitLogger(logDir, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "is a file") {
+		t.Fatalf("expected %s to be reported as a file, got %v", logDir, err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	dir := makeTempDir("TestSync", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	isNil(w.Sync(), t)
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	isNil(w.Sync(), t)
+	existsWithContent(filename, b, t)
+
+	isNil(w.Close(), t)
+	isNil(w.Sync(), t)
+}
+
+func TestReopenOnMissing(t *testing.T) {
+	dir := makeTempDir("TestReopenOnMissing", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, ReopenOnMissing: true}
+	defer w.Close()
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+
+	isNil(os.Remove(filename), t)
+	b := []byte("after remove\n")
+	_, err = w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, b, t)
+
+	moved := filepath.Join(dir, "app.log.1")
+	isNil(os.Rename(filename, moved), t)
+	b2 := []byte("after rename\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+	existsWithContent(filename, b2, t)
+	existsWithContent(moved, b, t)
+}
+
+func TestReopenOnMissingDisabled(t *testing.T) {
+	dir := makeTempDir("TestReopenOnMissingDisabled", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	isNil(os.Remove(filename), t)
+	_, err = w.Write([]byte("lost\n"))
+	isNil(err, t)
+	notExist(filename, t)
+}
+
+func TestReopen(t *testing.T) {
+	dir := makeTempDir("TestReopen", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+
+	// an external rotator moves the file aside and then signals us
+	moved := filepath.Join(dir, "app.log.1")
+	isNil(os.Rename(filename, moved), t)
+	isNil(w.Reopen(), t)
+	existsWithContent(filename, []byte{}, t)
+
+	b2 := []byte("after reopen\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+	existsWithContent(filename, b2, t)
+	existsWithContent(moved, b, t)
+	equals(0, len(backupFiles(dir, t)), t)
+
+	// reopening an existing file appends to it
+	isNil(w.Reopen(), t)
+	_, err = w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, append(b2, b...), t)
+	equals(int64(len(b2)+len(b)), w.size, t)
+	equals(0, len(backupFiles(dir, t)), t)
+}
+
+func TestSetFilename(t *testing.T) {
+	dir := makeTempDir("TestSetFilename", t)
+	defer os.RemoveAll(dir)
+
+	first := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: first}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+
+	second := filepath.Join(dir, "other", "app.log")
+	isNil(w.SetFilename(second), t)
+	notExist(second, t)
+	equals(second, w.CurrentFilename(), t)
+
+	b2 := []byte("moved\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+	existsWithContent(first, b, t)
+	existsWithContent(second, b2, t)
+}
+
+func TestSetFilenameConcurrentWrites(t *testing.T) {
+	dir := makeTempDir("TestSetFilenameConcurrentWrites", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "a.log"), MaxBackups: 1}
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := w.Write([]byte("boo!\n")); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		isNil(w.SetFilename(filepath.Join(dir, fmt.Sprintf("%d.log", i%2))), t)
+		isNil(w.Rotate(), t)
+	}
+	wg.Wait()
+}
+
+func TestSize(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestSize", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	isNil(ioutil.WriteFile(filename, []byte("old\n"), 0644), t)
+
+	w := &Writer{Filename: filename, MaxSize: 10}
+	defer w.Close()
+	equals(int64(0), w.Size(), t)
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	equals(int64(9), w.Size(), t)
+
+	// rotation starts counting again from the fresh file
+	_, err = w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	equals(int64(5), w.Size(), t)
+
+	isNil(w.Close(), t)
+	equals(int64(0), w.Size(), t)
+}
+
+func TestOnRotate(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestOnRotate", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	var calls [][2]string
+	w := &Writer{Filename: filename, MaxSize: 10}
+	w.OnRotate = func(oldPath, newPath string) {
+		calls = append(calls, [2]string{oldPath, newPath})
+		if len(calls) == 1 {
+			// the lock is not held, so the hook may write through the Writer
+			_, err := w.Write([]byte("rotated\n"))
+			isNil(err, t)
+		}
+	}
+	defer w.Close()
+
+	// nothing to move aside, so no hook
+	isNil(w.Rotate(), t)
+	equals(0, len(calls), t)
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+	equals(1, len(calls), t)
+	existsWithContent(calls[0][0], []byte("boo!\n"), t)
+	equals(filename, calls[0][1], t)
+	existsWithContent(filename, []byte("rotated\n"), t)
+
+	// size based rotation fires the hook as well
+	_, err = w.Write([]byte("foooooo!\n"))
+	isNil(err, t)
+	equals(2, len(calls), t)
+	existsWithContent(calls[1][0], []byte("rotated\n"), t)
+}
+
+func TestBeforeRotate(t *testing.T) {
+	dir := makeTempDir("TestBeforeRotate", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	// nil is a no-op
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+	equals(1, len(backupFiles(dir, t)), t)
+	existsWithContent(filename, []byte{}, t)
+
+	calls := 0
+	w.BeforeRotate = func() error {
+		calls++
+		return fmt.Errorf("not now")
+	}
+	_, err = w.Write([]byte("foo!\n"))
+	isNil(err, t)
+	if err := w.Rotate(); err == nil || !strings.Contains(err.Error(), "not now") {
+		t.Fatalf("expected the hook's error, got %v", err)
+	}
+	equals(1, calls, t)
+	equals(1, len(backupFiles(dir, t)), t)
+	existsWithContent(filename, []byte("foo!\n"), t)
+
+	// the logfile is still open after the aborted rotation
+	_, err = w.Write([]byte("bar!\n"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("foo!\nbar!\n"), t)
+}
+
+func TestFallbackWriter(t *testing.T) {
+	dir := makeTempDir("TestFallbackWriter", t)
+	defer os.RemoveAll(dir)
+
+	// a regular file where the log directory should be can't be written to,
+	// even by root
+	notDir := filepath.Join(dir, "file")
+	isNil(ioutil.WriteFile(notDir, nil, 0644), t)
+
+	var fallback bytes.Buffer
+	w := &Writer{Filename: filepath.Join(notDir, "app.log"), FallbackWriter: &fallback}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	n, err := w.Write(b)
+	isNil(err, t)
+	equals(len(b), n, t)
+	b2 := []byte("again\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+
+	lines := strings.Split(fallback.String(), "\n")
+	equals(4, len(lines), t)
+	if !strings.HasPrefix(lines[0], "zaphelper: can't write to") {
+		t.Fatalf("expected a warning line, got %q", lines[0])
+	}
+	equals("boo!", lines[1], t)
+	equals("again", lines[2], t)
+}
+
+func TestNoFallbackWriter(t *testing.T) {
+	dir := makeTempDir("TestNoFallbackWriter", t)
+	defer os.RemoveAll(dir)
+
+	notDir := filepath.Join(dir, "file")
+	isNil(ioutil.WriteFile(notDir, nil, 0644), t)
+
+	w := &Writer{Filename: filepath.Join(notDir, "app.log")}
+	defer w.Close()
+
+	n, err := w.Write([]byte("boo!\n"))
+	if err == nil {
+		t.Fatal("expected an error writing below a regular file")
+	}
+	equals(0, n, t)
+}
+
+func TestBufferedWrites(t *testing.T) {
+	dir := makeTempDir("TestBufferedWrites", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, BufferSize: 4096}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, []byte{}, t)
+
+	isNil(w.Sync(), t)
+	existsWithContent(filename, b, t)
+
+	// rotation flushes before moving the file aside
+	b2 := []byte("buffered\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], append(b, b2...), t)
+
+	_, err = w.Write(b)
+	isNil(err, t)
+	isNil(w.Close(), t)
+	existsWithContent(filename, b, t)
+}
+
+func TestFlushInterval(t *testing.T) {
+	dir := makeTempDir("TestFlushInterval", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, BufferSize: 4096, FlushInterval: 10 * time.Millisecond}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	waitFor(func() bool {
+		content, err := ioutil.ReadFile(filename)
+		return err == nil && string(content) == string(b)
+	}, t)
+
+	isNil(w.Close(), t)
+	equals(true, w.flushStop == nil, t)
+
+	// writing after Close reopens the file and restarts the flusher
+	_, err = w.Write(b)
+	isNil(err, t)
+	waitFor(func() bool {
+		content, err := ioutil.ReadFile(filename)
+		return err == nil && string(content) == string(b)+string(b)
+	}, t)
+}
+
+func TestCloseStopsWorkers(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestCloseStopsWorkers", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{
+		Filename:      filename,
+		MaxSize:       10,
+		MaxBackups:    2,
+		Compress:      true,
+		BufferSize:    4096,
+		FlushInterval: time.Millisecond,
+	}
+	a := NewAsyncWriter(w, 16)
+	for i := 0; i < 10; i++ {
+		_, err := a.Write([]byte("boo!\n"))
+		isNil(err, t)
+	}
+	isNil(a.Close(), t)
+	equals(0, len(backupFiles(dir, t)), t)
+	backups, err := w.Backups()
+	isNil(err, t)
+	equals(2, len(backups), t)
+	for _, b := range backups {
+		equals(true, b.Compressed, t)
+	}
+	existsWithContent(filename, []byte("boo!\nboo!\n"), t)
+}
+
+func TestMinFreeBytes(t *testing.T) {
+	now := fakeCurrentTime
+	currentTime = func() time.Time { return now }
+	defer func() { currentTime = time.Now }()
+	free := uint64(100)
+	freeSpace = func(dir string) (uint64, error) { return free, nil }
+	defer func() { freeSpace = diskFree }()
+
+	dir := makeTempDir("TestMinFreeBytes", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	var fallback bytes.Buffer
+	w := &Writer{Filename: filename, MinFreeBytes: 50, FallbackWriter: &fallback}
+	defer w.Close()
+
+	_, err := w.Write([]byte("first\n"))
+	isNil(err, t)
+
+	// the space is only checked again after a while
+	free = 10
+	_, err = w.Write([]byte("second\n"))
+	isNil(err, t)
+	now = now.Add(time.Second)
+	_, err = w.Write([]byte("refused\n"))
+	isNil(err, t)
+	_, err = w.Write([]byte("refused again\n"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("first\nsecond\n"), t)
+	equals(1, strings.Count(fallback.String(), "less than MinFreeBytes"), t)
+	if !strings.HasSuffix(fallback.String(), "\nrefused\nrefused again\n") {
+		t.Fatalf("unexpected fallback output %q", fallback.String())
+	}
+
+	// writes resume once there is space again
+	free = 50
+	now = now.Add(time.Second)
+	_, err = w.Write([]byte("third\n"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("first\nsecond\nthird\n"), t)
+
+	// without a FallbackWriter the writes fail
+	w.FallbackWriter = nil
+	free = 0
+	now = now.Add(time.Second)
+	n, err := w.Write([]byte("lost\n"))
+	equals(0, n, t)
+	if err == nil {
+		t.Fatal("expected an error when the disk is full")
+	}
+	existsWithContent(filename, []byte("first\nsecond\nthird\n"), t)
+}
+
+func TestMaxSizeRotates(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestMaxSizeRotates", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, MaxSize: 10}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, b, t)
+	equals(0, len(backupFiles(dir, t)), t)
+
+	b2 := []byte("foooooo!\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+	existsWithContent(filename, b2, t)
+
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], b, t)
+}
+
+func TestMaxSizeExistingFile(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestMaxSizeExistingFile", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	data := []byte("foooooo!")
+	isNil(ioutil.WriteFile(filename, data, 0644), t)
+
+	w := &Writer{Filename: filename, MaxSize: 10}
+	defer w.Close()
+
+	b := []byte("boo!")
+	_, err := w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, b, t)
+
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], data, t)
+}
+
+func TestMaxSizeWriteTooLong(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestMaxSizeWriteTooLong", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), MaxSize: 5}
+	defer w.Close()
+
+	n, err := w.Write([]byte("booooooooooooooo!"))
+	if err == nil {
+		t.Fatal("expected an error for a write longer than MaxSize")
+	}
+	equals(0, n, t)
+}
+
+func TestMaxSizeUnlimited(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestMaxSizeUnlimited", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := w.Write([]byte("boo!\n"))
+		isNil(err, t)
+	}
+	equals(0, len(backupFiles(dir, t)), t)
+}
+
+func TestMaxLines(t *testing.T) {
+	dir := makeTempDir("TestMaxLines", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, MaxLines: 3}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	for i := 0; i < 3; i++ {
+		_, err := w.Write(b)
+		isNil(err, t)
+	}
+	equals(0, len(backupFiles(dir, t)), t)
+
+	// a stacktrace counts for all its lines
+	trace := []byte("panic!\n\tmain.go:1\n")
+	_, err := w.Write(trace)
+	isNil(err, t)
+	existsWithContent(filename, trace, t)
+	equals(1, len(backupFiles(dir, t)), t)
+
+	_, err = w.Write(b)
+	isNil(err, t)
+	_, err = w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, b, t)
+	equals(2, len(backupFiles(dir, t)), t)
+}
+
+func TestMaxLinesExistingFile(t *testing.T) {
+	dir := makeTempDir("TestMaxLinesExistingFile", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	data := []byte("one\ntwo\n")
+	isNil(ioutil.WriteFile(filename, data, 0644), t)
+
+	w := &Writer{Filename: filename, MaxLines: 3}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, []byte("one\ntwo\nboo!\n"), t)
+	_, err = w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, b, t)
+}
+
+func TestMinRotateInterval(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestMinRotateInterval", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, MaxSize: 10, MinRotateInterval: time.Minute}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	for i := 0; i < 3; i++ {
+		_, err := w.Write(b)
+		isNil(err, t)
+	}
+	equals(1, len(backupFiles(dir, t)), t)
+
+	// the next rotation is due within a minute, so it is suppressed
+	for i := 0; i < 2; i++ {
+		_, err := w.Write(b)
+		isNil(err, t)
+	}
+	existsWithContent(filename, []byte("boo!\nboo!\nboo!\n"), t)
+	equals(1, len(backupFiles(dir, t)), t)
+
+	fakeCurrentTime = fakeCurrentTime.Add(time.Minute)
+	defer func() { fakeCurrentTime = fakeCurrentTime.Add(-time.Minute) }()
+	_, err := w.Write(b)
+	isNil(err, t)
+	equals(2, len(backupFiles(dir, t)), t)
+	existsWithContent(filename, b, t)
+}
+
+func TestRotationInterval(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+	start := fakeCurrentTime
+	defer func() { fakeCurrentTime = start }()
+
+	dir := makeTempDir("TestRotationInterval", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, RotationInterval: 24 * time.Hour}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+
+	// still the same day
+	fakeCurrentTime = start.Add(11 * time.Hour)
+	_, err = w.Write(b)
+	isNil(err, t)
+	equals(0, len(backupFiles(dir, t)), t)
+
+	// past midnight
+	fakeCurrentTime = start.Add(12 * time.Hour)
+	b2 := []byte("foo!\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+	existsWithContent(filename, b2, t)
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], []byte("boo!\nboo!\n"), t)
+}
+
+func TestRotationIntervalExistingFile(t *testing.T) {
+	dir := makeTempDir("TestRotationIntervalExistingFile", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	data := []byte("yesterday\n")
+	isNil(ioutil.WriteFile(filename, data, 0644), t)
+	yesterday := time.Now().Add(-24 * time.Hour)
+	isNil(os.Chtimes(filename, yesterday, yesterday), t)
+
+	w := &Writer{Filename: filename, RotationInterval: 24 * time.Hour}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	existsWithContent(filename, b, t)
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], data, t)
+}
+
+func TestTail(t *testing.T) {
+	dir := makeTempDir("TestTail", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), BufferSize: 4096}
+	defer w.Close()
+
+	// nothing before the file exists, nor while it is empty
+	tail, err := w.Tail(100)
+	isNil(err, t)
+	equals(0, len(tail), t)
+	_, err = w.Write(nil)
+	isNil(err, t)
+	tail, err = w.Tail(100)
+	isNil(err, t)
+	equals(0, len(tail), t)
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		_, err := w.Write([]byte(line))
+		isNil(err, t)
+	}
+	for _, tt := range []struct {
+		n    int
+		want string
+	}{
+		{100, "first\nsecond\nthird\n"},
+		{19, "first\nsecond\nthird\n"},
+		{13, "second\nthird\n"},
+		{10, "third\n"},
+		{6, "third\n"},
+		{4, "ird\n"},
+		{0, ""},
+	} {
+		tail, err := w.Tail(tt.n)
+		isNil(err, t)
+		equals(tt.want, string(tail), t)
+	}
+
+	// writing carries on where it was
+	_, err = w.Write([]byte("fourth\n"))
+	isNil(err, t)
+	isNil(w.Close(), t)
+	existsWithContent(w.Filename, []byte("first\nsecond\nthird\nfourth\n"), t)
+}
+
+// noReadFS is a fileSystem that can't open files for reading, as with
+// ExclusiveLock on Windows, where the lock keeps other handles out.
+type noReadFS struct {
+	osFS
+}
+
+func (noReadFS) Open(name string) (file, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: fmt.Errorf("sharing violation")}
+}
+
+func TestTailExclusiveLock(t *testing.T) {
+	dir := makeTempDir("TestTailExclusiveLock", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	isNil(ioutil.WriteFile(filename, []byte("one\ntwo\n"), 0644), t)
+	w := &Writer{Filename: filename, ExclusiveLock: true, MaxLines: 3, fs: noReadFS{}}
+	defer w.Close()
+
+	// the file is read through the locked descriptor
+	_, err := w.Write([]byte("three\n"))
+	isNil(err, t)
+	tail, err := w.Tail(10)
+	isNil(err, t)
+	equals("two\nthree\n", string(tail), t)
+	// as are its lines counted
+	_, err = w.Write([]byte("four\n"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("four\n"), t)
+}
+
+func TestStats(t *testing.T) {
+	megabyte = 1
+	defer func() { megabyte = 1024 * 1024 }()
+
+	dir := makeTempDir("TestStats", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), MaxSize: 100}
+	defer w.Close()
+
+	const goroutines, writes = 8, 50
+	b := []byte("boo!\n")
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if _, err := w.Write(b); err != nil {
+					t.Error(err)
+					return
+				}
+				w.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := w.Stats()
+	equals(uint64(goroutines*writes*len(b)), stats.BytesWritten, t)
+	// 100 bytes fit 20 writes per file
+	equals(uint64(goroutines*writes/20-1), stats.Rotations, t)
+	equals(uint64(0), stats.WriteErrors, t)
+
+	_, err := w.Write(make([]byte, 101))
+	if err == nil {
+		t.Fatal("expected an error for a write longer than MaxSize")
+	}
+	equals(uint64(1), w.Stats().WriteErrors, t)
+}
+
+func TestMaxBackups(t *testing.T) {
+	dir := makeTempDir("TestMaxBackups", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	now := time.Now().UTC()
+	var old []string
+	for i := 1; i <= 3; i++ {
+		old = append(old, makeBackup(dir, now.Add(-time.Duration(i)*time.Hour), t))
+	}
+	unrelated := []string{
+		filepath.Join(dir, "app-foo.log"),
+		filepath.Join(dir, "other.log"),
+	}
+	for _, name := range unrelated {
+		isNil(ioutil.WriteFile(name, []byte("keep"), 0644), t)
+	}
+
+	w := &Writer{Filename: filename, MaxBackups: 2}
+	defer w.Close()
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+
+	// two retained backups plus app-foo.log, which also matches the glob
+	waitFor(func() bool { return len(backupFiles(dir, t)) == 3 }, t)
+
+	existsWithContent(old[0], []byte("old"), t)
+	notExist(old[1], t)
+	notExist(old[2], t)
+	for _, name := range unrelated {
+		existsWithContent(name, []byte("keep"), t)
+	}
+}
+
+func TestMaxBackupsZeroKeepsAll(t *testing.T) {
+	dir := makeTempDir("TestMaxBackupsZeroKeepsAll", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	now := time.Now().UTC()
+	for i := 1; i <= 3; i++ {
+		makeBackup(dir, now.Add(-time.Duration(i)*time.Hour), t)
+	}
+
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+	isNil(w.millRunOnce(), t)
+
+	equals(4, len(backupFiles(dir, t)), t)
+}
+
+func TestMaxAge(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestMaxAge", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), MaxAge: 2}
+	defer w.Close()
+
+	day := 24 * time.Hour
+	fresh := makeBackup(dir, fakeCurrentTime.Add(-day), t)
+	stale := makeBackup(dir, fakeCurrentTime.Add(-3*day), t)
+	isNil(w.millRunOnce(), t)
+	existsWithContent(fresh, []byte("old"), t)
+	notExist(stale, t)
+
+	// simulate the remaining backup aging past the threshold
+	newFakeTime()
+	isNil(w.millRunOnce(), t)
+	notExist(fresh, t)
+}
+
+func TestMaxAgeAndMaxBackups(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestMaxAgeAndMaxBackups", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), MaxBackups: 2, MaxAge: 4}
+	defer w.Close()
+
+	newest := makeBackup(dir, fakeCurrentTime.Add(-time.Hour), t)
+	young := makeBackup(dir, fakeCurrentTime.Add(-2*time.Hour), t)
+	extra := makeBackup(dir, fakeCurrentTime.Add(-3*time.Hour), t)
+	isNil(w.millRunOnce(), t)
+	existsWithContent(newest, []byte("old"), t)
+	existsWithContent(young, []byte("old"), t)
+	notExist(extra, t)
+
+	newFakeTime()
+	newFakeTime()
+	w.MaxBackups = 5
+	isNil(w.millRunOnce(), t)
+	notExist(newest, t)
+	notExist(young, t)
+}
+
+func TestMaxTotalSize(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestMaxTotalSize", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), MaxTotalSize: 25}
+	defer w.Close()
+
+	newest := makeBackup(dir, fakeCurrentTime.Add(-time.Hour), t)
+	isNil(ioutil.WriteFile(newest, make([]byte, 10), 0644), t)
+	middle := makeBackup(dir, fakeCurrentTime.Add(-2*time.Hour), t)
+	isNil(ioutil.WriteFile(middle, make([]byte, 10), 0644), t)
+	big := makeBackup(dir, fakeCurrentTime.Add(-3*time.Hour), t)
+	isNil(ioutil.WriteFile(big, make([]byte, 10), 0644), t)
+	// small enough to fit, but older than a file that doesn't
+	small := makeBackup(dir, fakeCurrentTime.Add(-4*time.Hour), t)
+	isNil(w.millRunOnce(), t)
+	existsWithContent(newest, make([]byte, 10), t)
+	existsWithContent(middle, make([]byte, 10), t)
+	notExist(big, t)
+	notExist(small, t)
+
+	// any rule removes a file
+	w.MaxBackups = 1
+	isNil(w.millRunOnce(), t)
+	existsWithContent(newest, make([]byte, 10), t)
+	notExist(middle, t)
+}
+
+func TestMaxTotalSizeCompressedSibling(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestMaxTotalSizeCompressedSibling", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), MaxTotalSize: 25}
+	defer w.Close()
+
+	// left behind by an interrupted compression, only the .gz counts
+	newest := makeBackup(dir, fakeCurrentTime.Add(-time.Hour), t)
+	isNil(ioutil.WriteFile(newest, make([]byte, 10), 0644), t)
+	isNil(ioutil.WriteFile(newest+compressSuffix, make([]byte, 10), 0644), t)
+	older := makeBackup(dir, fakeCurrentTime.Add(-2*time.Hour), t)
+	isNil(ioutil.WriteFile(older, make([]byte, 10), 0644), t)
+	isNil(w.millRunOnce(), t)
+	existsWithContent(newest, make([]byte, 10), t)
+	existsWithContent(newest+compressSuffix, make([]byte, 10), t)
+	existsWithContent(older, make([]byte, 10), t)
+}
+
+func TestBackups(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestBackups", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	older := makeBackup(dir, fakeCurrentTime.Add(-2*time.Hour), t)
+	newer := makeBackup(dir, fakeCurrentTime.Add(-time.Hour), t)
+	isNil(os.Rename(newer, newer+compressSuffix), t)
+	newer += compressSuffix
+	// neither the logfile nor unrelated files are backups
+	isNil(ioutil.WriteFile(filename, []byte("current"), 0644), t)
+	isNil(ioutil.WriteFile(filepath.Join(dir, "app-notatime.log"), []byte("foo"), 0644), t)
+	isNil(ioutil.WriteFile(filepath.Join(dir, "other.log"), []byte("foo"), 0644), t)
+
+	backups, err := w.Backups()
+	isNil(err, t)
+	equals(2, len(backups), t)
+	equals(newer, backups[0].Path, t)
+	equals(true, backups[0].Timestamp.Equal(fakeCurrentTime.Add(-time.Hour)), t)
+	equals(int64(3), backups[0].Size, t)
+	equals(true, backups[0].Compressed, t)
+	equals(older, backups[1].Path, t)
+	equals(true, backups[1].Timestamp.Equal(fakeCurrentTime.Add(-2*time.Hour)), t)
+	equals(false, backups[1].Compressed, t)
+}
+
+func TestPruneBackups(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestPruneBackups", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log")}
+	defer w.Close()
+
+	newest := makeBackup(dir, fakeCurrentTime.Add(-time.Hour), t)
+	older := makeBackup(dir, fakeCurrentTime.Add(-2*time.Hour), t)
+	isNil(w.PruneBackups(), t)
+	existsWithContent(older, []byte("old"), t)
+
+	w.MaxBackups = 1
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			if _, err := w.Write([]byte("boo!\n")); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	isNil(w.PruneBackups(), t)
+	<-done
+	existsWithContent(newest, []byte("old"), t)
+	notExist(older, t)
+}
+
+func TestOnRemove(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestOnRemove", t)
+	defer os.RemoveAll(dir)
+
+	var removed []string
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), MaxBackups: 2, MaxAge: 1}
+	w.OnRemove = func(path string) {
+		// the lock is not held, so the hook may write through the Writer
+		_, err := w.Write([]byte("removed " + filepath.Base(path) + "\n"))
+		isNil(err, t)
+		removed = append(removed, path)
+	}
+	defer w.Close()
+
+	kept := makeBackup(dir, fakeCurrentTime.Add(-time.Hour), t)
+	aged := makeBackup(dir, fakeCurrentTime.Add(-48*time.Hour), t)
+	extra1 := makeBackup(dir, fakeCurrentTime.Add(-72*time.Hour), t)
+	extra2 := makeBackup(dir, fakeCurrentTime.Add(-96*time.Hour), t)
+	isNil(w.PruneBackups(), t)
+	equals(strings.Join([]string{aged, extra1, extra2}, ","), strings.Join(removed, ","), t)
+	existsWithContent(kept, []byte("old"), t)
+	notExist(aged, t)
+
+	// nothing left to remove
+	removed = nil
+	isNil(w.PruneBackups(), t)
+	equals(0, len(removed), t)
+}
+
+func TestSubdirLayout(t *testing.T) {
+	currentTime = fakeTime
+	defer func() { currentTime = time.Now }()
+
+	dir := makeTempDir("TestSubdirLayout", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, SubdirLayout: "2006/01/02", MaxBackups: 1}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	isNil(w.Close(), t)
+	backup, err := w.backup()
+	isNil(err, t)
+	equals(filepath.Join(dir, fakeTime().Format("2006/01/02"), "app-"+fakeTime().Format(backupTimeFormat)+".log"), backup, t)
+	existsWithContent(backup, b, t)
+
+	// cleanup finds backups in the subdirectories, and removes the emptied ones
+	then := fakeCurrentTime.AddDate(-1, 0, 0)
+	olderDir := filepath.Join(dir, then.Format("2006/01/02"))
+	isNil(os.MkdirAll(olderDir, 0755), t)
+	older := makeBackup(olderDir, then, t)
+	backups, err := w.Backups()
+	isNil(err, t)
+	equals(2, len(backups), t)
+	isNil(w.millRunOnce(), t)
+	existsWithContent(backup, b, t)
+	notExist(older, t)
+	notExist(filepath.Join(dir, then.Format("2006")), t)
+}
+
+func TestCompressOnRotate(t *testing.T) {
+	dir := makeTempDir("TestCompressOnRotate", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, Compress: true}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+
+	var compressed []string
+	waitFor(func() bool {
+		compressed, _ = filepath.Glob(filepath.Join(dir, "app-*.log"+compressSuffix))
+		return len(compressed) == 1 && len(backupFiles(dir, t)) == 0
+	}, t)
+	existsWithGzipContent(compressed[0], b, t)
+	existsWithContent(filename, []byte{}, t)
+
+	tmps, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	isNil(err, t)
+	equals(0, len(tmps), t)
+}
+
+func TestMaxBackupsCountsCompressed(t *testing.T) {
+	dir := makeTempDir("TestMaxBackupsCountsCompressed", t)
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Filename: filepath.Join(dir, "app.log"), MaxBackups: 1}
+	defer w.Close()
+
+	now := time.Now()
+	newest := makeBackup(dir, now.Add(-time.Hour), t)
+	older := makeBackup(dir, now.Add(-2*time.Hour), t)
+	isNil(os.Rename(older, older+compressSuffix), t)
+	// a leftover from an interrupted compression is never treated as a backup
+	partial := newest + compressSuffix + ".tmp"
+	isNil(ioutil.WriteFile(partial, []byte("partial"), 0644), t)
+
+	isNil(w.millRunOnce(), t)
+	existsWithContent(newest, []byte("old"), t)
+	notExist(older+compressSuffix, t)
+	existsWithContent(partial, []byte("partial"), t)
+}
+
+// fakeCurrentTime is the fake "current time" used by tests that swap out
+// currentTime.
+var fakeCurrentTime = time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestExclusiveLock(t *testing.T) {
+	dir := makeTempDir("TestExclusiveLock", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	first := &Writer{Filename: filename, ExclusiveLock: true}
+	defer first.Close()
+	_, err := first.Write([]byte("boo!\n"))
+	isNil(err, t)
+
+	second := &Writer{Filename: filename, ExclusiveLock: true}
+	defer second.Close()
+	_, err = second.Write([]byte("foo!\n"))
+	if err == nil {
+		t.Fatal("expected an error writing to a locked logfile")
+	}
+	existsWithContent(filename, []byte("boo!\n"), t)
+
+	// nor does truncating on open wipe it
+	truncating := &Writer{Filename: filename, ExclusiveLock: true, TruncateOnOpen: true}
+	defer truncating.Close()
+	_, err = truncating.Write([]byte("foo!\n"))
+	if err == nil {
+		t.Fatal("expected an error writing to a locked logfile")
+	}
+	existsWithContent(filename, []byte("boo!\n"), t)
+
+	// the lock goes away with the first writer
+	isNil(first.Close(), t)
+	_, err = second.Write([]byte("foo!\n"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("boo!\nfoo!\n"), t)
+}
+
+func TestTruncateOnOpen(t *testing.T) {
+	dir := makeTempDir("TestTruncateOnOpen", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	isNil(ioutil.WriteFile(filename, []byte("previous run\n"), 0644), t)
+
+	w := &Writer{Filename: filename}
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+	isNil(w.Close(), t)
+	existsWithContent(filename, []byte("previous run\nboo!\n"), t)
+
+	w = &Writer{Filename: filename, TruncateOnOpen: true}
+	defer w.Close()
+	_, err = w.Write([]byte("fresh\n"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("fresh\n"), t)
+	equals(int64(6), w.Size(), t)
+
+	// only the first open truncates
+	isNil(w.Close(), t)
+	_, err = w.Write([]byte("again\n"))
+	isNil(err, t)
+	existsWithContent(filename, []byte("fresh\nagain\n"), t)
+	equals(0, len(backupFiles(dir, t)), t)
+}
+
+func fakeTime() time.Time {
+	return fakeCurrentTime
+}
+
+// newFakeTime moves the fake clock forward two days.
+func newFakeTime() {
+	fakeCurrentTime = fakeCurrentTime.Add(2 * 24 * time.Hour)
+}
+
+// makeBackup creates a backup of app.log in dir stamped with the given time.
+func makeBackup(dir string, ts time.Time, t testing.TB) string {
+	t.Helper()
+	name := filepath.Join(dir, "app-"+ts.UTC().Format(backupTimeFormat)+".log")
+	isNil(ioutil.WriteFile(name, []byte("old"), 0644), t)
+	return name
+}
+
+// makeTempDir creates a directory with a semi-unique name in the OS temp
+// directory.
+func makeTempDir(name string, t testing.TB) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", name)
+	isNil(err, t)
+	return dir
+}
+
+// backupFiles returns the rotated backups of app.log found in dir.
+func backupFiles(dir string, t testing.TB) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	isNil(err, t)
+	return matches
+}
+
+// existsWithContent checks that the given file exists and has the correct
+// content.
+func existsWithContent(path string, content []byte, t testing.TB) {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	isNil(err, t)
+	if string(b) != string(content) {
+		t.Fatalf("%s: expected content %q, got %q", path, content, b)
+	}
+}
+
+// existsWithGzipContent checks that the given file exists and decompresses to
+// the given content.
+func existsWithGzipContent(path string, content []byte, t testing.TB) {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	isNil(err, t)
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	isNil(err, t)
+	defer gz.Close()
+	b, err = ioutil.ReadAll(gz)
+	isNil(err, t)
+	if string(b) != string(content) {
+		t.Fatalf("%s: expected content %q, got %q", path, content, b)
+	}
+}
+
+// notExist checks that the given file does not exist.
+func notExist(path string, t testing.TB) {
+	_, err := os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Helper()
+		t.Fatalf("expected %s to not exist, got err %v", path, err)
+	}
+}
+
+// waitFor polls cond until it returns true, failing the test if that doesn't
+// happen in a reasonable amount of time.  It is used to wait on the mill
+// goroutine.
+func waitFor(cond func() bool, t testing.TB) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+func isNil(err error, t testing.TB) {
+	if err != nil {
+		t.Helper()
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func equals(exp, act interface{}, t testing.TB) {
+	if exp != act {
+		t.Helper()
+		t.Fatalf("expected %v (%T), got %v (%T)", exp, exp, act, act)
+	}
+}
@@ -0,0 +1,113 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package zaphelper
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileAndDirMode(t *testing.T) {
+	dir := makeTempDir("TestFileAndDirMode", t)
+	defer os.RemoveAll(dir)
+
+	mask := syscall.Umask(0)
+	defer syscall.Umask(mask)
+
+	logDir := filepath.Join(dir, "logs")
+	filename := filepath.Join(logDir, "app.log")
+	w := &Writer{Filename: filename, FileMode: 0640, DirMode: 0750}
+	defer w.Close()
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+
+	info, err := os.Stat(logDir)
+	isNil(err, t)
+	equals(os.FileMode(0750), info.Mode().Perm(), t)
+	info, err = os.Stat(filename)
+	isNil(err, t)
+	equals(os.FileMode(0640), info.Mode().Perm(), t)
+}
+
+func TestDefaultFileMode(t *testing.T) {
+	dir := makeTempDir("TestDefaultFileMode", t)
+	defer os.RemoveAll(dir)
+
+	mask := syscall.Umask(0)
+	defer syscall.Umask(mask)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename}
+	defer w.Close()
+
+	_, err := w.Write([]byte("boo!\n"))
+	isNil(err, t)
+
+	info, err := os.Stat(filename)
+	isNil(err, t)
+	equals(os.FileMode(0644), info.Mode().Perm(), t)
+}
+
+func TestLinkName(t *testing.T) {
+	dir := makeTempDir("TestLinkName", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	link := filepath.Join(dir, "current.log")
+	w := &Writer{Filename: filename, LinkName: link}
+	defer w.Close()
+
+	b := []byte("boo!\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	target, err := os.Readlink(link)
+	isNil(err, t)
+	equals("app.log", target, t)
+	existsWithContent(link, b, t)
+
+	isNil(w.Rotate(), t)
+	b2 := []byte("foo!\n")
+	_, err = w.Write(b2)
+	isNil(err, t)
+	existsWithContent(link, b2, t)
+
+	other := filepath.Join(dir, "other", "app.log")
+	isNil(w.SetFilename(other), t)
+	_, err = w.Write(b)
+	isNil(err, t)
+	target, err = os.Readlink(link)
+	isNil(err, t)
+	equals(other, target, t)
+	existsWithContent(link, b, t)
+	notExist(link+".tmp", t)
+}
+
+func TestRotateAcrossDevices(t *testing.T) {
+	renames := 0
+	fs := renameFS{osFS{}, func(oldpath, newpath string) error {
+		renames++
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}}
+
+	dir := makeTempDir("TestRotateAcrossDevices", t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	w := &Writer{Filename: filename, fs: fs}
+	defer w.Close()
+
+	b := []byte("before rotate\n")
+	_, err := w.Write(b)
+	isNil(err, t)
+	isNil(w.Rotate(), t)
+	equals(1, renames, t)
+
+	backups := backupFiles(dir, t)
+	equals(1, len(backups), t)
+	existsWithContent(backups[0], b, t)
+	existsWithContent(filename, []byte{}, t)
+}
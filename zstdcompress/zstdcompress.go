@@ -0,0 +1,32 @@
+// Package zstdcompress registers the "zstd" compression algorithm for the
+// backups of zaphelper Writers, written with the .zst suffix.  It is enabled
+// by importing it for its side effect:
+//
+//	import _ "github.com/yeeuu/zaphelper/zstdcompress"
+//
+// and setting the Writer's CompressAlgorithm to "zstd".  CompressLevel is a
+// zstd level from 1 to 22, mapped to the closest level the encoder supports.
+package zstdcompress
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/yeeuu/zaphelper"
+)
+
+// Suffix is appended to the names of backups compressed with zstd.
+const Suffix = ".zst"
+
+func init() {
+	zaphelper.RegisterCompressor("zstd", Suffix, compressor)
+}
+
+// compressor is the zaphelper.Compressor of zstd.
+func compressor(w io.Writer, level int) (io.WriteCloser, error) {
+	var opts []zstd.EOption
+	if level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	return zstd.NewWriter(w, opts...)
+}
@@ -0,0 +1,67 @@
+package zstdcompress
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/yeeuu/zaphelper"
+)
+
+func TestCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestCompress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := &zaphelper.Writer{
+		Filename:          filepath.Join(dir, "app.log"),
+		Compress:          true,
+		CompressAlgorithm: "zstd",
+		CompressLevel:     19,
+	}
+	defer w.Close()
+	content := []byte(`{"level":"info","message":"hello"}` + "\n")
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var backups []zaphelper.BackupInfo
+	for i := 0; i < 100; i++ {
+		if backups, err = w.Backups(); err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) == 1 && backups[0].Compressed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(backups) != 1 || !backups[0].Compressed || filepath.Ext(backups[0].Path) != Suffix {
+		t.Fatalf("expected a compressed backup, got %+v", backups)
+	}
+
+	f, err := os.Open(backups[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	b, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(content) {
+		t.Fatalf("expected %q, got %q", content, b)
+	}
+}